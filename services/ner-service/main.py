@@ -43,6 +43,16 @@ class JAXNERModel:
         logger.info("✓ JAX NER Model initialized with %d pattern groups", len(self.patterns))
     
     def detect_entities(self, text: str, domain: str = 'general') -> List[Dict]:
+        self.entity_counters = {}
+        return self._detect(text, domain)
+    
+    def detect_entities_batch(self, texts: List[str], domain: str = 'general') -> List[List[Dict]]:
+        # Counters are shared across the batch so every token is unique
+        # within the request, not just within one text.
+        self.entity_counters = {}
+        return [self._detect(text, domain) for text in texts]
+    
+    def _detect(self, text: str, domain: str) -> List[Dict]:
         start_time = time.time()
         
         active_patterns = self.patterns.copy()
@@ -52,7 +62,6 @@ class JAXNERModel:
             active_patterns.update(self.legal_patterns)
         
         entities = []
-        self.entity_counters = {}
         
         for entity_type, pattern in active_patterns.items():
             for match in re.finditer(pattern, text):
@@ -101,6 +110,25 @@ def detect():
         logger.error("Detection failed: %s", str(e))
         return jsonify({'error': 'Detection failed'}), 500
 
+@app.route('/detect/batch', methods=['POST'])
+def detect_batch():
+    data = request.json or {}
+    texts = data.get('texts')
+    domain = data.get('domain', 'general')
+    
+    if not isinstance(texts, list) or not all(isinstance(t, str) for t in texts):
+        return jsonify({'error': 'texts must be a list of strings'}), 400
+    
+    try:
+        results = ner_model.detect_entities_batch(texts, domain)
+        return jsonify({
+            'results': [{'entities': entities, 'count': len(entities)} for entities in results],
+            'domain': domain
+        })
+    except Exception as e:
+        logger.error("Batch detection failed: %s", str(e))
+        return jsonify({'error': 'Detection failed'}), 500
+
 @app.route('/metrics', methods=['GET'])
 def metrics():
     return jsonify({
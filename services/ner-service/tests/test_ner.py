@@ -70,6 +70,18 @@ class TestNERModel:
     def test_empty_text(self, ner_model):
         entities = ner_model.detect_entities("")
         assert len(entities) == 0
+    
+    def test_batch_tokens_unique_across_texts(self, ner_model):
+        results = ner_model.detect_entities_batch([
+            "Email: first@example.com",
+            "",
+            "Email: second@example.com",
+        ])
+        assert len(results) == 3
+        assert results[1] == []
+        assert results[0][0]['token'] == '[EMAIL_001]'
+        assert results[2][0]['token'] == '[EMAIL_002]'
+        assert results[2][0]['position'] == 7
 
 class TestNERAPI:
     def test_health_endpoint(self, client):
@@ -102,6 +114,21 @@ class TestNERAPI:
         })
         assert response.status_code == 400
     
+    def test_detect_batch_endpoint(self, client):
+        response = client.post('/detect/batch', json={
+            'texts': ['My email is test@example.com', 'SSN 123-45-6789'],
+            'domain': 'general'
+        })
+        assert response.status_code == 200
+        data = response.get_json()
+        assert len(data['results']) == 2
+        assert data['results'][0]['entities'][0]['type'] == 'EMAIL'
+        assert data['results'][1]['entities'][0]['type'] == 'SSN'
+    
+    def test_detect_batch_invalid_texts(self, client):
+        response = client.post('/detect/batch', json={'texts': 'not a list'})
+        assert response.status_code == 400
+    
     def test_metrics_endpoint(self, client):
         response = client.get('/metrics')
         assert response.status_code == 200
@@ -2,61 +2,144 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/saferoute/proxy/internal/auth"
+	"github.com/saferoute/proxy/internal/clientip"
 	"github.com/saferoute/proxy/internal/config"
 	"github.com/saferoute/proxy/internal/handlers"
+	"github.com/saferoute/proxy/internal/health"
+	"github.com/saferoute/proxy/internal/logging"
 	"github.com/saferoute/proxy/internal/middleware"
+	"github.com/saferoute/proxy/internal/policy"
+	"github.com/saferoute/proxy/internal/quota"
 	"github.com/saferoute/proxy/internal/services"
+	"github.com/saferoute/proxy/internal/tracing"
 )
 
 func main() {
 	cfg := config.LoadFromEnv()
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
 
-	nerClient := services.NewNERClient(cfg.NERServiceURL)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.ServiceName)
+	if err != nil {
+		fatal("Invalid tracing configuration", err)
+	}
+
+	breakers := make(map[string]*services.CircuitBreaker)
+	breaker := func(name string) *services.CircuitBreaker {
+		b := services.NewCircuitBreaker(name, services.BreakerSettings{
+			FailureThreshold: cfg.Breaker.FailureThreshold,
+			OpenTimeout:      cfg.Breaker.OpenTimeout,
+			HalfOpenMaxCalls: cfg.Breaker.HalfOpenMaxCalls,
+		})
+		breakers[name] = b
+		return b
+	}
+
+	nerClient, err := newNERService(cfg, breaker)
+	if err != nil {
+		fatal("Invalid NER configuration", err)
+	}
 	vaultClient := services.NewVaultClient(cfg.VaultServiceURL)
-	llmClient := services.NewLLMClient(cfg.LLMProviderURL, cfg.LLMAPIKey)
+	vault := services.NewVaultBreaker(vaultClient, breaker("vault"))
+	llmRouter, llmClients, err := newLLMRouter(cfg, breaker)
+	if err != nil {
+		fatal("Invalid LLM configuration", err)
+	}
+	llmClient := newFailoverLLM(cfg, llmRouter)
+
+	entityPolicy := policy.Default()
+	if cfg.PolicyFile != "" {
+		if entityPolicy, err = policy.Load(cfg.PolicyFile); err != nil {
+			fatal("Invalid entity policy", err)
+		}
+	}
+
+	redisOptions, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		fatal("Invalid REDIS_URL", err)
+	}
+	redisClient := redis.NewClient(redisOptions)
+	defer redisClient.Close()
+
+	authenticate, err := newAuthMiddleware(cfg, redisClient)
+	if err != nil {
+		fatal("Invalid auth configuration", err)
+	}
+
+	clientIPs, err := clientip.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		fatal("Invalid TRUSTED_PROXIES", err)
+	}
+
+	if err := validateRateLimit(cfg.RateLimit); err != nil {
+		fatal("Invalid rate limit configuration", err)
+	}
+
+	readiness := newReadinessChecker(cfg, vaultClient, llmClients, redisClient, breakers)
+
+	quotas := quota.NewManager(redisClient, quota.Limits{
+		Daily:   cfg.Quota.DailyTokens,
+		Monthly: cfg.Quota.MonthlyTokens,
+	})
 
-	proxyHandler := handlers.NewProxyHandler(nerClient, vaultClient, llmClient)
+	proxyHandler := handlers.NewProxyHandler(nerClient, vault, llmClient,
+		handlers.WithPolicy(entityPolicy),
+		handlers.WithQuota(quotas, cfg.Quota.DefaultCompletionTokens),
+		handlers.WithEmbeddings([]byte(cfg.Embeddings.HashKey), cfg.Embeddings.NERBatchSize),
+	)
+
+	ipRateLimit, keyedRateLimit := newRateLimits(cfg.RateLimit, redisClient)
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/v1/chat/completions", proxyHandler.HandleChatCompletion)
+	mux.HandleFunc("/v1/messages", proxyHandler.HandleMessages)
+	mux.HandleFunc("/v1/embeddings", proxyHandler.HandleEmbeddings)
 	mux.HandleFunc("/v1/anonymize", proxyHandler.HandleAnonymize)
 	mux.HandleFunc("/v1/restore", proxyHandler.HandleRestore)
 
 	mux.HandleFunc("/health", handlers.HealthCheck)
-	mux.HandleFunc("/ready", handlers.ReadinessCheck)
+	mux.HandleFunc("/ready", handlers.ReadinessCheck(readiness))
 	mux.Handle("/metrics", promhttp.Handler())
 
 	handler := middleware.Chain(
-		mux,
+		middleware.Routes(mux),
 		middleware.RequestID,
+		middleware.Tracing,
+		middleware.Metrics,
+		clientIPs.Middleware,
 		middleware.Logger,
-		middleware.CORS,
-		middleware.RateLimit(100, 1*time.Second),
+		middleware.CORS(cfg.CORSAllowedOrigins),
+		ipRateLimit,
+		authenticate,
+		keyedRateLimit,
 		middleware.Recovery,
-		middleware.Metrics,
 	)
 
 	srv := &http.Server{
 		Addr:         ":" + cfg.Port,
 		Handler:      handler,
 		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: writeTimeout(cfg),
 		IdleTimeout:  60 * time.Second,
 	}
 
 	go func() {
-		log.Printf("SafeRoute Proxy listening on %s", srv.Addr)
+		slog.Info("SafeRoute Proxy listening", "addr", srv.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
+			fatal("Server failed", err)
 		}
 	}()
 
@@ -64,13 +147,224 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced shutdown:", err)
+		fatal("Server forced shutdown", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
 	}
 
-	log.Println("Server gracefully stopped")
+	slog.Info("Server gracefully stopped")
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newLLMRouter builds one LLMClient per configured provider, each behind its
+// own circuit breaker, and routes models to them, falling back to the
+// LLM_PROVIDER client for unmatched models. The bare clients are also
+// returned by provider name.
+func newLLMRouter(cfg *config.Config, breaker func(string) *services.CircuitBreaker) (*services.LLMRouter, map[string]*services.LLMClient, error) {
+	clients := make(map[string]*services.LLMClient)
+	for _, p := range cfg.LLMProviders {
+		client, err := services.NewLLMClient(p.Kind, p.BaseURL, p.APIKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("provider %q: %w", p.Name, err)
+		}
+		clients[p.Name] = client
+	}
+
+	guarded := make(map[string]services.LLMService, len(clients))
+	for name, client := range clients {
+		guarded[name] = services.NewLLMBreaker(client, breaker("llm:"+name))
+	}
+
+	router := services.NewLLMRouter(guarded[cfg.LLMProvider])
+	for _, route := range cfg.LLMRoutes {
+		client, ok := guarded[route.Provider]
+		if !ok {
+			slog.Warn("Skipping LLM route: provider is not configured", "pattern", route.Pattern, "provider", route.Provider)
+			continue
+		}
+		router.Handle(route.Pattern, client)
+	}
+
+	return router, clients, nil
+}
+
+// writeTimeout leaves room for the NER and vault calls around the LLM
+// call, whose retries LLM_RETRY_BUDGET bounds, so a completed answer is not
+// cut off by the server. Without a budget there is no write timeout either.
+// Streams extend their own deadline as they write.
+func writeTimeout(cfg *config.Config) time.Duration {
+	const pipelineOverhead = 30 * time.Second
+	if cfg.LLMRetry.Budget <= 0 {
+		return 0
+	}
+	return cfg.LLMRetry.Budget + pipelineOverhead
+}
+
+// newFailoverLLM retries the routed model and then each LLM_FALLBACK_MODELS
+// entry, all resolved through the same router.
+func newFailoverLLM(cfg *config.Config, router *services.LLMRouter) *services.FailoverLLM {
+	policy := services.RetryPolicy{
+		MaxAttempts:    cfg.LLMRetry.MaxAttempts,
+		InitialBackoff: cfg.LLMRetry.InitialBackoff,
+		MaxBackoff:     cfg.LLMRetry.MaxBackoff,
+		Budget:         cfg.LLMRetry.Budget,
+	}
+
+	var fallbacks []services.LLMTarget
+	for _, model := range cfg.LLMRetry.FallbackModels {
+		fallbacks = append(fallbacks, services.LLMTarget{Name: model, Service: router, Model: model})
+	}
+
+	return services.NewFailoverLLM(policy, router, fallbacks...)
+}
+
+// newNERService builds the detectors listed in NER_DETECTORS and runs them
+// together, filtering by the configured confidence thresholds. The remote
+// service sits behind a circuit breaker; local detectors cannot time out.
+// NER_ON_DETECTOR_FAILURE decides whether one failing detector fails the
+// whole detection.
+func newNERService(cfg *config.Config, breaker func(string) *services.CircuitBreaker) (services.NERService, error) {
+	var detectors []services.NERService
+	for _, name := range cfg.NERDetectors {
+		switch name {
+		case "remote":
+			detectors = append(detectors, services.NewNERBreaker(services.NewNERClient(cfg.NERServiceURL), breaker("ner")))
+		case "regex":
+			detectors = append(detectors, services.NewRegexNER())
+		case "dictionary":
+			if cfg.NERDictionaryFile == "" {
+				return nil, fmt.Errorf("NER_DICTIONARY_FILE is required for the dictionary detector")
+			}
+			dictionary, err := services.LoadDictionaryNER(cfg.NERDictionaryFile)
+			if err != nil {
+				return nil, err
+			}
+			detectors = append(detectors, dictionary)
+		default:
+			return nil, fmt.Errorf("unsupported NER detector %q", name)
+		}
+	}
+
+	composite := services.NewCompositeNER(cfg.NERThresholds, detectors...)
+	switch cfg.NEROnDetectorFailure {
+	case "fail":
+	case "partial":
+		composite.AllowPartialResults()
+	default:
+		return nil, fmt.Errorf("unsupported NER_ON_DETECTOR_FAILURE %q", cfg.NEROnDetectorFailure)
+	}
+
+	return composite, nil
+}
+
+// newAuthMiddleware authenticates /v1/ requests against the key store chosen
+// by AUTH_MODE. "disabled" lets every caller through and is meant only for
+// trusted networks.
+func newAuthMiddleware(cfg *config.Config, redisClient *redis.Client) (middleware.Middleware, error) {
+	switch cfg.AuthMode {
+	case "file":
+		if cfg.AuthKeysFile == "" {
+			return nil, fmt.Errorf("AUTH_KEYS_FILE is required when AUTH_MODE is file")
+		}
+		store, err := auth.LoadKeyFile(cfg.AuthKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		return middleware.Authenticate(store), nil
+	case "redis":
+		return middleware.Authenticate(auth.NewRedisKeyStore(redisClient)), nil
+	case "disabled":
+		slog.Warn("Authentication is disabled; every caller can use /v1/ routes")
+		return func(next http.Handler) http.Handler { return next }, nil
+	default:
+		return nil, fmt.Errorf("unsupported AUTH_MODE %q", cfg.AuthMode)
+	}
+}
+
+// newReadinessChecker checks the remote NER service (when it is one of the
+// detectors), the vault, every LLM provider and Redis, and reports the state
+// of the circuit breaker named after each check.
+func newReadinessChecker(cfg *config.Config, vault *services.VaultClient, llmClients map[string]*services.LLMClient, redisClient *redis.Client, breakers map[string]*services.CircuitBreaker) *health.Checker {
+	critical := make(map[string]bool)
+	for _, name := range cfg.Readiness.Critical {
+		critical[name] = true
+	}
+
+	var checks []health.Check
+	if slices.Contains(cfg.NERDetectors, "remote") {
+		checks = append(checks, health.Check{
+			Name:     "ner",
+			Critical: critical["ner"],
+			Timeout:  cfg.Readiness.Timeout,
+			Probe:    services.NewNERClient(cfg.NERServiceURL).Health,
+		})
+	}
+	checks = append(checks, health.Check{
+		Name:     "vault",
+		Critical: critical["vault"],
+		Timeout:  cfg.Readiness.Timeout,
+		Probe:    vault.Health,
+	})
+	for _, name := range slices.Sorted(maps.Keys(llmClients)) {
+		checks = append(checks, health.Check{
+			Name:     "llm:" + name,
+			Critical: critical["llm"],
+			Timeout:  cfg.Readiness.LLMTimeout,
+			Probe:    llmClients[name].Health,
+		})
+	}
+	checks = append(checks, health.Check{
+		Name:     "redis",
+		Critical: critical["redis"],
+		Timeout:  cfg.Readiness.Timeout,
+		Probe: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		},
+	})
+
+	for i := range checks {
+		if b, ok := breakers[checks[i].Name]; ok {
+			checks[i].Circuit = func() string { return b.State().String() }
+		}
+	}
+
+	return health.NewChecker(cfg.Readiness.CacheTTL, checks...)
+}
+
+// newRateLimits returns the limiter that runs before authentication, per
+// client IP, and the one after it, per RATE_LIMIT_KEY. Keying by IP needs
+// only the first.
+func newRateLimits(cfg config.RateLimitConfig, redisClient *redis.Client) (ip, keyed middleware.Middleware) {
+	if cfg.KeyBy == middleware.RateLimitByIP {
+		ip = middleware.DistributedRateLimit(middleware.NewTokenBucket(redisClient, cfg.RequestsPerSecond, cfg.Burst), middleware.RateLimitByIP)
+		return ip, func(next http.Handler) http.Handler { return next }
+	}
+	ip = middleware.DistributedRateLimit(middleware.NewTokenBucket(redisClient, cfg.IPRequestsPerSecond, cfg.IPBurst), middleware.RateLimitByIP)
+	keyed = middleware.DistributedRateLimit(middleware.NewTokenBucket(redisClient, cfg.RequestsPerSecond, cfg.Burst), cfg.KeyBy)
+	return ip, keyed
+}
+
+func validateRateLimit(cfg config.RateLimitConfig) error {
+	switch cfg.KeyBy {
+	case middleware.RateLimitByTenant, middleware.RateLimitByAPIKey, middleware.RateLimitByIP:
+	default:
+		return fmt.Errorf("unsupported RATE_LIMIT_KEY %q", cfg.KeyBy)
+	}
+	if cfg.RequestsPerSecond <= 0 || cfg.Burst < 1 {
+		return fmt.Errorf("RATE_LIMIT_RPS must be positive and RATE_LIMIT_BURST at least 1")
+	}
+	if cfg.IPRequestsPerSecond <= 0 || cfg.IPBurst < 1 {
+		return fmt.Errorf("RATE_LIMIT_IP_RPS must be positive and RATE_LIMIT_IP_BURST at least 1")
+	}
+	return nil
 }
@@ -0,0 +1,325 @@
+// Package anonymizer replaces detected entities with vault tokens and back,
+// using non-overlapping spans rather than blind string substitution.
+package anonymizer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+// Span is a byte range of the text that will be replaced by Replacement.
+type Span struct {
+	Start       int
+	End         int
+	Replacement string
+	Entity      models.Entity
+
+	// exact is set when the span came from the entity's reported Position
+	// rather than from searching the text for its Original value.
+	exact bool
+}
+
+// Resolve locates every entity in text and returns non-overlapping spans in
+// text order. An entity is placed at its reported Position when the text
+// there matches its Original (byte or code point offset, since NER services
+// written in Python report the latter); any other whole-word occurrences of
+// the same value are found by search so a detected value never leaks. When
+// candidates overlap, the longest wins, then an exact position, then the
+// higher confidence.
+func Resolve(text string, entities []models.Entity) []Span {
+	var candidates []Span
+	var runeOffsets []int
+
+	for _, entity := range entities {
+		if entity.Original == "" {
+			continue
+		}
+
+		if start, ok := locate(text, entity, &runeOffsets); ok {
+			candidates = append(candidates, Span{
+				Start:       start,
+				End:         start + len(entity.Original),
+				Replacement: entity.Token,
+				Entity:      entity,
+				exact:       true,
+			})
+		}
+
+		for _, start := range wordOccurrences(text, entity.Original) {
+			candidates = append(candidates, Span{
+				Start:       start,
+				End:         start + len(entity.Original),
+				Replacement: entity.Token,
+				Entity:      entity,
+			})
+		}
+	}
+
+	return selectSpans(candidates)
+}
+
+// AssignTokens gives every distinct value exactly one token. Entities that
+// share a Type and Original share a token, and a token already claimed by a
+// different value (as happens when several texts or detectors number their
+// tokens independently) is replaced with the next free "[TYPE_NNN]".
+func AssignTokens(entities []models.Entity) []models.Entity {
+	tokens := make(map[entityValue]string)
+	owners := make(map[string]entityValue)
+	assigned := make([]models.Entity, len(entities))
+
+	for i, entity := range entities {
+		v := entityValue{entity.Type, entity.Original}
+
+		if token, ok := tokens[v]; ok {
+			entity.Token = token
+		} else {
+			if _, taken := owners[entity.Token]; taken || entity.Token == "" {
+				entity.Token = nextFreeToken(entity.Type, owners)
+			}
+			tokens[v] = entity.Token
+			owners[entity.Token] = v
+		}
+
+		assigned[i] = entity
+	}
+	return assigned
+}
+
+// HashToken returns a deterministic, irreversible token for entity: the
+// same key and value always give the same "[TYPE_<hash>]", so scrubbed
+// texts stay comparable without a vault to restore them.
+func HashToken(key []byte, entity models.Entity) string {
+	entityType := entity.Type
+	if entityType == "" {
+		entityType = "ENTITY"
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(entityType + "\x00" + entity.Original))
+	return "[" + entityType + "_" + hex.EncodeToString(mac.Sum(nil))[:12] + "]"
+}
+
+type entityValue struct {
+	typ      string
+	original string
+}
+
+func nextFreeToken(entityType string, owners map[string]entityValue) string {
+	if entityType == "" {
+		entityType = "ENTITY"
+	}
+	for n := 1; ; n++ {
+		token := fmt.Sprintf("[%s_%03d]", entityType, n)
+		if _, taken := owners[token]; !taken {
+			return token
+		}
+	}
+}
+
+// MessageEntities returns the entities to apply to message index. Entities
+// detected in other messages are kept, without a position, so a value found
+// in one message is still scrubbed wherever else it appears.
+func MessageEntities(entities []models.Entity, index int) []models.Entity {
+	scoped := make([]models.Entity, len(entities))
+	for i, entity := range entities {
+		if entity.MessageIndex != index {
+			entity.Position = -1
+		}
+		scoped[i] = entity
+	}
+	return scoped
+}
+
+// Anonymize replaces each entity in text with its token.
+func Anonymize(text string, entities []models.Entity) string {
+	return Apply(text, Resolve(text, entities))
+}
+
+// AnonymizeSegments anonymizes texts that were scanned as one text, joined
+// by newlines, such as the text parts of a message; entity positions refer
+// to that joined text. A value that runs across a boundary is replaced by
+// its token in the segment where it starts and removed from the next.
+func AnonymizeSegments(segments []string, entities []models.Entity) []string {
+	spans := Resolve(strings.Join(segments, "\n"), entities)
+	placed := make([]bool, len(spans))
+
+	out := make([]string, len(segments))
+	offset := 0
+	for i, segment := range segments {
+		start, end := offset, offset+len(segment)
+
+		var local []Span
+		for j, span := range spans {
+			if span.End <= start || span.Start >= end {
+				continue
+			}
+			clipped := Span{
+				Start:  max(span.Start, start) - start,
+				End:    min(span.End, end) - start,
+				Entity: span.Entity,
+			}
+			if !placed[j] {
+				clipped.Replacement = span.Replacement
+				placed[j] = true
+			}
+			local = append(local, clipped)
+		}
+
+		out[i] = Apply(segment, local)
+		offset = end + 1
+	}
+	return out
+}
+
+// Restore replaces each token in text with the entity's original value.
+// Overlapping tokens resolve to the longest one.
+func Restore(text string, entities []models.Entity) string {
+	var candidates []Span
+	for _, entity := range entities {
+		if entity.Token == "" {
+			continue
+		}
+		for _, start := range occurrences(text, entity.Token) {
+			candidates = append(candidates, Span{
+				Start:       start,
+				End:         start + len(entity.Token),
+				Replacement: entity.Original,
+				Entity:      entity,
+			})
+		}
+	}
+
+	return Apply(text, selectSpans(candidates))
+}
+
+// Apply rewrites text with spans, which must be sorted and non-overlapping
+// as returned by Resolve.
+func Apply(text string, spans []Span) string {
+	if len(spans) == 0 {
+		return text
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, span := range spans {
+		out.WriteString(text[last:span.Start])
+		out.WriteString(span.Replacement)
+		last = span.End
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+func selectSpans(candidates []Span) []Span {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.End-a.Start != b.End-b.Start {
+			return a.End-a.Start > b.End-b.Start
+		}
+		if a.exact != b.exact {
+			return a.exact
+		}
+		if a.Entity.Confidence != b.Entity.Confidence {
+			return a.Entity.Confidence > b.Entity.Confidence
+		}
+		return a.Start < b.Start
+	})
+
+	var selected []Span
+	for _, candidate := range candidates {
+		overlaps := false
+		for _, s := range selected {
+			if candidate.Start < s.End && s.Start < candidate.End {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			selected = append(selected, candidate)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Start < selected[j].Start
+	})
+	return selected
+}
+
+// Locate returns the byte offset of entity in text if its Position, read
+// as either a byte or a code point offset, points at its Original value.
+func Locate(text string, entity models.Entity) (int, bool) {
+	var runeOffsets []int
+	return locate(text, entity, &runeOffsets)
+}
+
+func locate(text string, entity models.Entity, runeOffsets *[]int) (int, bool) {
+	pos := entity.Position
+	if pos < 0 || entity.Original == "" {
+		return 0, false
+	}
+
+	if strings.HasPrefix(text[min(pos, len(text)):], entity.Original) {
+		return pos, true
+	}
+
+	if *runeOffsets == nil {
+		*runeOffsets = make([]int, 0, utf8.RuneCountInString(text))
+		for i := range text {
+			*runeOffsets = append(*runeOffsets, i)
+		}
+	}
+	if pos < len(*runeOffsets) {
+		start := (*runeOffsets)[pos]
+		if strings.HasPrefix(text[start:], entity.Original) {
+			return start, true
+		}
+	}
+
+	return 0, false
+}
+
+func occurrences(text, value string) []int {
+	var starts []int
+	for offset := 0; ; {
+		idx := strings.Index(text[offset:], value)
+		if idx == -1 {
+			return starts
+		}
+		starts = append(starts, offset+idx)
+		offset += idx + len(value)
+	}
+}
+
+// wordOccurrences finds occurrences of value that do not run into
+// neighbouring letters or digits, so "Ann" is not found inside "Annual".
+func wordOccurrences(text, value string) []int {
+	var starts []int
+	for _, start := range occurrences(text, value) {
+		end := start + len(value)
+
+		if first, _ := utf8.DecodeRuneInString(value); isWordRune(first) {
+			if prev, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWordRune(prev) {
+				continue
+			}
+		}
+		if last, _ := utf8.DecodeLastRuneInString(value); isWordRune(last) {
+			if next, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordRune(next) {
+				continue
+			}
+		}
+
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
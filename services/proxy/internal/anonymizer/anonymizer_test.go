@@ -0,0 +1,297 @@
+package anonymizer
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+func TestAnonymize_OverlappingEntities(t *testing.T) {
+	text := "Ann met Annabelle at the Annual review"
+	entities := []models.Entity{
+		{Original: "Ann", Token: "[PERSON_001]", Type: "PERSON", Position: 0, Confidence: 0.9},
+		{Original: "Annabelle", Token: "[PERSON_002]", Type: "PERSON", Position: 8, Confidence: 0.9},
+		{Original: "Ann", Token: "[PERSON_003]", Type: "PERSON", Position: 8, Confidence: 0.95},
+	}
+
+	got := Anonymize(text, entities)
+	want := "[PERSON_001] met [PERSON_002] at the Annual review"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if restored := Restore(got, entities); restored != text {
+		t.Errorf("Expected round trip to %q, got %q", text, restored)
+	}
+}
+
+func TestAnonymize_OrderIndependent(t *testing.T) {
+	text := "Call Ann Lee at 555-123-4567 or email ann@example.com"
+	entities := []models.Entity{
+		{Original: "Ann Lee", Token: "[PERSON_001]", Position: 5, Confidence: 0.9},
+		{Original: "Ann", Token: "[PERSON_002]", Position: 5, Confidence: 0.99},
+		{Original: "555-123-4567", Token: "[PHONE_001]", Position: 16, Confidence: 0.98},
+		{Original: "ann@example.com", Token: "[EMAIL_001]", Position: 38, Confidence: 0.98},
+	}
+
+	want := Anonymize(text, entities)
+	for i := 0; i < 10; i++ {
+		shuffled := append([]models.Entity(nil), entities...)
+		rand.Shuffle(len(shuffled), func(a, b int) { shuffled[a], shuffled[b] = shuffled[b], shuffled[a] })
+		if got := Anonymize(text, shuffled); got != want {
+			t.Fatalf("Expected %q regardless of order, got %q", want, got)
+		}
+	}
+
+	if want != "Call [PERSON_001] at [PHONE_001] or email [EMAIL_001]" {
+		t.Errorf("Unexpected anonymization %q", want)
+	}
+}
+
+func TestAnonymize_CodePointPositions(t *testing.T) {
+	text := "Grüße an José, jose@example.com"
+	entities := []models.Entity{
+		// Positions as a Python NER service reports them.
+		{Original: "José", Token: "[PERSON_001]", Position: 9},
+		{Original: "jose@example.com", Token: "[EMAIL_001]", Position: 15},
+	}
+
+	spans := Resolve(text, entities)
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	for _, span := range spans {
+		if !span.exact {
+			t.Errorf("Expected %q to be placed by position", span.Entity.Original)
+		}
+	}
+
+	if got := Anonymize(text, entities); got != "Grüße an [PERSON_001], [EMAIL_001]" {
+		t.Errorf("Unexpected anonymization %q", got)
+	}
+}
+
+func TestAnonymize_WrongPositionFallsBackToSearch(t *testing.T) {
+	text := "first line\nreach me at bob@example.com"
+	entities := []models.Entity{
+		{Original: "bob@example.com", Token: "[EMAIL_001]", Position: 3},
+	}
+
+	if got := Anonymize(text, entities); got != "first line\nreach me at [EMAIL_001]" {
+		t.Errorf("Unexpected anonymization %q", got)
+	}
+}
+
+func TestRestore_PrefersLongestToken(t *testing.T) {
+	entities := []models.Entity{
+		{Original: "a@example.com", Token: "[EMAIL_1]"},
+		{Original: "j@example.com", Token: "[EMAIL_10]"},
+	}
+
+	got := Restore("[EMAIL_10] and [EMAIL_1]", entities)
+	if got != "j@example.com and a@example.com" {
+		t.Errorf("Unexpected restoration %q", got)
+	}
+}
+
+// roundTripCase is a random text with entities reported at the offsets where
+// they were inserted, mixing byte and code point positions.
+type roundTripCase struct {
+	Text     string
+	Entities []models.Entity
+}
+
+var (
+	fillerWords = []string{"the", "patient", "café", "über", "日本", "notes", "and", "called", "re:", "x", "annual", "42"}
+	piiValues   = []struct{ typ, value string }{
+		{"PERSON", "Ann"},
+		{"PERSON", "Annabelle"},
+		{"PERSON", "José Núñez"},
+		{"EMAIL", "ann@example.com"},
+		{"EMAIL", "a@b.io"},
+		{"SSN", "123-45-6789"},
+		{"PHONE", "555-123-4567"},
+		{"CREDIT_CARD", "4111 1111 1111 1111"},
+	}
+)
+
+func (roundTripCase) Generate(r *rand.Rand, size int) reflect.Value {
+	var text strings.Builder
+	var entities []models.Entity
+	counters := make(map[string]int)
+
+	newToken := func(typ string) string {
+		counters[typ]++
+		return fmt.Sprintf("[%s_%03d]", typ, counters[typ])
+	}
+
+	for i := 0; i < size+1; i++ {
+		if text.Len() > 0 {
+			text.WriteString([]string{" ", "\n", ", "}[r.Intn(3)])
+		}
+
+		if r.Intn(3) > 0 {
+			text.WriteString(fillerWords[r.Intn(len(fillerWords))])
+			continue
+		}
+
+		pii := piiValues[r.Intn(len(piiValues))]
+		prefix := text.String()
+		position := len(prefix)
+		if r.Intn(2) == 0 {
+			position = utf8.RuneCountInString(prefix)
+		}
+		if r.Intn(5) == 0 {
+			// Simulate a detector reporting a stale offset.
+			position += 1 + r.Intn(7)
+		}
+
+		entities = append(entities, models.Entity{
+			Original:   pii.value,
+			Token:      newToken(pii.typ),
+			Type:       pii.typ,
+			Position:   position,
+			Confidence: r.Float64(),
+		})
+
+		// Occasionally report a shorter entity nested inside this one.
+		if pii.value == "Annabelle" && r.Intn(2) == 0 {
+			entities = append(entities, models.Entity{
+				Original:   "Ann",
+				Token:      newToken("PERSON"),
+				Type:       "PERSON",
+				Position:   position,
+				Confidence: r.Float64(),
+			})
+		}
+
+		text.WriteString(pii.value)
+	}
+
+	r.Shuffle(len(entities), func(i, j int) { entities[i], entities[j] = entities[j], entities[i] })
+
+	return reflect.ValueOf(roundTripCase{Text: text.String(), Entities: entities})
+}
+
+func TestProperty_RoundTrip(t *testing.T) {
+	roundTrip := func(c roundTripCase) bool {
+		return Restore(Anonymize(c.Text, c.Entities), c.Entities) == c.Text
+	}
+
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProperty_NoDetectedValueLeaks(t *testing.T) {
+	noLeaks := func(c roundTripCase) bool {
+		anonymized := Anonymize(c.Text, c.Entities)
+		for _, entity := range c.Entities {
+			if len(wordOccurrences(anonymized, entity.Original)) > 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(noLeaks, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProperty_SpansAreOrderedAndDisjoint(t *testing.T) {
+	disjoint := func(c roundTripCase) bool {
+		spans := Resolve(c.Text, c.Entities)
+		for i, span := range spans {
+			if span.Start < 0 || span.End > len(c.Text) || c.Text[span.Start:span.End] != span.Entity.Original {
+				return false
+			}
+			if i > 0 && spans[i-1].End > span.Start {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(disjoint, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAssignTokens(t *testing.T) {
+	entities := []models.Entity{
+		{Original: "a@example.com", Token: "[EMAIL_001]", Type: "EMAIL"},
+		{Original: "b@example.com", Token: "[EMAIL_001]", Type: "EMAIL"},
+		{Original: "a@example.com", Token: "[EMAIL_002]", Type: "EMAIL"},
+		{Original: "Ann", Type: "PERSON"},
+	}
+
+	got := AssignTokens(entities)
+	want := []string{"[EMAIL_001]", "[EMAIL_002]", "[EMAIL_001]", "[PERSON_001]"}
+	for i, entity := range got {
+		if entity.Token != want[i] {
+			t.Errorf("Entity %d: expected %s, got %s", i, want[i], entity.Token)
+		}
+	}
+
+	if entities[1].Token != "[EMAIL_001]" {
+		t.Error("Expected input entities to be left unchanged")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	key := []byte("secret")
+	ann := models.Entity{Original: "Ann", Type: "PERSON"}
+
+	token := HashToken(key, ann)
+	if !strings.HasPrefix(token, "[PERSON_") || len(token) != len("[PERSON_]")+12 {
+		t.Errorf("Expected [PERSON_<12 hex>], got %s", token)
+	}
+	if HashToken(key, ann) != token {
+		t.Error("Expected the same token for the same value")
+	}
+	if HashToken(key, models.Entity{Original: "Bob", Type: "PERSON"}) == token {
+		t.Error("Expected different values to get different tokens")
+	}
+	if HashToken([]byte("other"), ann) == token {
+		t.Error("Expected a different key to give a different token")
+	}
+}
+
+func TestMessageEntities(t *testing.T) {
+	entities := []models.Entity{
+		{Original: "Ann", Token: "[PERSON_001]", Position: 0, MessageIndex: 0},
+		{Original: "Bob", Token: "[PERSON_002]", Position: 4, MessageIndex: 1},
+	}
+
+	// "Bob" sits at offset 4 of message 1; message 0 has it elsewhere.
+	got := Anonymize("Ann, Bob and Bob", MessageEntities(entities, 0))
+	if got != "[PERSON_001], [PERSON_002] and [PERSON_002]" {
+		t.Errorf("Unexpected anonymization %q", got)
+	}
+
+	spans := Resolve("Hi, Bob", MessageEntities(entities, 1))
+	if len(spans) != 1 || !spans[0].exact {
+		t.Errorf("Expected Bob to be placed by its own message offset, got %+v", spans)
+	}
+}
+
+func TestAnonymizeSegments(t *testing.T) {
+	segments := []string{"Hi, I am Ann", "Lee. Mail ann@example.com", "Thanks"}
+	entities := []models.Entity{
+		{Original: "Ann\nLee", Token: "[PERSON_001]", Position: 9},
+		{Original: "ann@example.com", Token: "[EMAIL_001]", Position: 23},
+	}
+
+	got := AnonymizeSegments(segments, entities)
+	want := []string{"Hi, I am [PERSON_001]", ". Mail [EMAIL_001]", "Thanks"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,120 @@
+package anonymizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+// JSONStrings returns the strings in doc, a JSON document such as the
+// arguments of a tool call, object keys included, joined by newlines so
+// they can be scanned for entities as one text. A doc that is not valid
+// JSON is returned unchanged.
+func JSONStrings(doc string) string {
+	value, ok := decodeJSON(doc)
+	if !ok {
+		return doc
+	}
+
+	var values []string
+	walkStrings(value, func(s string) string {
+		values = append(values, s)
+		return s
+	})
+	return strings.Join(values, "\n")
+}
+
+// AnonymizeJSON replaces each entity inside the strings of doc, object keys
+// included, with its token. Strings are matched after JSON unescaping, so
+// "é" in the document matches an "é" found by detection. doc is returned byte for
+// byte when nothing in it is replaced, since re-encoding would reorder keys
+// and change escaping and whitespace. A doc that is not valid JSON is
+// anonymized as plain text.
+func AnonymizeJSON(doc string, entities []models.Entity) string {
+	value, ok := decodeJSON(doc)
+	if !ok {
+		return Anonymize(doc, entities)
+	}
+
+	replaced := false
+	value = walkStrings(value, func(s string) string {
+		anonymized := Anonymize(s, entities)
+		replaced = replaced || anonymized != s
+		return anonymized
+	})
+	if !replaced {
+		return doc
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return Anonymize(doc, entities)
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// RestoreJSON replaces each token in doc with the entity's original value,
+// escaped so that doc stays valid JSON. Tokens only ever appear inside
+// strings, so doc may also be a fragment of a streamed document.
+func RestoreJSON(doc string, entities []models.Entity) string {
+	return Restore(doc, JSONEscaped(entities))
+}
+
+// JSONEscaped returns a copy of entities whose Original values are escaped
+// for use inside a JSON string.
+func JSONEscaped(entities []models.Entity) []models.Entity {
+	escaped := make([]models.Entity, len(entities))
+	for i, entity := range entities {
+		entity.Original = escapeJSONString(entity.Original)
+		escaped[i] = entity
+	}
+	return escaped
+}
+
+func escapeJSONString(s string) string {
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Drop the surrounding quotes and the encoder's trailing newline.
+	return out.String()[1 : out.Len()-2]
+}
+
+func decodeJSON(doc string) (any, bool) {
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, false
+	}
+	if dec.More() {
+		return nil, false
+	}
+	return value, true
+}
+
+// walkStrings replaces every string in value, object keys included, with
+// fn's result. Keys are rewritten too because arguments such as
+// {"john@example.com": "owner"} use PII as keys.
+func walkStrings(value any, fn func(string) string) any {
+	switch v := value.(type) {
+	case string:
+		return fn(v)
+	case []any:
+		for i := range v {
+			v[i] = walkStrings(v[i], fn)
+		}
+	case map[string]any:
+		walked := make(map[string]any, len(v))
+		for key, item := range v {
+			walked[fn(key)] = walkStrings(item, fn)
+		}
+		return walked
+	}
+	return value
+}
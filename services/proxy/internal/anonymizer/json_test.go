@@ -0,0 +1,96 @@
+package anonymizer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+func TestAnonymizeJSON_MatchesUnescapedValues(t *testing.T) {
+	// Python's json.dumps escapes non-ASCII by default.
+	doc := `{"to": "Ren\u00e9 Dubois", "cc": ["rene@example.com"], "count": 2}`
+	entities := []models.Entity{
+		{Original: "René Dubois", Token: "[PERSON_001]", Position: -1},
+		{Original: "rene@example.com", Token: "[EMAIL_001]", Position: -1},
+	}
+
+	got := AnonymizeJSON(doc, entities)
+
+	var args struct {
+		To    string   `json:"to"`
+		CC    []string `json:"cc"`
+		Count json.Number
+	}
+	if err := json.Unmarshal([]byte(got), &args); err != nil {
+		t.Fatalf("Expected valid JSON, got %q: %v", got, err)
+	}
+	if args.To != "[PERSON_001]" || args.CC[0] != "[EMAIL_001]" || args.Count != "2" {
+		t.Errorf("Unexpected anonymized arguments %q", got)
+	}
+}
+
+func TestAnonymizeJSON_UnchangedWithoutReplacements(t *testing.T) {
+	doc := `{"z": "Ren\u00e9", "a": [1.50, "<b>"]}`
+	entities := []models.Entity{{Original: "ann@example.com", Token: "[EMAIL_001]", Position: -1}}
+
+	if got := AnonymizeJSON(doc, entities); got != doc {
+		t.Errorf("Expected doc unchanged, got %q", got)
+	}
+}
+
+func TestAnonymizeJSON_InvalidJSON(t *testing.T) {
+	entities := []models.Entity{{Original: "ann@example.com", Token: "[EMAIL_001]", Position: -1}}
+
+	if got := AnonymizeJSON(`{"to": "ann@example.com"`, entities); got != `{"to": "[EMAIL_001]"` {
+		t.Errorf("Expected plain text anonymization, got %q", got)
+	}
+}
+
+func TestRestoreJSON_EscapesOriginals(t *testing.T) {
+	doc := `{"note": "[NAME_001] said [QUOTE_001]"}`
+	entities := []models.Entity{
+		{Original: `O"Brien`, Token: "[NAME_001]"},
+		{Original: "line one\nline two <ok>", Token: "[QUOTE_001]"},
+	}
+
+	got := RestoreJSON(doc, entities)
+
+	var args map[string]string
+	if err := json.Unmarshal([]byte(got), &args); err != nil {
+		t.Fatalf("Expected valid JSON, got %q: %v", got, err)
+	}
+	if want := "O\"Brien said line one\nline two <ok>"; args["note"] != want {
+		t.Errorf("Expected %q, got %q", want, args["note"])
+	}
+}
+
+func TestJSONStrings(t *testing.T) {
+	got := JSONStrings(`{"to": "Ann Lee", "body": {"lines": ["Hi", 3, true]}}`)
+
+	for _, want := range []string{"to", "Ann Lee", "lines", "Hi"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in %q", want, got)
+		}
+	}
+	if strings.Contains(got, "3") || strings.Contains(got, "true") {
+		t.Errorf("Expected only strings, got %q", got)
+	}
+}
+
+func TestAnonymizeJSON_Keys(t *testing.T) {
+	doc := `{"owners": {"ann@example.com": "admin"}}`
+	entities := []models.Entity{{Original: "ann@example.com", Token: "[EMAIL_001]", Position: -1}}
+
+	got := AnonymizeJSON(doc, entities)
+	if got != `{"owners":{"[EMAIL_001]":"admin"}}` {
+		t.Errorf("Expected the key to be tokenized, got %q", got)
+	}
+	if !strings.Contains(JSONStrings(doc), "ann@example.com") {
+		t.Error("Expected keys to be scanned")
+	}
+	if restored := RestoreJSON(got, entities); restored != `{"owners":{"ann@example.com":"admin"}}` {
+		t.Errorf("Expected the key to be restored, got %q", restored)
+	}
+}
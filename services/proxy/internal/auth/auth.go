@@ -0,0 +1,114 @@
+// Package auth resolves tenant API keys. Keys are never stored in clear:
+// stores hold the hex SHA-256 of each key, which is safe for the long random
+// keys SafeRoute issues.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrUnknownKey is returned when no tenant owns an API key.
+var ErrUnknownKey = errors.New("unknown API key")
+
+// Tenant is the caller identity attached to authenticated requests.
+type Tenant struct {
+	ID   string `json:"tenant_id"`
+	Name string `json:"name,omitempty"`
+}
+
+// KeyStore finds the tenant owning a hashed API key.
+type KeyStore interface {
+	Lookup(ctx context.Context, keyHash string) (Tenant, error)
+}
+
+// HashKey returns the hex SHA-256 of key, the form in which stores hold it.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying tenant.
+func WithTenant(ctx context.Context, tenant Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant.
+func TenantFromContext(ctx context.Context) (Tenant, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(Tenant)
+	return tenant, ok
+}
+
+// FileKeyStore holds keys loaded once from a JSON file of the form
+// {"keys": [{"hash": "<sha256 hex>", "tenant_id": "acme", "name": "Acme"}]}.
+type FileKeyStore struct {
+	tenants map[string]Tenant
+}
+
+func LoadKeyFile(path string) (*FileKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var file struct {
+		Keys []struct {
+			Hash string `json:"hash"`
+			Tenant
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+
+	store := &FileKeyStore{tenants: make(map[string]Tenant)}
+	for i, key := range file.Keys {
+		if key.Hash == "" || key.ID == "" {
+			return nil, fmt.Errorf("key %d: hash and tenant_id are required", i)
+		}
+		store.tenants[key.Hash] = key.Tenant
+	}
+	return store, nil
+}
+
+func (s *FileKeyStore) Lookup(ctx context.Context, keyHash string) (Tenant, error) {
+	tenant, ok := s.tenants[keyHash]
+	if !ok {
+		return Tenant{}, ErrUnknownKey
+	}
+	return tenant, nil
+}
+
+// RedisKeyPrefix is prepended to the key hash to form the Redis hash that
+// holds a key's "tenant_id" and "name" fields.
+const RedisKeyPrefix = "saferoute:apikey:"
+
+// RedisKeyStore looks keys up in Redis on every request, so keys can be
+// issued and revoked without restarting the proxy.
+type RedisKeyStore struct {
+	client *redis.Client
+}
+
+func NewRedisKeyStore(client *redis.Client) *RedisKeyStore {
+	return &RedisKeyStore{client: client}
+}
+
+func (s *RedisKeyStore) Lookup(ctx context.Context, keyHash string) (Tenant, error) {
+	fields, err := s.client.HGetAll(ctx, RedisKeyPrefix+keyHash).Result()
+	if err != nil {
+		return Tenant{}, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	if fields["tenant_id"] == "" {
+		return Tenant{}, ErrUnknownKey
+	}
+	return Tenant{ID: fields["tenant_id"], Name: fields["name"]}, nil
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestHashKey(t *testing.T) {
+	// echo -n sk-test | sha256sum
+	want := "f3abf2a6cc4f00987743db5f544ba345b4899ae31f326d8ee9c4816de153c9e0"
+	if got := HashKey("sk-test"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"keys": [{"hash": "` + HashKey("sk-acme") + `", "tenant_id": "acme", "name": "Acme"}]}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tenant, err := store.Lookup(context.Background(), HashKey("sk-acme"))
+	if err != nil || tenant.ID != "acme" || tenant.Name != "Acme" {
+		t.Errorf("Expected tenant acme, got %+v (%v)", tenant, err)
+	}
+
+	if _, err := store.Lookup(context.Background(), HashKey("sk-other")); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestLoadKeyFile_RequiresTenant(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`{"keys": [{"hash": "abc"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeyFile(path); err == nil {
+		t.Error("Expected an error for a key without a tenant")
+	}
+}
+
+func TestRedisKeyStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	mr.HSet(RedisKeyPrefix+HashKey("sk-globex"), "tenant_id", "globex", "name", "Globex")
+
+	store := NewRedisKeyStore(client)
+	tenant, err := store.Lookup(context.Background(), HashKey("sk-globex"))
+	if err != nil || tenant.ID != "globex" || tenant.Name != "Globex" {
+		t.Errorf("Expected tenant globex, got %+v (%v)", tenant, err)
+	}
+
+	if _, err := store.Lookup(context.Background(), HashKey("sk-other")); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+
+	mr.Close()
+	if _, err := store.Lookup(context.Background(), HashKey("sk-globex")); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected a lookup failure when Redis is down, got %v", err)
+	}
+}
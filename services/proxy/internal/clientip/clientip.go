@@ -0,0 +1,161 @@
+// Package clientip finds the address of the client behind trusted reverse
+// proxies. Forwarding headers are only believed when the peer that sent
+// them is a trusted proxy, and are read right to left so a client cannot
+// spoof its address by sending its own X-Forwarded-For.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver extracts client addresses given the trusted proxy ranges.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver trusts the given CIDRs; bare addresses are accepted as single
+// host ranges.
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, value := range trustedProxies {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// ClientIP returns the client address for req. When the direct peer is a
+// trusted proxy, the forwarding chain from the Forwarded, X-Forwarded-For
+// or X-Real-IP header (in that order of preference) is walked from the
+// nearest hop outward and the first untrusted address is returned.
+func (r *Resolver) ClientIP(req *http.Request) netip.Addr {
+	peer, ok := parseAddr(req.RemoteAddr)
+	if !ok || !r.isTrusted(peer) {
+		return peer
+	}
+
+	client := peer
+	chain := forwardedChain(req.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(chain[i])
+		if !ok {
+			// An unparsable hop (such as "unknown") ends what can be
+			// trusted; the last proxy before it is as far as we can see.
+			break
+		}
+		client = addr
+		if !r.isTrusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func forwardedChain(h http.Header) []string {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		var chain []string
+		for _, element := range splitList(values) {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					chain = append(chain, value)
+				}
+			}
+		}
+		return chain
+	}
+
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		return splitList(values)
+	}
+
+	if value := h.Get("X-Real-IP"); value != "" {
+		return []string{value}
+	}
+	return nil
+}
+
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+	return items
+}
+
+// parseAddr accepts an address with or without a port, including quoted and
+// bracketed IPv6 forms such as "[2001:db8::1]:4711". IPv4-mapped IPv6
+// addresses are unmapped so they match IPv4 ranges.
+func parseAddr(value string) (netip.Addr, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap(), true
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+type contextKey struct{}
+
+// Middleware resolves the client address once per request and stores it in
+// the request context for FromRequest.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), contextKey{}, r.ClientIP(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// FromRequest returns the address stored by Middleware, or the direct
+// peer's address when the middleware did not run.
+func FromRequest(req *http.Request) netip.Addr {
+	if addr, ok := req.Context().Value(contextKey{}).(netip.Addr); ok {
+		return addr
+	}
+	addr, _ := parseAddr(req.RemoteAddr)
+	return addr
+}
+
+// String returns the client address of req for use as a key or log field,
+// falling back to the raw RemoteAddr if it could not be parsed.
+func String(req *http.Request) string {
+	if addr := FromRequest(req); addr.IsValid() {
+		return addr.String()
+	}
+	return req.RemoteAddr
+}
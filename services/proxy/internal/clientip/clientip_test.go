@@ -0,0 +1,72 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "fd00::/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer ignores headers", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.7"},
+		{"x-forwarded-for", "10.0.0.5:443", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		{"spoofed left entries skipped", "10.0.0.5:443", map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.9, 10.1.1.1"}, "198.51.100.9"},
+		{"all trusted returns leftmost", "10.0.0.5:443", map[string]string{"X-Forwarded-For": "10.2.2.2, 10.1.1.1"}, "10.2.2.2"},
+		{"ipv6 peer and hop", "[fd00::1]:443", map[string]string{"X-Forwarded-For": "2001:db8::42"}, "2001:db8::42"},
+		{"hop with port", "10.0.0.5:443", map[string]string{"X-Forwarded-For": "[2001:db8::42]:8443"}, "2001:db8::42"},
+		{"forwarded", "10.0.0.5:443", map[string]string{"Forwarded": `for=198.51.100.9;proto=https, for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"forwarded preferred", "10.0.0.5:443", map[string]string{"Forwarded": "for=198.51.100.9", "X-Forwarded-For": "6.6.6.6"}, "198.51.100.9"},
+		{"forwarded unknown", "10.0.0.5:443", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.5"},
+		{"x-real-ip", "192.0.2.1:80", map[string]string{"X-Real-IP": "198.51.100.9"}, "198.51.100.9"},
+		{"ipv4-mapped peer", "[::ffff:10.0.0.5]:443", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			if got := resolver.ClientIP(req).String(); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewResolver_Invalid(t *testing.T) {
+	if _, err := NewResolver([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected an error for an invalid CIDR")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	resolver, _ := NewResolver([]string{"10.0.0.0/8"})
+
+	var got string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = String(r)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.5:443"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "198.51.100.9" {
+		t.Errorf("Expected the resolved client in context, got %s", got)
+	}
+}
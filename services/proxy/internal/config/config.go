@@ -2,28 +2,277 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Port            string
-	NERServiceURL   string
+	Port              string
+	NERServiceURL     string
+	NERDetectors      []string
+	NERDictionaryFile string
+	// NEROnDetectorFailure is "fail" to fail detection when any detector
+	// errors, or "partial" to keep the results of the ones that succeeded.
+	NEROnDetectorFailure string
+	// NERThresholds is the minimum confidence per entity type; "*" applies
+	// to types without their own entry.
+	NERThresholds   map[string]float64
+	PolicyFile      string
 	VaultServiceURL string
+	LLMProvider     string
 	LLMProviderURL  string
 	LLMAPIKey       string
+	LLMProviders    []LLMProviderConfig
+	LLMRoutes       []LLMRouteConfig
+	LLMRetry        LLMRetryConfig
 	RedisURL        string
 	LogLevel        string
+	// AuthMode selects where tenant API keys come from: "file", "redis" or
+	// "disabled".
+	AuthMode           string
+	AuthKeysFile       string
+	CORSAllowedOrigins []string
+	// TrustedProxies are the CIDRs whose forwarding headers are believed
+	// when working out the client IP.
+	TrustedProxies []string
+	RateLimit      RateLimitConfig
+	Quota          QuotaConfig
+	Embeddings     EmbeddingsConfig
+	Tracing        TracingConfig
+	Readiness      ReadinessConfig
+	Breaker        BreakerConfig
+}
+
+// BreakerConfig applies to the circuit breakers around the NER service, the
+// vault and each LLM provider. A FailureThreshold of zero disables them.
+type BreakerConfig struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	HalfOpenMaxCalls int
+}
+
+// ReadinessConfig controls the /ready probe. Results are cached for
+// CacheTTL. Critical lists the dependencies ("ner", "vault", "llm",
+// "redis") whose failure takes the replica out of rotation; the others are
+// only reported.
+type ReadinessConfig struct {
+	CacheTTL   time.Duration
+	Timeout    time.Duration
+	LLMTimeout time.Duration
+	Critical   []string
+}
+
+// TracingConfig selects the span exporter: "otlp" or "none". The OTLP
+// endpoint, headers and sampler are read by the OpenTelemetry SDK from the
+// standard OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER variables.
+type TracingConfig struct {
+	Exporter    string
+	ServiceName string
+}
+
+// RateLimitConfig is a token bucket shared across replicas through Redis:
+// Burst requests at once, refilled at RequestsPerSecond. KeyBy is "tenant",
+// "api_key" or "ip". Unless KeyBy is "ip", a second bucket per client IP,
+// of IPBurst refilled at IPRequestsPerSecond, is taken before
+// authentication so invalid keys cannot be tried without limit.
+type RateLimitConfig struct {
+	RequestsPerSecond   float64
+	Burst               int
+	KeyBy               string
+	IPRequestsPerSecond float64
+	IPBurst             int
+}
+
+// QuotaConfig sets the default per-tenant LLM token budgets (zero is
+// unlimited) and the completion size assumed when max_tokens is unset.
+type QuotaConfig struct {
+	DailyTokens             int64
+	MonthlyTokens           int64
+	DefaultCompletionTokens int
+}
+
+// EmbeddingsConfig controls /v1/embeddings. With a HashKey, tokenized
+// entities become keyed hashes, so equal values embed alike; without one
+// they are redacted. NERBatchSize is the number of inputs per NER call.
+type EmbeddingsConfig struct {
+	HashKey      string
+	NERBatchSize int
+}
+
+// LLMProviderConfig describes one upstream endpoint. Kind selects the wire
+// format ("anthropic" or "openai"; Ollama and vLLM speak the latter).
+type LLMProviderConfig struct {
+	Name    string
+	Kind    string
+	BaseURL string
+	APIKey  string
+}
+
+// LLMRouteConfig sends models matching Pattern to the named provider. A
+// trailing "*" matches any suffix, and a namespaced pattern such as
+// "local/*" strips the namespace before the model name goes upstream.
+type LLMRouteConfig struct {
+	Pattern  string
+	Provider string
+}
+
+// LLMRetryConfig controls retries per target and the ordered list of
+// fallback models tried once the requested model keeps failing. Budget
+// bounds a whole non-streaming call, retries included; the server's write
+// timeout is derived from it.
+type LLMRetryConfig struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Budget         time.Duration
+	FallbackModels []string
 }
 
 func LoadFromEnv() *Config {
-	return &Config{
-		Port:            getEnv("PORT", "8080"),
-		NERServiceURL:   getEnv("NER_SERVICE_URL", "http://localhost:8081"),
-		VaultServiceURL: getEnv("VAULT_SERVICE_URL", "http://localhost:8082"),
-		LLMProviderURL:  getEnv("LLM_PROVIDER_URL", "https://api.anthropic.com"),
-		LLMAPIKey:       getEnv("LLM_API_KEY", ""),
-		RedisURL:        getEnv("REDIS_URL", "redis://localhost:6379"),
-		LogLevel:        getEnv("LOG_LEVEL", "info"),
+	cfg := &Config{
+		Port:              getEnv("PORT", "8080"),
+		NERServiceURL:     getEnv("NER_SERVICE_URL", "http://localhost:8081"),
+		NERDictionaryFile: getEnv("NER_DICTIONARY_FILE", ""),
+		PolicyFile:        getEnv("POLICY_FILE", ""),
+		VaultServiceURL:   getEnv("VAULT_SERVICE_URL", "http://localhost:8082"),
+		LLMProvider:       getEnv("LLM_PROVIDER", "anthropic"),
+		LLMProviderURL:    getEnv("LLM_PROVIDER_URL", "https://api.anthropic.com"),
+		LLMAPIKey:         getEnv("LLM_API_KEY", ""),
+		RedisURL:          getEnv("REDIS_URL", "redis://localhost:6379"),
+		LogLevel:          getEnv("LOG_LEVEL", "info"),
+		AuthMode:          getEnv("AUTH_MODE", "file"),
+		AuthKeysFile:      getEnv("AUTH_KEYS_FILE", ""),
+		RateLimit: RateLimitConfig{
+			RequestsPerSecond: getEnvFloat("RATE_LIMIT_RPS", 100),
+			Burst:             getEnvInt("RATE_LIMIT_BURST", 200),
+			KeyBy:             getEnv("RATE_LIMIT_KEY", "tenant"),
+		},
+		Quota: QuotaConfig{
+			DailyTokens:             int64(getEnvInt("QUOTA_DAILY_TOKENS", 0)),
+			MonthlyTokens:           int64(getEnvInt("QUOTA_MONTHLY_TOKENS", 0)),
+			DefaultCompletionTokens: getEnvInt("QUOTA_DEFAULT_COMPLETION_TOKENS", 1024),
+		},
+		Embeddings: EmbeddingsConfig{
+			HashKey:      getEnv("EMBEDDINGS_HASH_KEY", ""),
+			NERBatchSize: getEnvInt("EMBEDDINGS_NER_BATCH_SIZE", 64),
+		},
+		Readiness: ReadinessConfig{
+			CacheTTL:   getEnvDuration("READINESS_CACHE_TTL", 5*time.Second),
+			Timeout:    getEnvDuration("READINESS_TIMEOUT", 2*time.Second),
+			LLMTimeout: getEnvDuration("READINESS_LLM_TIMEOUT", 5*time.Second),
+		},
+		Breaker: BreakerConfig{
+			FailureThreshold: getEnvInt("BREAKER_FAILURE_THRESHOLD", 5),
+			OpenTimeout:      getEnvDuration("BREAKER_OPEN_TIMEOUT", 30*time.Second),
+			HalfOpenMaxCalls: getEnvInt("BREAKER_HALF_OPEN_CALLS", 1),
+		},
+		LLMRetry: LLMRetryConfig{
+			MaxAttempts:    getEnvInt("LLM_MAX_ATTEMPTS", 3),
+			InitialBackoff: getEnvDuration("LLM_RETRY_INITIAL_BACKOFF", 500*time.Millisecond),
+			MaxBackoff:     getEnvDuration("LLM_RETRY_MAX_BACKOFF", 10*time.Second),
+			Budget:         getEnvDuration("LLM_RETRY_BUDGET", 90*time.Second),
+			FallbackModels: getEnvList("LLM_FALLBACK_MODELS"),
+		},
+	}
+
+	cfg.RateLimit.IPRequestsPerSecond = getEnvFloat("RATE_LIMIT_IP_RPS", cfg.RateLimit.RequestsPerSecond)
+	cfg.RateLimit.IPBurst = getEnvInt("RATE_LIMIT_IP_BURST", cfg.RateLimit.Burst)
+
+	cfg.NERDetectors = getEnvList("NER_DETECTORS")
+	if len(cfg.NERDetectors) == 0 {
+		cfg.NERDetectors = []string{"remote"}
+	}
+	cfg.CORSAllowedOrigins = getEnvList("CORS_ALLOWED_ORIGINS")
+	if len(cfg.CORSAllowedOrigins) == 0 {
+		cfg.CORSAllowedOrigins = []string{"http://localhost:3000"}
+	}
+	cfg.TrustedProxies = getEnvList("TRUSTED_PROXIES")
+	cfg.Readiness.Critical = getEnvList("READINESS_CRITICAL")
+	if len(cfg.Readiness.Critical) == 0 {
+		cfg.Readiness.Critical = []string{"ner", "vault"}
+	}
+	cfg.Tracing = TracingConfig{
+		Exporter:    getEnv("OTEL_TRACES_EXPORTER", defaultTracesExporter()),
+		ServiceName: getEnv("OTEL_SERVICE_NAME", "saferoute-proxy"),
 	}
+	cfg.NERThresholds = parseThresholds(getEnv("NER_CONFIDENCE_THRESHOLDS", ""))
+	cfg.NEROnDetectorFailure = getEnv("NER_ON_DETECTOR_FAILURE", "fail")
+
+	cfg.LLMProviders = loadLLMProviders(cfg)
+	cfg.LLMRoutes = parseLLMRoutes(getEnv("LLM_ROUTES", "claude-*=anthropic,gpt-*=openai,o1*=openai,o3*=openai,local/*=local"))
+
+	return cfg
+}
+
+// loadLLMProviders returns the default provider built from LLM_PROVIDER,
+// LLM_PROVIDER_URL and LLM_API_KEY, plus any provider given its own
+// credentials through ANTHROPIC_*, OPENAI_* or LOCAL_LLM_* variables.
+func loadLLMProviders(cfg *Config) []LLMProviderConfig {
+	providers := []LLMProviderConfig{
+		{Name: cfg.LLMProvider, Kind: cfg.LLMProvider, BaseURL: cfg.LLMProviderURL, APIKey: cfg.LLMAPIKey},
+	}
+
+	add := func(p LLMProviderConfig) {
+		for i := range providers {
+			if providers[i].Name == p.Name {
+				providers[i] = p
+				return
+			}
+		}
+		providers = append(providers, p)
+	}
+
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		add(LLMProviderConfig{Name: "anthropic", Kind: "anthropic", BaseURL: getEnv("ANTHROPIC_BASE_URL", "https://api.anthropic.com"), APIKey: key})
+	}
+	if key := os.Getenv("OPENAI_API_KEY"); key != "" {
+		add(LLMProviderConfig{Name: "openai", Kind: "openai", BaseURL: getEnv("OPENAI_BASE_URL", "https://api.openai.com"), APIKey: key})
+	}
+	if url := os.Getenv("LOCAL_LLM_BASE_URL"); url != "" {
+		add(LLMProviderConfig{Name: "local", Kind: "openai", BaseURL: url, APIKey: os.Getenv("LOCAL_LLM_API_KEY")})
+	}
+
+	return providers
+}
+
+// defaultTracesExporter turns OTLP export on as soon as a collector
+// endpoint is configured.
+func defaultTracesExporter() string {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		return "otlp"
+	}
+	return "none"
+}
+
+// parseLLMRoutes reads "pattern=provider" pairs separated by commas.
+func parseLLMRoutes(value string) []LLMRouteConfig {
+	var routes []LLMRouteConfig
+	for _, pair := range strings.Split(value, ",") {
+		pattern, provider, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || pattern == "" || provider == "" {
+			continue
+		}
+		routes = append(routes, LLMRouteConfig{Pattern: strings.TrimSpace(pattern), Provider: strings.TrimSpace(provider)})
+	}
+	return routes
+}
+
+// parseThresholds reads "TYPE=confidence" pairs separated by commas.
+func parseThresholds(value string) map[string]float64 {
+	thresholds := make(map[string]float64)
+	for _, pair := range strings.Split(value, ",") {
+		entityType, raw, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			continue
+		}
+		thresholds[strings.TrimSpace(entityType)] = threshold
+	}
+	return thresholds
 }
 
 func getEnv(key, defaultValue string) string {
@@ -32,3 +281,34 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/saferoute/proxy/internal/anonymizer"
+	"github.com/saferoute/proxy/internal/logging"
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/quota"
+	"github.com/saferoute/proxy/internal/services"
+	"github.com/saferoute/proxy/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultEmbeddingsNERBatchSize = 64
+
+// WithEmbeddings configures /v1/embeddings. Embeddings are never restored,
+// so tokenized entities are replaced irreversibly: with a keyed hash when
+// hashKey is set, which keeps equal values equal across requests, and
+// redacted otherwise. Inputs are sent to the NER service nerBatchSize at a
+// time.
+func WithEmbeddings(hashKey []byte, nerBatchSize int) Option {
+	return func(h *ProxyHandler) {
+		h.embeddingsHashKey = hashKey
+		h.embeddingsNERBatchSize = nerBatchSize
+	}
+}
+
+// HandleEmbeddings scrubs each input, forwards the request to the LLM
+// provider and returns its vectors unchanged. Nothing is stored in the
+// vault.
+func (h *ProxyHandler) HandleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	ensureRequestID(w, r)
+	logger := logging.FromContext(r.Context())
+
+	var req models.EmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Input) == 0 {
+		respondError(w, "Input must not be empty", http.StatusBadRequest)
+		return
+	}
+	logging.Add(r.Context(), "model", req.Model, "inputs", len(req.Input))
+
+	embedder, ok := h.llmClient.(services.EmbeddingService)
+	if !ok {
+		respondError(w, "Embeddings not supported by LLM provider", http.StatusNotImplemented)
+		return
+	}
+
+	reservation, ok := h.reserveQuota(w, r, quota.EstimateEmbeddings(req.Input), chatFormat{})
+	if !ok {
+		return
+	}
+	var usedTokens int64
+	defer func() { h.settleQuota(r.Context(), reservation, usedTokens) }()
+
+	nerStart := time.Now()
+	detected, err := h.detectEmbeddingEntities(r.Context(), req.Input)
+	if err != nil {
+		detected, err = h.degrade(w, r, req.Input, err)
+	}
+	if err != nil {
+		logger.Error("NER failed", "error", err)
+		respondError(w, "NER service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	logging.Add(r.Context(), "ner_ms", milliseconds(time.Since(nerStart)))
+
+	decision, err := h.applyPolicy(r.Context(), flattenEntities(detected))
+	if err != nil {
+		logger.Warn("request blocked by policy", "error", err)
+		respondPolicyError(w, chatFormat{}, err)
+		return
+	}
+	decision = decision.Irreversible(h.embeddingToken())
+	logging.Add(r.Context(), "entities", len(decision.Replace))
+
+	scrubbed := req
+	scrubbed.Input = make([]string, len(req.Input))
+	for i, input := range req.Input {
+		scrubbed.Input[i] = anonymizer.Anonymize(input, anonymizer.MessageEntities(decision.Replace, i))
+	}
+
+	llmStart := time.Now()
+	resp, err := h.embeddings(r.Context(), embedder, scrubbed)
+	if err != nil {
+		logger.Error("LLM failed", "error", err)
+		respondLLMError(w, chatFormat{}, err)
+		return
+	}
+	usedTokens = quota.Used(embeddingUsage(resp.Usage))
+	if usedTokens == 0 && reservation != nil {
+		// The provider reported no usage; keep the estimate.
+		usedTokens = reservation.Tokens
+	}
+	logging.Add(r.Context(), "llm_ms", milliseconds(time.Since(llmStart)), "llm_tokens", usedTokens)
+
+	w.Header().Set("X-Latency-Ms", fmt.Sprintf("%.2f", time.Since(startTime).Seconds()*1000))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// detectEmbeddingEntities detects entities in inputs, a batch of them per
+// NER call.
+func (h *ProxyHandler) detectEmbeddingEntities(ctx context.Context, inputs []string) ([][]models.Entity, error) {
+	size := h.embeddingsNERBatchSize
+	if size <= 0 {
+		size = defaultEmbeddingsNERBatchSize
+	}
+
+	detected := make([][]models.Entity, 0, len(inputs))
+	for start := 0; start < len(inputs); start += size {
+		batch, err := h.detectEntities(ctx, inputs[start:min(start+size, len(inputs))])
+		if err != nil {
+			return nil, err
+		}
+		detected = append(detected, batch...)
+	}
+	return detected, nil
+}
+
+// embeddingToken returns the replacement for tokenized entities, or nil to
+// redact them.
+func (h *ProxyHandler) embeddingToken() func(models.Entity) string {
+	if len(h.embeddingsHashKey) == 0 {
+		return nil
+	}
+	return func(entity models.Entity) string {
+		return anonymizer.HashToken(h.embeddingsHashKey, entity)
+	}
+}
+
+func (h *ProxyHandler) embeddings(ctx context.Context, embedder services.EmbeddingService, req models.EmbeddingRequest) (models.EmbeddingResponse, error) {
+	ctx, span := tracing.Start(ctx, "llm.embeddings", attribute.String("model", req.Model), attribute.Int("inputs", len(req.Input)))
+	start := time.Now()
+	resp, err := embedder.Embeddings(ctx, req)
+	llmDuration.WithLabelValues(h.modelLabel(req.Model), "false").Observe(seconds(start))
+	recordUpstreamError(upstreamLLM, err)
+	if err == nil {
+		recordTokens(h.modelLabel(req.Model), embeddingUsage(resp.Usage))
+		span.SetAttributes(attribute.Int("tokens", resp.Usage.TotalTokens))
+	}
+	tracing.End(span, err)
+	return resp, err
+}
+
+func embeddingUsage(usage models.EmbeddingUsage) models.Usage {
+	return models.Usage{PromptTokens: usage.PromptTokens, TotalTokens: usage.TotalTokens}
+}
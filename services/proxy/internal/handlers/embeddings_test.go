@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/requestid"
+)
+
+// emailNERClient finds ann@example.com in each text and records the size of
+// every batch it is sent.
+type emailNERClient struct {
+	batches []int
+}
+
+func (m *emailNERClient) DetectEntities(ctx context.Context, text string) ([]models.Entity, error) {
+	if pos := strings.Index(text, "ann@example.com"); pos >= 0 {
+		return []models.Entity{{Original: "ann@example.com", Token: "[EMAIL_001]", Type: "EMAIL", Position: pos, Confidence: 0.98}}, nil
+	}
+	return nil, nil
+}
+
+func (m *emailNERClient) DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	m.batches = append(m.batches, len(texts))
+	detected := make([][]models.Entity, len(texts))
+	for i, text := range texts {
+		detected[i], _ = m.DetectEntities(ctx, text)
+	}
+	return detected, nil
+}
+
+type embeddingLLMClient struct {
+	mockLLMClient
+	lastReq models.EmbeddingRequest
+}
+
+func (m *embeddingLLMClient) Embeddings(ctx context.Context, req models.EmbeddingRequest) (models.EmbeddingResponse, error) {
+	m.lastReq = req
+	resp := models.EmbeddingResponse{Object: "list", Model: req.Model, Usage: models.EmbeddingUsage{PromptTokens: 8, TotalTokens: 8}}
+	for i := range req.Input {
+		resp.Data = append(resp.Data, models.Embedding{Object: "embedding", Index: i, Embedding: json.RawMessage(`[0.1,-0.25]`)})
+	}
+	return resp, nil
+}
+
+func postEmbeddings(handler *ProxyHandler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body))
+	req = req.WithContext(requestid.With(req.Context(), "test-request-123"))
+	w := httptest.NewRecorder()
+	handler.HandleEmbeddings(w, req)
+	return w
+}
+
+func TestHandleEmbeddings(t *testing.T) {
+	nerClient := &emailNERClient{}
+	vaultClient := &recordingVaultClient{}
+	llmClient := &embeddingLLMClient{}
+	handler := NewProxyHandler(nerClient, vaultClient, llmClient, WithEmbeddings(nil, 2))
+
+	w := postEmbeddings(handler, `{
+		"model": "text-embedding-3-small",
+		"input": ["Write to ann@example.com", "No PII here", "ann@example.com again"]
+	}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(nerClient.batches) != 2 || nerClient.batches[0] != 2 || nerClient.batches[1] != 1 {
+		t.Errorf("Expected NER batches of 2 and 1, got %v", nerClient.batches)
+	}
+	want := []string{"Write to [REDACTED_EMAIL]", "No PII here", "[REDACTED_EMAIL] again"}
+	for i, input := range llmClient.lastReq.Input {
+		if input != want[i] {
+			t.Errorf("Input %d: expected %q, got %q", i, want[i], input)
+		}
+	}
+	if vaultClient.stored != nil {
+		t.Errorf("Expected nothing stored in the vault, got %+v", vaultClient.stored)
+	}
+
+	var resp models.EmbeddingResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 3 || string(resp.Data[2].Embedding) != `[0.1,-0.25]` {
+		t.Errorf("Expected the provider's vectors unchanged, got %+v", resp.Data)
+	}
+}
+
+func TestHandleEmbeddings_HashKey(t *testing.T) {
+	llmClient := &embeddingLLMClient{}
+	handler := NewProxyHandler(&emailNERClient{}, &mockVaultClient{}, llmClient, WithEmbeddings([]byte("secret"), 0))
+
+	w := postEmbeddings(handler, `{"model": "text-embedding-3-small", "input": ["ann@example.com", "Mail ann@example.com"]}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	first, second := llmClient.lastReq.Input[0], llmClient.lastReq.Input[1]
+	if !strings.HasPrefix(first, "[EMAIL_") || first == "[EMAIL_001]" {
+		t.Errorf("Expected a hashed token, got %q", first)
+	}
+	if second != "Mail "+first {
+		t.Errorf("Expected the same token in every input, got %q and %q", first, second)
+	}
+}
+
+func TestHandleEmbeddings_StringInput(t *testing.T) {
+	llmClient := &embeddingLLMClient{}
+	handler := NewProxyHandler(&emailNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := postEmbeddings(handler, `{"model": "text-embedding-3-small", "input": "Hi ann@example.com", "dimensions": 256}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(llmClient.lastReq.Input) != 1 || llmClient.lastReq.Input[0] != "Hi [REDACTED_EMAIL]" {
+		t.Errorf("Expected one scrubbed input, got %q", llmClient.lastReq.Input)
+	}
+	if string(llmClient.lastReq.Extra["dimensions"]) != "256" {
+		t.Errorf("Expected unknown fields to be forwarded, got %v", llmClient.lastReq.Extra)
+	}
+}
+
+func TestHandleEmbeddings_Unsupported(t *testing.T) {
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{})
+
+	w := postEmbeddings(handler, `{"model": "claude-3", "input": "hello"}`)
+
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501, got %d", w.Code)
+	}
+}
+
+func TestHandleEmbeddings_NERFailure(t *testing.T) {
+	llmClient := &embeddingLLMClient{}
+	handler := NewProxyHandler(&mockNERClient{shouldFail: true}, &mockVaultClient{}, llmClient)
+
+	w := postEmbeddings(handler, `{"model": "text-embedding-3-small", "input": ["hello"]}`)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	if llmClient.lastReq.Input != nil {
+		t.Error("Expected the request not to reach the LLM")
+	}
+}
+
+func TestHandleEmbeddings_InvalidInput(t *testing.T) {
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &embeddingLLMClient{})
+
+	for _, body := range []string{`{"model": "m", "input": [1, 2]}`, `{"model": "m", "input": []}`} {
+		if w := postEmbeddings(handler, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, w.Code)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/saferoute/proxy/internal/health"
 )
 
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
@@ -14,9 +16,18 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func ReadinessCheck(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status": "ready",
-	})
+// ReadinessCheck answers 503 while a critical dependency is down so that
+// traffic moves to other replicas. The body breaks the result down per
+// dependency either way.
+func ReadinessCheck(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Report(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !report.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
 }
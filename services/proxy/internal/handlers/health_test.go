@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/saferoute/proxy/internal/health"
 )
 
 func TestHealthCheck(t *testing.T) {
@@ -34,10 +40,16 @@ func TestHealthCheck(t *testing.T) {
 }
 
 func TestReadinessCheck(t *testing.T) {
+	checker := health.NewChecker(time.Second, health.Check{
+		Name:     "vault",
+		Critical: true,
+		Probe:    func(context.Context) error { return nil },
+	})
+
 	req := httptest.NewRequest("GET", "/ready", nil)
 	w := httptest.NewRecorder()
 
-	ReadinessCheck(w, req)
+	ReadinessCheck(checker)(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status 200, got %d", w.Code)
@@ -50,3 +62,33 @@ func TestReadinessCheck(t *testing.T) {
 		t.Error("Expected status to be 'ready'")
 	}
 }
+
+func TestReadinessCheck_CriticalDependencyDown(t *testing.T) {
+	checker := health.NewChecker(time.Second,
+		health.Check{Name: "ner", Critical: true, Probe: func(context.Context) error { return errors.New("connection refused") }},
+		health.Check{Name: "vault", Critical: true, Probe: func(context.Context) error { return nil }},
+	)
+
+	req := httptest.NewRequest("GET", "/ready", nil)
+	w := httptest.NewRecorder()
+
+	ReadinessCheck(checker)(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	var response health.Report
+	json.Unmarshal([]byte(body), &response)
+
+	if response.Status != health.StatusNotReady {
+		t.Errorf("Expected status not_ready, got %s", response.Status)
+	}
+	if response.Dependencies["ner"].Status != health.StatusDown || response.Dependencies["vault"].Status != health.StatusUp {
+		t.Errorf("Unexpected breakdown: %+v", response.Dependencies)
+	}
+	if strings.Contains(body, "connection refused") {
+		t.Errorf("Expected probe errors to stay out of the body, got %s", body)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/logging"
+	"github.com/saferoute/proxy/internal/middleware"
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/policy"
+)
+
+const loggedPrompt = "My email is john@example.com and SSN is 123-45-6789"
+
+// piiInLogs lists every value that must never be logged: the entity
+// originals returned by mockNERClient, the prompt, and the restored answer.
+var piiInLogs = []string{"john@example.com", "123-45-6789", loggedPrompt, "LLM response with"}
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(&buf, "debug"))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func serveLogged(handler *ProxyHandler, stream bool) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.ChatCompletionRequest{
+		Model:    "claude-3",
+		Messages: []models.Message{{Role: "user", Content: loggedPrompt}},
+		Stream:   stream,
+	})
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	middleware.Chain(http.HandlerFunc(handler.HandleChatCompletion), middleware.RequestID, middleware.Logger).ServeHTTP(w, req)
+	return w
+}
+
+func TestLogs_NeverContainPII(t *testing.T) {
+	blocking := &policy.Policy{
+		Default:  policy.Rule{Action: policy.ActionTokenize},
+		Entities: map[string]policy.Rule{"SSN": {Action: policy.ActionBlock}},
+	}
+
+	tests := []struct {
+		name    string
+		handler *ProxyHandler
+		stream  bool
+	}{
+		{"success", NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{}), false},
+		{"stream", NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockStreamingLLMClient{
+			stream: &mockStream{chunks: deltaChunks("LLM response with ", "[EMAIL_001]")},
+		}), true},
+		{"NER failure", NewProxyHandler(&mockNERClient{shouldFail: true}, &mockVaultClient{}, &mockLLMClient{}), false},
+		{"vault failure", NewProxyHandler(&mockNERClient{}, &mockVaultClient{shouldFailStore: true}, &mockLLMClient{}), false},
+		{"LLM failure", NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{shouldFail: true}), false},
+		{"blocked", NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{}, WithPolicy(blocking)), false},
+		{"stream unsupported", NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{}), true},
+		{"unrouted", NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockUnroutedLLMClient{}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			serveLogged(tt.handler, tt.stream)
+
+			out := logs.String()
+			if !strings.Contains(out, `"msg":"request completed"`) {
+				t.Fatalf("Expected an access log line, got:\n%s", out)
+			}
+			for _, leak := range piiInLogs {
+				if strings.Contains(out, leak) {
+					t.Errorf("Logs leaked %q:\n%s", leak, out)
+				}
+			}
+		})
+	}
+}
+
+func TestLogs_RequestFields(t *testing.T) {
+	logs := captureLogs(t)
+	serveLogged(NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{}), false)
+
+	var completed map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected JSON log lines, got %q", line)
+		}
+		if entry["msg"] == "request completed" {
+			completed = entry
+		}
+	}
+
+	for _, field := range []string{"request_id", "method", "path", "status", "latency_ms", "model", "entities", "ner_ms", "vault_store_ms", "llm_ms", "vault_get_ms"} {
+		if _, ok := completed[field]; !ok {
+			t.Errorf("Expected %s in the access log, got %v", field, completed)
+		}
+	}
+}
+
+func TestLogs_DebugCarriesCountsOnly(t *testing.T) {
+	// A plain handler, without the redaction that logging.New installs.
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	serveLogged(NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{}), false)
+
+	out := buf.String()
+	if !strings.Contains(out, `"types":{"EMAIL":1,"SSN":1}`) {
+		t.Errorf("Expected entity counts by type, got:\n%s", out)
+	}
+	for _, leak := range piiInLogs {
+		if strings.Contains(out, leak) {
+			t.Errorf("Logs leaked %q:\n%s", leak, out)
+		}
+	}
+}
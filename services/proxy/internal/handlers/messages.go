@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/quota"
+)
+
+// HandleMessages serves the Anthropic Messages API. The request is
+// translated to a chat completion, which goes through the same pipeline as
+// HandleChatCompletion, and the answer is translated back. The model still
+// picks the provider, so Anthropic clients can reach any routed model.
+func (h *ProxyHandler) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	var in models.AnthropicRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		ensureRequestID(w, r)
+		messagesFormat{}.respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req, err := fromAnthropicRequest(in)
+	if err != nil {
+		ensureRequestID(w, r)
+		messagesFormat{}.respondError(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.complete(w, r, req, messagesFormat{})
+}
+
+// fromAnthropicRequest maps a Messages API request to a chat completion
+// request: the system prompt becomes a system message, tool_result blocks
+// become tool messages and tool_use blocks become tool calls. Fields with no
+// chat completion equivalent, such as top_k, metadata or a block's
+// cache_control, are dropped; the README lists them.
+func fromAnthropicRequest(in models.AnthropicRequest) (models.ChatCompletionRequest, error) {
+	req := models.ChatCompletionRequest{
+		Model:       in.Model,
+		Temperature: in.Temperature,
+		TopP:        in.TopP,
+		MaxTokens:   in.MaxTokens,
+		Stream:      in.Stream,
+	}
+	if len(in.StopSequences) > 0 {
+		stop, err := json.Marshal(in.StopSequences)
+		if err != nil {
+			return models.ChatCompletionRequest{}, err
+		}
+		req.Stop = stop
+	}
+
+	if len(in.System) > 0 {
+		system := models.Message{Role: "system"}
+		if err := setAnthropicContent(&system, in.System); err != nil {
+			return models.ChatCompletionRequest{}, err
+		}
+		req.Messages = append(req.Messages, system)
+	}
+	for _, msg := range in.Messages {
+		messages, err := fromAnthropicMessage(msg)
+		if err != nil {
+			return models.ChatCompletionRequest{}, err
+		}
+		req.Messages = append(req.Messages, messages...)
+	}
+
+	for _, tool := range in.Tools {
+		req.Tools = append(req.Tools, models.Tool{
+			Type: "function",
+			Function: models.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
+	if in.ToolChoice != nil {
+		choice, err := fromAnthropicToolChoice(*in.ToolChoice)
+		if err != nil {
+			return models.ChatCompletionRequest{}, err
+		}
+		req.ToolChoice = choice
+		if in.ToolChoice.DisableParallelToolUse {
+			parallel := false
+			req.ParallelToolCalls = &parallel
+		}
+	}
+	return req, nil
+}
+
+// fromAnthropicMessage maps one turn to chat messages. A user turn's
+// tool_result blocks come first, as tool messages, followed by the rest of
+// its content.
+func fromAnthropicMessage(msg models.AnthropicMessage) ([]models.Message, error) {
+	if msg.Role != "user" && msg.Role != "assistant" {
+		return nil, fmt.Errorf("unsupported role %q", msg.Role)
+	}
+
+	var messages []models.Message
+	out := models.Message{Role: msg.Role}
+	var content models.AnthropicContent
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "tool_use":
+			if msg.Role != "assistant" {
+				return nil, errors.New("tool_use block outside an assistant turn")
+			}
+			args := string(block.Input)
+			if args == "" {
+				args = "{}"
+			}
+			out.ToolCalls = append(out.ToolCalls, models.ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: models.FunctionCall{Name: block.Name, Arguments: args},
+			})
+		case "tool_result":
+			if msg.Role != "user" {
+				return nil, errors.New("tool_result block outside a user turn")
+			}
+			result := models.Message{Role: "tool", ToolCallID: block.ToolUseID}
+			resultContent := block.Content
+			if block.IsError {
+				resultContent = markToolError(resultContent)
+			}
+			if err := setAnthropicContent(&result, resultContent); err != nil {
+				return nil, err
+			}
+			messages = append(messages, result)
+		default:
+			content = append(content, block)
+		}
+	}
+
+	if err := setAnthropicContent(&out, content); err != nil {
+		return nil, err
+	}
+	if len(content) > 0 || len(out.ToolCalls) > 0 {
+		messages = append(messages, out)
+	}
+	return messages, nil
+}
+
+// toolErrorPrefix starts the content of a tool_result that has is_error
+// set. Chat completions have no such flag, so the model is told in the
+// result itself.
+const toolErrorPrefix = "Error: "
+
+// markToolError returns content with toolErrorPrefix in front of its first
+// text block, or in a text block of its own.
+func markToolError(content models.AnthropicContent) models.AnthropicContent {
+	if len(content) > 0 && content[0].Type == "text" {
+		marked := append(models.AnthropicContent{}, content...)
+		marked[0].Text = toolErrorPrefix + marked[0].Text
+		return marked
+	}
+	prefix := models.AnthropicContentBlock{Type: "text", Text: strings.TrimSpace(toolErrorPrefix)}
+	return append(models.AnthropicContent{prefix}, content...)
+}
+
+// setAnthropicContent sets msg's content from text and image blocks: a lone
+// text block as Content, anything else as Parts.
+func setAnthropicContent(msg *models.Message, content models.AnthropicContent) error {
+	if len(content) == 1 && content[0].Type == "text" {
+		msg.Content = content[0].Text
+		return nil
+	}
+
+	var parts []models.ContentPart
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			parts = append(parts, models.ContentPart{Type: models.PartText, Text: block.Text})
+		case "image":
+			url, err := fromAnthropicImageSource(block.Source)
+			if err != nil {
+				return err
+			}
+			parts = append(parts, models.ContentPart{Type: models.PartImageURL, ImageURL: &models.ImageURL{URL: url}})
+		default:
+			return fmt.Errorf("unsupported content block %q", block.Type)
+		}
+	}
+	msg.Parts = parts
+	return nil
+}
+
+// fromAnthropicImageSource turns a base64 source into a data URL.
+func fromAnthropicImageSource(source *models.AnthropicImageSource) (string, error) {
+	if source == nil {
+		return "", errors.New("image block without a source")
+	}
+	switch source.Type {
+	case "base64":
+		return "data:" + source.MediaType + ";base64," + source.Data, nil
+	case "url":
+		return source.URL, nil
+	default:
+		return "", fmt.Errorf("unsupported image source %q", source.Type)
+	}
+}
+
+// fromAnthropicToolChoice maps "auto", "any", "none" or a named tool to the
+// chat completions tool_choice.
+func fromAnthropicToolChoice(choice models.AnthropicToolChoice) (json.RawMessage, error) {
+	switch choice.Type {
+	case "auto":
+		return json.RawMessage(`"auto"`), nil
+	case "any":
+		return json.RawMessage(`"required"`), nil
+	case "none":
+		return json.RawMessage(`"none"`), nil
+	case "tool":
+		return json.Marshal(map[string]interface{}{
+			"type":     "function",
+			"function": map[string]string{"name": choice.Name},
+		})
+	default:
+		return nil, fmt.Errorf("unsupported tool_choice %q", choice.Type)
+	}
+}
+
+// toAnthropicResponse maps the first choice of a chat completion to a
+// Messages API response.
+func toAnthropicResponse(resp models.ChatCompletionResponse) models.AnthropicResponse {
+	out := models.AnthropicResponse{
+		ID:      resp.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   resp.Model,
+		Content: []models.AnthropicContentBlock{},
+		Usage: models.AnthropicUsage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		},
+	}
+	if len(resp.Choices) == 0 {
+		return out
+	}
+
+	choice := resp.Choices[0]
+	if text := choice.Message.Text(); text != "" {
+		out.Content = append(out.Content, models.AnthropicContentBlock{Type: "text", Text: text})
+	}
+	for _, call := range choice.Message.ToolCalls {
+		out.Content = append(out.Content, models.AnthropicContentBlock{
+			Type:  "tool_use",
+			ID:    call.ID,
+			Name:  call.Function.Name,
+			Input: toolInput(call.Function.Arguments),
+		})
+	}
+	out.StopReason = anthropicStopReason(choice.FinishReason)
+	return out
+}
+
+// toolInput returns tool call arguments as a tool_use input, which must be
+// a JSON object.
+func toolInput(args string) json.RawMessage {
+	if !json.Valid([]byte(args)) || !strings.HasPrefix(strings.TrimSpace(args), "{") {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(args)
+}
+
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "stop":
+		return "end_turn"
+	case "length":
+		return "max_tokens"
+	case "tool_calls":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return finishReason
+	}
+}
+
+// anthropicErrorType returns the Anthropic error type for an HTTP status.
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	default:
+		return "api_error"
+	}
+}
+
+// messagesFormat is the Anthropic Messages API format.
+type messagesFormat struct{}
+
+func (messagesFormat) respondError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(anthropicError(message, status))
+}
+
+func (f messagesFormat) respondQuotaExceeded(w http.ResponseWriter, exceeded *quota.ExceededError) {
+	f.respondError(w, fmt.Sprintf("Token quota exceeded: %s limit of %d tokens", exceeded.Period, exceeded.Limit), http.StatusTooManyRequests)
+}
+
+func (messagesFormat) respondCompletion(w http.ResponseWriter, resp models.ChatCompletionResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toAnthropicResponse(resp))
+}
+
+func (messagesFormat) newStreamEncoder(w http.ResponseWriter, rc *http.ResponseController) streamEncoder {
+	return &messagesStreamEncoder{w: w, rc: rc, toolBlocks: make(map[int]int)}
+}
+
+func anthropicError(message string, status int) models.AnthropicErrorResponse {
+	return models.AnthropicErrorResponse{
+		Type:  "error",
+		Error: models.AnthropicError{Type: anthropicErrorType(status), Message: message},
+	}
+}
+
+// messagesStreamEncoder turns chat completion chunks into Messages API
+// stream events. Only the first choice is relayed: text deltas and tool
+// calls become content blocks, opened and closed in order, and the finish
+// reason and usage are sent in message_delta when the stream closes.
+type messagesStreamEncoder struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	started bool
+	// blocks counts the content blocks started so far; open is the type of
+	// the last one while it is open.
+	blocks int
+	open   string
+	// toolBlocks maps each tool call index to its content block index.
+	toolBlocks map[int]int
+	stopReason string
+	usage      *models.Usage
+}
+
+func (e *messagesStreamEncoder) writeChunk(chunk models.ChatCompletionChunk) error {
+	if !e.started {
+		e.started = true
+		err := e.event("message_start", map[string]interface{}{
+			"message": map[string]interface{}{
+				"id":            chunk.ID,
+				"type":          "message",
+				"role":          "assistant",
+				"model":         chunk.Model,
+				"content":       []interface{}{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         models.AnthropicUsage{},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if chunk.Usage != nil {
+		e.usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" {
+			if err := e.text(choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+		for j, call := range choice.Delta.ToolCalls {
+			if err := e.toolCall(call, toolCallIndex(call, j)); err != nil {
+				return err
+			}
+		}
+		if choice.FinishReason != nil {
+			e.stopReason = anthropicStopReason(*choice.FinishReason)
+		}
+	}
+	return nil
+}
+
+func (e *messagesStreamEncoder) text(text string) error {
+	if e.open != "text" {
+		if err := e.startBlock(map[string]interface{}{"type": "text", "text": ""}); err != nil {
+			return err
+		}
+		e.open = "text"
+	}
+	return e.event("content_block_delta", map[string]interface{}{
+		"index": e.blocks - 1,
+		"delta": map[string]string{"type": "text_delta", "text": text},
+	})
+}
+
+func (e *messagesStreamEncoder) toolCall(call models.ToolCall, index int) error {
+	if call.ID != "" {
+		err := e.startBlock(map[string]interface{}{
+			"type":  "tool_use",
+			"id":    call.ID,
+			"name":  call.Function.Name,
+			"input": map[string]interface{}{},
+		})
+		if err != nil {
+			return err
+		}
+		e.open = "tool_use"
+		e.toolBlocks[index] = e.blocks - 1
+	}
+
+	block, ok := e.toolBlocks[index]
+	if !ok || call.Function.Arguments == "" {
+		return nil
+	}
+	if e.open != "tool_use" || block != e.blocks-1 {
+		// Blocks cannot be reopened; arguments for a call that has been
+		// closed have nowhere to go.
+		return fmt.Errorf("%w: arguments for tool call %d after its block closed", errStreamEncode, index)
+	}
+	return e.event("content_block_delta", map[string]interface{}{
+		"index": block,
+		"delta": map[string]string{"type": "input_json_delta", "partial_json": call.Function.Arguments},
+	})
+}
+
+func (e *messagesStreamEncoder) startBlock(block map[string]interface{}) error {
+	if err := e.stopBlock(); err != nil {
+		return err
+	}
+	e.blocks++
+	return e.event("content_block_start", map[string]interface{}{
+		"index":         e.blocks - 1,
+		"content_block": block,
+	})
+}
+
+func (e *messagesStreamEncoder) stopBlock() error {
+	if e.open == "" {
+		return nil
+	}
+	e.open = ""
+	return e.event("content_block_stop", map[string]interface{}{"index": e.blocks - 1})
+}
+
+func (e *messagesStreamEncoder) writeError(message string) {
+	data, _ := json.Marshal(anthropicError(message, http.StatusInternalServerError))
+	writeSSE(e.w, e.rc, "error", string(data))
+}
+
+func (e *messagesStreamEncoder) close() error {
+	if !e.started {
+		if err := e.writeChunk(models.ChatCompletionChunk{}); err != nil {
+			return err
+		}
+	}
+	if err := e.stopBlock(); err != nil {
+		return err
+	}
+
+	stopReason := e.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	var usage models.AnthropicUsage
+	if e.usage != nil {
+		usage = models.AnthropicUsage{InputTokens: e.usage.PromptTokens, OutputTokens: e.usage.CompletionTokens}
+	}
+	err := e.event("message_delta", map[string]interface{}{
+		"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": usage,
+	})
+	if err != nil {
+		return err
+	}
+	return e.event("message_stop", map[string]interface{}{})
+}
+
+// event writes a stream event; the "type" field repeats its name.
+func (e *messagesStreamEncoder) event(name string, fields map[string]interface{}) error {
+	fields["type"] = name
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errStreamEncode, err)
+	}
+	return writeSSE(e.w, e.rc, name, string(data))
+}
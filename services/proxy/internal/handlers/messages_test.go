@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/requestid"
+)
+
+func postMessages(handler *ProxyHandler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+	req = req.WithContext(requestid.With(req.Context(), "test-request-123"))
+	w := httptest.NewRecorder()
+	handler.HandleMessages(w, req)
+	return w
+}
+
+func TestHandleMessages(t *testing.T) {
+	llmClient := &capturingLLMClient{}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := postMessages(handler, `{
+		"model": "claude-3",
+		"max_tokens": 256,
+		"system": [{"type": "text", "text": "Reply to john@example.com."}],
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "My email is john@example.com and SSN is 123-45-6789"}
+		]}],
+		"stop_sequences": ["END"]
+	}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	sent := llmClient.lastReq
+	if len(sent.Messages) != 2 || sent.Messages[0].Role != "system" || sent.Messages[1].Role != "user" {
+		t.Fatalf("Expected system and user messages, got %+v", sent.Messages)
+	}
+	for _, msg := range sent.Messages {
+		if strings.Contains(msg.Content, "john@example.com") || strings.Contains(msg.Content, "123-45-6789") {
+			t.Errorf("Expected PII to be tokenized, got %q", msg.Content)
+		}
+	}
+	if string(sent.Stop) != `["END"]` || sent.MaxTokens != 256 {
+		t.Errorf("Expected stop sequences and max_tokens to be forwarded, got %s and %d", sent.Stop, sent.MaxTokens)
+	}
+
+	var resp models.AnthropicResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != "message" || resp.Role != "assistant" || resp.StopReason != "end_turn" {
+		t.Errorf("Expected an Anthropic message, got %+v", resp)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Text != "LLM response with john@example.com and 123-45-6789" {
+		t.Errorf("Expected restored text block, got %+v", resp.Content)
+	}
+	if resp.Usage.InputTokens != 10 || resp.Usage.OutputTokens != 5 {
+		t.Errorf("Expected usage 10/5, got %+v", resp.Usage)
+	}
+}
+
+func TestHandleMessages_ToolUse(t *testing.T) {
+	llmClient := &toolCallingLLMClient{}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := postMessages(handler, `{
+		"model": "claude-3",
+		"max_tokens": 256,
+		"tools": [{"name": "send_email", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "any", "disable_parallel_tool_use": true},
+		"messages": [
+			{"role": "user", "content": "Email my SSN"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "lookup", "input": {}}]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "SSN is 123-45-6789"},
+				{"type": "text", "text": "Send it to john@example.com"}
+			]}
+		]
+	}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	sent := llmClient.lastReq
+	roles := make([]string, len(sent.Messages))
+	for i, msg := range sent.Messages {
+		roles[i] = msg.Role
+	}
+	if strings.Join(roles, ",") != "user,assistant,tool,user" {
+		t.Fatalf("Expected user,assistant,tool,user, got %v", roles)
+	}
+	if call := sent.Messages[1].ToolCalls; len(call) != 1 || call[0].ID != "toolu_1" || call[0].Function.Arguments != "{}" {
+		t.Errorf("Expected tool_use as a tool call, got %+v", call)
+	}
+	if tool := sent.Messages[2]; tool.ToolCallID != "toolu_1" || strings.Contains(tool.Content, "123-45-6789") {
+		t.Errorf("Expected tokenized tool result, got %+v", tool)
+	}
+	if string(sent.ToolChoice) != `"required"` || sent.ParallelToolCalls == nil || *sent.ParallelToolCalls {
+		t.Errorf("Expected required tool choice without parallel calls, got %s", sent.ToolChoice)
+	}
+
+	var resp models.AnthropicResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.StopReason != "tool_use" || len(resp.Content) != 1 || resp.Content[0].Type != "tool_use" {
+		t.Fatalf("Expected a tool_use block, got %+v", resp)
+	}
+	if input := string(resp.Content[0].Input); input != `{"to":"john@example.com","ssn":"123-45-6789"}` {
+		t.Errorf("Expected restored tool input, got %s", input)
+	}
+}
+
+func TestFromAnthropicMessage_ToolResultError(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"text", `"connection refused"`, "Error: connection refused"},
+		{"blocks", `[{"type": "text", "text": "timed out"}]`, "Error: timed out"},
+		{"empty", `[]`, "Error:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg models.AnthropicMessage
+			data := `{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "is_error": true, "content": ` + tt.content + `}]}`
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				t.Fatal(err)
+			}
+
+			messages, err := fromAnthropicMessage(msg)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(messages) != 1 || messages[0].Role != "tool" || messages[0].Text() != tt.want {
+				t.Errorf("Expected a tool message %q, got %+v", tt.want, messages)
+			}
+		})
+	}
+}
+
+func TestHandleMessages_InvalidRequest(t *testing.T) {
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{})
+
+	w := postMessages(handler, `{
+		"model": "claude-3",
+		"max_tokens": 256,
+		"messages": [{"role": "user", "content": [{"type": "document", "source": {"type": "text"}}]}]
+	}`)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", w.Code)
+	}
+	var resp models.AnthropicErrorResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Type != "error" || resp.Error.Type != "invalid_request_error" || !strings.Contains(resp.Error.Message, "document") {
+		t.Errorf("Expected an Anthropic error, got %+v", resp)
+	}
+}
+
+// readMessageEvents returns the stream's event names and the text of its
+// text deltas.
+func readMessageEvents(t *testing.T, body *bytes.Buffer) ([]string, string) {
+	t.Helper()
+
+	var names []string
+	var text strings.Builder
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			names = append(names, name)
+			continue
+		}
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Text string `json:"text"`
+			} `json:"delta"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("Invalid event %q: %v", data, err)
+		}
+		if event.Type != names[len(names)-1] {
+			t.Errorf("Expected data type %q, got %q", names[len(names)-1], event.Type)
+		}
+		text.WriteString(event.Delta.Text)
+	}
+	return names, text.String()
+}
+
+func TestHandleMessages_Stream(t *testing.T) {
+	llmClient := &mockStreamingLLMClient{
+		stream: &mockStream{chunks: deltaChunks("Reach [EMAIL", "_001] today")},
+	}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := postMessages(handler, `{
+		"model": "claude-3",
+		"max_tokens": 256,
+		"stream": true,
+		"messages": [{"role": "user", "content": "My email is john@example.com and SSN is 123-45-6789"}]
+	}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	names, text := readMessageEvents(t, w.Body)
+	want := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop,message_delta,message_stop"
+	if strings.Join(names, ",") != want {
+		t.Errorf("Expected events %s, got %s", want, strings.Join(names, ","))
+	}
+	if text != "Reach john@example.com today" {
+		t.Errorf("Expected restored text, got %q", text)
+	}
+}
+
+func TestHandleMessages_StreamEncodingError(t *testing.T) {
+	first, second := 0, 1
+	toolChunk := func(call models.ToolCall) models.ChatCompletionChunk {
+		return models.ChatCompletionChunk{ID: "chunk-1", Choices: []models.ChunkChoice{{Delta: models.Delta{ToolCalls: []models.ToolCall{call}}}}}
+	}
+	// Arguments for the first call after the second has opened its block
+	// cannot be expressed as Anthropic events.
+	llmClient := &mockStreamingLLMClient{stream: &mockStream{chunks: []models.ChatCompletionChunk{
+		toolChunk(models.ToolCall{Index: &first, ID: "call_1", Function: models.FunctionCall{Name: "a"}}),
+		toolChunk(models.ToolCall{Index: &second, ID: "call_2", Function: models.FunctionCall{Name: "b"}}),
+		toolChunk(models.ToolCall{Index: &first, Function: models.FunctionCall{Arguments: `{"x":1}`}}),
+	}}}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := postMessages(handler, `{
+		"model": "claude-3",
+		"max_tokens": 256,
+		"stream": true,
+		"messages": [{"role": "user", "content": "Hello"}]
+	}`)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "event: error") || !strings.Contains(body, "Stream encoding failed") {
+		t.Errorf("Expected an error event, got %s", body)
+	}
+	if strings.Contains(body, "message_stop") {
+		t.Errorf("Expected the stream not to complete, got %s", body)
+	}
+}
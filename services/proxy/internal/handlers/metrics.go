@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/services"
+)
+
+var (
+	nerDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ner_duration_seconds",
+			Help:    "Time spent detecting entities in one request",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+	)
+
+	vaultDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "vault_duration_seconds",
+			Help:    "Vault call duration in seconds by operation",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5},
+		},
+		[]string{"operation"},
+	)
+
+	llmDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "llm_duration_seconds",
+			Help:    "LLM call duration in seconds by routing pattern, including retries and failover; streams are timed to the last chunk",
+			Buckets: []float64{.25, .5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+		},
+		[]string{"model", "stream"},
+	)
+
+	entitiesDetectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "entities_detected_total",
+			Help: "Total number of entities detected by type",
+		},
+		[]string{"type"},
+	)
+
+	llmTokensTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "llm_tokens_total",
+			Help: "Total number of LLM tokens used by routing pattern and kind (prompt or completion)",
+		},
+		[]string{"model", "kind"},
+	)
+
+	upstreamErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "upstream_errors_total",
+			Help: "Total number of failed calls to the NER service, vault and LLM by cause",
+		},
+		[]string{"service", "cause"},
+	)
+
+	nerDegradedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ner_degraded_requests_total",
+			Help: "Total number of requests served in a degraded mode after NER failed",
+		},
+		[]string{"mode", "route"},
+	)
+)
+
+// Upstream service names used as metric labels.
+const (
+	upstreamNER   = "ner"
+	upstreamVault = "vault"
+	upstreamLLM   = "llm"
+)
+
+func recordUpstreamError(service string, err error) {
+	if err != nil {
+		upstreamErrorsTotal.WithLabelValues(service, services.ErrorCause(err)).Inc()
+	}
+}
+
+func recordEntities(entities []models.Entity) {
+	for _, entity := range entities {
+		entitiesDetectedTotal.WithLabelValues(entity.Type).Inc()
+	}
+}
+
+// modelLabel is the bounded "model" label for requests to model: the
+// routing pattern that serves it rather than the client-supplied name.
+func (h *ProxyHandler) modelLabel(model string) string {
+	if labeler, ok := h.llmClient.(services.ModelLabeler); ok {
+		return labeler.ModelLabel(model)
+	}
+	return services.ModelLabelOther
+}
+
+func recordTokens(model string, usage models.Usage) {
+	llmTokensTotal.WithLabelValues(model, "prompt").Add(float64(usage.PromptTokens))
+	llmTokensTotal.WithLabelValues(model, "completion").Add(float64(usage.CompletionTokens))
+}
+
+func seconds(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
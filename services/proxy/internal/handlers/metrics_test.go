@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/requestid"
+	"github.com/saferoute/proxy/internal/services"
+)
+
+func postChatCompletion(handler *ProxyHandler, req models.ChatCompletionRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	r = r.WithContext(requestid.With(r.Context(), "test-request-123"))
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, r)
+	return w
+}
+
+func TestMetrics_ChatCompletion(t *testing.T) {
+	router := services.NewLLMRouter(nil)
+	router.Handle("metrics-*", &mockLLMClient{})
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, router)
+
+	// Models are labelled by the pattern that routes them, never by the
+	// name the client sent.
+	beforeUnrouted := sampleCount(llmDuration.WithLabelValues(services.ModelLabelUnrouted, "false"))
+	postChatCompletion(handler, models.ChatCompletionRequest{
+		Model:    "random-3f9a",
+		Messages: []models.Message{{Role: "user", Content: "Hi"}},
+	})
+
+	emails := entitiesDetectedTotal.WithLabelValues("EMAIL")
+	prompt := llmTokensTotal.WithLabelValues("metrics-*", "prompt")
+	completion := llmTokensTotal.WithLabelValues("metrics-*", "completion")
+	beforeEmails, beforePrompt, beforeCompletion := testutil.ToFloat64(emails), testutil.ToFloat64(prompt), testutil.ToFloat64(completion)
+	beforeLLM := sampleCount(llmDuration.WithLabelValues("metrics-*", "false"))
+
+	w := postChatCompletion(handler, models.ChatCompletionRequest{
+		Model:    "metrics-model-a1b2",
+		Messages: []models.Message{{Role: "user", Content: "My email is john@example.com"}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	if got := testutil.ToFloat64(emails) - beforeEmails; got != 1 {
+		t.Errorf("Expected 1 EMAIL entity counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(prompt) - beforePrompt; got != 10 {
+		t.Errorf("Expected 10 prompt tokens, got %v", got)
+	}
+	if got := testutil.ToFloat64(completion) - beforeCompletion; got != 5 {
+		t.Errorf("Expected 5 completion tokens, got %v", got)
+	}
+	if got := sampleCount(llmDuration.WithLabelValues("metrics-*", "false")) - beforeLLM; got != 1 {
+		t.Errorf("Expected 1 LLM latency observation, got %d", got)
+	}
+	if got := sampleCount(llmDuration.WithLabelValues(services.ModelLabelUnrouted, "false")) - beforeUnrouted; got != 1 {
+		t.Errorf("Expected the unrouted model under %q, got %d", services.ModelLabelUnrouted, got)
+	}
+}
+
+func sampleCount(o prometheus.Observer) uint64 {
+	var m dto.Metric
+	o.(prometheus.Metric).Write(&m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetrics_UpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler *ProxyHandler
+		service string
+		cause   string
+	}{
+		{"ner", NewProxyHandler(&mockNERClient{shouldFail: true}, &mockVaultClient{}, &mockLLMClient{}), upstreamNER, "other"},
+		{"vault", NewProxyHandler(&mockNERClient{}, &mockVaultClient{shouldFailStore: true}, &mockLLMClient{}), upstreamVault, "other"},
+		{"llm", NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &statusLLMClient{status: http.StatusTooManyRequests}), upstreamLLM, "rate_limited"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := upstreamErrorsTotal.WithLabelValues(tt.service, tt.cause)
+			before := testutil.ToFloat64(counter)
+
+			postChatCompletion(tt.handler, models.ChatCompletionRequest{
+				Model:    "claude-3",
+				Messages: []models.Message{{Role: "user", Content: "Hello"}},
+			})
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("Expected 1 %s error with cause %s, got %v", tt.service, tt.cause, got)
+			}
+		})
+	}
+}
+
+type statusLLMClient struct {
+	status int
+}
+
+func (m *statusLLMClient) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	return models.ChatCompletionResponse{}, &services.ProviderError{StatusCode: m.status}
+}
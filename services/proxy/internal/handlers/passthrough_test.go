@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/requestid"
+	"github.com/saferoute/proxy/internal/services"
+)
+
+// The passthrough tests send testdata requests through the handler to a real
+// OpenAI client and compare what each side receives with golden files:
+// only text-bearing fields may differ, and every field SafeRoute does not
+// model must survive unchanged.
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// assertJSONEqual compares two JSON documents ignoring formatting and key
+// order.
+func assertJSONEqual(t *testing.T, golden string, want, got []byte) {
+	t.Helper()
+
+	var wantValue, gotValue any
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatalf("Invalid golden file %s: %v", golden, err)
+	}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("Invalid JSON compared with %s: %v\n%s", golden, err, got)
+	}
+	if !reflect.DeepEqual(wantValue, gotValue) {
+		t.Errorf("Mismatch with %s\nwant: %s\n got: %s", golden, compactJSON(want), compactJSON(got))
+	}
+}
+
+func compactJSON(data []byte) string {
+	var out bytes.Buffer
+	if err := json.Compact(&out, data); err != nil {
+		return string(data)
+	}
+	return out.String()
+}
+
+func passthroughHandler(t *testing.T, upstream http.HandlerFunc) *ProxyHandler {
+	t.Helper()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	llm, err := services.NewLLMClient(services.ProviderOpenAI, server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llm)
+}
+
+func servePassthrough(handler *ProxyHandler, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(body))
+	req = req.WithContext(requestid.With(req.Context(), "test-request-123"))
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, req)
+	return w
+}
+
+func TestPassthrough_ChatCompletion(t *testing.T) {
+	var forwarded []byte
+	handler := passthroughHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		w.Write(readTestdata(t, "passthrough_response.json"))
+	})
+
+	w := servePassthrough(handler, readTestdata(t, "passthrough_request.json"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	assertJSONEqual(t, "passthrough_request.golden.json", readTestdata(t, "passthrough_request.golden.json"), forwarded)
+	assertJSONEqual(t, "passthrough_response.golden.json", readTestdata(t, "passthrough_response.golden.json"), w.Body.Bytes())
+}
+
+func TestPassthrough_Stream(t *testing.T) {
+	var forwarded []byte
+	handler := passthroughHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(readTestdata(t, "passthrough_stream.sse"))
+	})
+
+	request := readTestdata(t, "passthrough_request.json")
+	request = bytes.Replace(request, []byte(`"model": "gpt-4o",`), []byte(`"model": "gpt-4o", "stream": true,`), 1)
+	w := servePassthrough(handler, request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var sent map[string]any
+	json.Unmarshal(forwarded, &sent)
+	if sent["stream"] != true || sent["top_p"] != 0.9 {
+		t.Errorf("Expected stream and unknown fields upstream, got %s", forwarded)
+	}
+
+	want := sseData(t, readTestdata(t, "passthrough_stream.golden.sse"))
+	got := sseData(t, w.Body.Bytes())
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d events, got %d:\n%s", len(want), len(got), w.Body.String())
+	}
+	for i := range want {
+		if want[i] == "[DONE]" || got[i] == "[DONE]" {
+			if want[i] != got[i] {
+				t.Errorf("Event %d: expected %q, got %q", i, want[i], got[i])
+			}
+			continue
+		}
+		assertJSONEqual(t, "passthrough_stream.golden.sse", []byte(want[i]), []byte(got[i]))
+	}
+}
+
+func sseData(t *testing.T, body []byte) []string {
+	t.Helper()
+	var events []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	return events
+}
@@ -1,104 +1,185 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/saferoute/proxy/internal/anonymizer"
+	"github.com/saferoute/proxy/internal/auth"
+	"github.com/saferoute/proxy/internal/logging"
 	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/policy"
+	"github.com/saferoute/proxy/internal/quota"
+	"github.com/saferoute/proxy/internal/requestid"
 	"github.com/saferoute/proxy/internal/services"
+	"github.com/saferoute/proxy/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ProxyHandler struct {
 	nerClient   services.NERService
 	vaultClient services.VaultService
 	llmClient   services.LLMService
+	policy      *policy.Policy
+	// fallbackNER scrubs requests whose policy allows regex-only detection
+	// while nerClient is failing.
+	fallbackNER services.NERService
+
+	quota                  *quota.Manager
+	quotaDefaultCompletion int
+
+	embeddingsHashKey      []byte
+	embeddingsNERBatchSize int
 }
 
-func NewProxyHandler(ner services.NERService, vault services.VaultService, llm services.LLMService) *ProxyHandler {
-	return &ProxyHandler{
+// Option configures optional ProxyHandler behavior.
+type Option func(*ProxyHandler)
+
+// WithPolicy sets the entity policy. Without one every entity is tokenized.
+func WithPolicy(p *policy.Policy) Option {
+	return func(h *ProxyHandler) {
+		h.policy = p
+	}
+}
+
+func NewProxyHandler(ner services.NERService, vault services.VaultService, llm services.LLMService, opts ...Option) *ProxyHandler {
+	h := &ProxyHandler{
 		nerClient:   ner,
 		vaultClient: vault,
 		llmClient:   llm,
+		policy:      policy.Default(),
+		fallbackNER: services.NewRegexNER(),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 func (h *ProxyHandler) HandleChatCompletion(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
-	requestID := r.Context().Value("request_id").(string)
-
-	log.Printf("[%s] Received chat completion request", requestID)
-
 	var req models.ChatCompletionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ensureRequestID(w, r)
 		respondError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	h.complete(w, r, req, chatFormat{})
+}
 
-	originalText := extractTextFromMessages(req.Messages)
+// complete runs a chat completion through the pipeline: detect entities,
+// apply the policy, store them in the vault, call the LLM with the tokenized
+// request and restore its answer. f writes the results in the wire format of
+// the endpoint that was called.
+func (h *ProxyHandler) complete(w http.ResponseWriter, r *http.Request, req models.ChatCompletionRequest, f apiFormat) {
+	startTime := time.Now()
+	ensureRequestID(w, r)
+	logger := logging.FromContext(r.Context())
+
+	logging.Add(r.Context(), "model", req.Model, "stream", req.Stream)
+	logger.Debug("received chat completion request", "messages", len(req.Messages), "tools", len(req.Tools))
+
+	messages, err := h.policy.FilterParts(req.Messages)
+	if err != nil {
+		logger.Warn("request rejected by policy", "error", err)
+		respondPolicyError(w, f, err)
+		return
+	}
+	req.Messages = messages
+
+	reservation, ok := h.reserveQuota(w, r, quota.Estimate(req, h.quotaDefaultCompletion), f)
+	if !ok {
+		return
+	}
+	// usedTokens stays zero, releasing the reservation, unless the request
+	// reaches the LLM.
+	var usedTokens int64
+	defer func() { h.settleQuota(r.Context(), reservation, usedTokens) }()
 
-	log.Printf("[%s] Calling NER service...", requestID)
 	nerStart := time.Now()
-	entities, err := h.nerClient.DetectEntities(r.Context(), originalText)
+	texts := requestTexts(req)
+	detected, err := h.detectEntities(r.Context(), texts)
 	if err != nil {
-		log.Printf("[%s] NER failed: %v", requestID, err)
-		respondError(w, "NER service unavailable", http.StatusServiceUnavailable)
+		detected, err = h.degrade(w, r, texts, err)
+	}
+	if err != nil {
+		logger.Error("NER failed", "error", err)
+		f.respondError(w, "NER service unavailable", http.StatusServiceUnavailable)
 		return
 	}
 	nerLatency := time.Since(nerStart)
-	log.Printf("[%s] NER detected %d entities in %v", requestID, len(entities), nerLatency)
+	logging.Add(r.Context(), "ner_ms", milliseconds(nerLatency))
 
-	log.Printf("[%s] Storing entities in vault...", requestID)
-	vaultStart := time.Now()
-	if err := h.vaultClient.StoreEntities(r.Context(), requestID, entities); err != nil {
-		log.Printf("[%s] Vault store failed: %v", requestID, err)
-		respondError(w, "Vault service unavailable", http.StatusServiceUnavailable)
+	decision, err := h.applyPolicy(r.Context(), flattenEntities(detected))
+	if err != nil {
+		logger.Warn("request blocked by policy", "error", err)
+		respondPolicyError(w, f, err)
 		return
 	}
-	vaultStoreLatency := time.Since(vaultStart)
-	log.Printf("[%s] Entities stored in %v", requestID, vaultStoreLatency)
+	logging.Add(r.Context(), "entities", len(decision.Replace))
+	logger.Debug("entities detected", "types", entityTypeCounts(decision.Replace))
 
-	tokenizedReq := h.tokenizeRequest(req, entities)
-	log.Printf("[%s] Request tokenized, forwarding to LLM...", requestID)
+	vaultID := vaultKey(r.Context(), requestid.New())
+	vaultStart := time.Now()
+	if err := h.storeEntities(r.Context(), vaultID, decision.Vault); err != nil {
+		logger.Error("vault store failed", "error", err)
+		f.respondError(w, "Vault service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	logging.Add(r.Context(), "vault_store_ms", milliseconds(time.Since(vaultStart)))
+
+	tokenizedReq := h.tokenizeRequest(req, decision.Replace)
+
+	if req.Stream {
+		usage, forwarded := h.streamChatCompletion(w, r, vaultID, tokenizedReq, f)
+		switch {
+		case usage != nil:
+			usedTokens = quota.Used(*usage)
+		case forwarded && reservation != nil:
+			// The provider reported no usage; keep the estimate.
+			usedTokens = reservation.Tokens
+		}
+		return
+	}
 
 	llmStart := time.Now()
-	llmResp, err := h.llmClient.ChatCompletion(r.Context(), tokenizedReq)
+	llmResp, err := h.chatCompletion(r.Context(), tokenizedReq)
 	if err != nil {
-		log.Printf("[%s] LLM failed: %v", requestID, err)
-		respondError(w, "LLM service unavailable", http.StatusServiceUnavailable)
+		logger.Error("LLM failed", "error", err)
+		respondLLMError(w, f, err)
 		return
 	}
-	llmLatency := time.Since(llmStart)
-	log.Printf("[%s] LLM response received in %v", requestID, llmLatency)
+	usedTokens = quota.Used(llmResp.Usage)
+	logging.Add(r.Context(), "llm_ms", milliseconds(time.Since(llmStart)), "llm_tokens", usedTokens)
 
-	log.Printf("[%s] Retrieving entities from vault...", requestID)
 	vaultGetStart := time.Now()
-	retrievedEntities, err := h.vaultClient.GetEntities(r.Context(), requestID)
+	retrievedEntities, err := h.retrieveEntities(r.Context(), vaultID)
 	if err != nil {
-		log.Printf("[%s] Vault retrieve failed: %v", requestID, err)
-		respondError(w, "Vault retrieve failed", http.StatusInternalServerError)
+		logger.Error("vault retrieve failed", "error", err)
+		f.respondError(w, "Vault retrieve failed", http.StatusInternalServerError)
 		return
 	}
-	vaultGetLatency := time.Since(vaultGetStart)
-	log.Printf("[%s] Entities retrieved in %v", requestID, vaultGetLatency)
+	logging.Add(r.Context(), "vault_get_ms", milliseconds(time.Since(vaultGetStart)))
 
 	restoredResp := h.restoreResponse(llmResp, retrievedEntities)
 
 	totalLatency := time.Since(startTime)
-	log.Printf("[%s] Request completed in %v (NER: %v, Vault: %v/%v, LLM: %v)",
-		requestID, totalLatency, nerLatency, vaultStoreLatency, vaultGetLatency, llmLatency)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Request-ID", requestID)
 	w.Header().Set("X-Latency-Ms", fmt.Sprintf("%.2f", totalLatency.Seconds()*1000))
-	json.NewEncoder(w).Encode(restoredResp)
+	f.respondCompletion(w, restoredResp)
 }
 
+// HandleAnonymize returns the anonymized text with a request_id to restore
+// it by. That ID is minted here, not taken from X-Request-ID, and only
+// restores for the same tenant.
 func (h *ProxyHandler) HandleAnonymize(w http.ResponseWriter, r *http.Request) {
-	requestID := r.Context().Value("request_id").(string)
+	ensureRequestID(w, r)
 
 	var req struct {
 		Text string `json:"text"`
@@ -109,27 +190,33 @@ func (h *ProxyHandler) HandleAnonymize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	entities, err := h.nerClient.DetectEntities(r.Context(), req.Text)
+	detected, err := h.detectEntities(r.Context(), []string{req.Text})
 	if err != nil {
+		logging.FromContext(r.Context()).Error("NER failed", "error", err)
 		respondError(w, "NER service unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
-	if err := h.vaultClient.StoreEntities(r.Context(), requestID, entities); err != nil {
-		respondError(w, "Vault service unavailable", http.StatusServiceUnavailable)
+	decision, err := h.applyPolicy(r.Context(), detected[0])
+	if err != nil {
+		respondPolicyError(w, chatFormat{}, err)
 		return
 	}
 
-	anonymizedText := req.Text
-	for _, entity := range entities {
-		anonymizedText = strings.ReplaceAll(anonymizedText, entity.Original, entity.Token)
+	handle := requestid.New()
+	if err := h.storeEntities(r.Context(), vaultKey(r.Context(), handle), decision.Vault); err != nil {
+		logging.FromContext(r.Context()).Error("vault store failed", "error", err)
+		respondError(w, "Vault service unavailable", http.StatusServiceUnavailable)
+		return
 	}
 
+	anonymizedText := anonymizer.Anonymize(req.Text, decision.Replace)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"request_id":      requestID,
+		"request_id":      handle,
 		"anonymized_text": anonymizedText,
-		"entities_count":  len(entities),
+		"entities_count":  len(decision.Replace),
 	})
 }
 
@@ -143,17 +230,24 @@ func (h *ProxyHandler) HandleRestore(w http.ResponseWriter, r *http.Request) {
 		respondError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !requestid.Valid(req.RequestID) {
+		respondError(w, "Invalid request_id", http.StatusBadRequest)
+		return
+	}
 
-	entities, err := h.vaultClient.GetEntities(r.Context(), req.RequestID)
+	entities, err := h.retrieveEntities(r.Context(), vaultKey(r.Context(), req.RequestID))
+	var statusErr *services.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		respondError(w, "Unknown request_id", http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		logging.FromContext(r.Context()).Error("vault retrieve failed", "error", err)
 		respondError(w, "Vault retrieve failed", http.StatusInternalServerError)
 		return
 	}
 
-	restoredText := req.Text
-	for _, entity := range entities {
-		restoredText = strings.ReplaceAll(restoredText, entity.Token, entity.Original)
-	}
+	restoredText := anonymizer.Restore(req.Text, entities)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -161,36 +255,364 @@ func (h *ProxyHandler) HandleRestore(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// detectEntities, applyPolicy, storeEntities, retrieveEntities and
+// chatCompletion each run one stage inside a span and record its metrics.
+func (h *ProxyHandler) detectEntities(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	ctx, span := tracing.Start(ctx, "ner.detect", attribute.Int("texts", len(texts)))
+	start := time.Now()
+	detected, err := services.DetectEntitiesBatch(ctx, h.nerClient, texts)
+	nerDuration.Observe(seconds(start))
+	recordUpstreamError(upstreamNER, err)
+	for _, entities := range detected {
+		recordEntities(entities)
+	}
+	tracing.End(span, err)
+	return detected, err
+}
+
+// degrade applies the policy's degraded mode after detection of texts
+// failed with nerErr. It returns nerErr when the request must be blocked;
+// otherwise it flags the response and returns the entities to scrub.
+func (h *ProxyHandler) degrade(w http.ResponseWriter, r *http.Request, texts []string, nerErr error) ([][]models.Entity, error) {
+	var tenantID string
+	if tenant, ok := auth.TenantFromContext(r.Context()); ok {
+		tenantID = tenant.ID
+	}
+	route := r.URL.Path
+	mode := h.policy.DegradedMode(route, tenantID)
+
+	var detected [][]models.Entity
+	switch mode {
+	case policy.DegradeRegexOnly:
+		var err error
+		detected, err = services.DetectEntitiesBatch(r.Context(), h.fallbackNER, texts)
+		if err != nil {
+			return nil, fmt.Errorf("%w; regex fallback: %v", nerErr, err)
+		}
+		for _, entities := range detected {
+			recordEntities(entities)
+		}
+	case policy.DegradePassThrough:
+		detected = make([][]models.Entity, len(texts))
+	default:
+		return nil, nerErr
+	}
+
+	nerDegradedTotal.WithLabelValues(string(mode), route).Inc()
+	w.Header().Set("X-SafeRoute-Degraded", string(mode))
+	logging.Add(r.Context(), "ner_degraded", string(mode))
+	logging.FromContext(r.Context()).Warn("NER failed, continuing degraded", "mode", mode, "error", nerErr)
+	return detected, nil
+}
+
+func (h *ProxyHandler) applyPolicy(ctx context.Context, entities []models.Entity) (policy.Decision, error) {
+	_, span := tracing.Start(ctx, "policy.apply", attribute.Int("entities", len(entities)))
+	decision, err := h.policy.Apply(entities)
+	tracing.End(span, err)
+	return decision, err
+}
+
+func (h *ProxyHandler) storeEntities(ctx context.Context, vaultID string, entities []models.Entity) error {
+	ctx, span := tracing.Start(ctx, "vault.store", attribute.Int("entities", len(entities)))
+	start := time.Now()
+	err := h.vaultClient.StoreEntities(ctx, vaultID, entities)
+	vaultDuration.WithLabelValues("store").Observe(seconds(start))
+	recordUpstreamError(upstreamVault, err)
+	tracing.End(span, err)
+	return err
+}
+
+func (h *ProxyHandler) retrieveEntities(ctx context.Context, vaultID string) ([]models.Entity, error) {
+	ctx, span := tracing.Start(ctx, "vault.retrieve")
+	start := time.Now()
+	entities, err := h.vaultClient.GetEntities(ctx, vaultID)
+	vaultDuration.WithLabelValues("retrieve").Observe(seconds(start))
+	recordUpstreamError(upstreamVault, err)
+	tracing.End(span, err)
+	return entities, err
+}
+
+func (h *ProxyHandler) chatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	ctx, span := tracing.Start(ctx, "llm.chat_completion", attribute.String("model", req.Model))
+	start := time.Now()
+	resp, err := h.llmClient.ChatCompletion(ctx, req)
+	llmDuration.WithLabelValues(h.modelLabel(req.Model), "false").Observe(seconds(start))
+	recordUpstreamError(upstreamLLM, err)
+	if err == nil {
+		recordTokens(h.modelLabel(req.Model), resp.Usage)
+		span.SetAttributes(attribute.Int("tokens", resp.Usage.TotalTokens))
+	}
+	tracing.End(span, err)
+	return resp, err
+}
+
 func (h *ProxyHandler) tokenizeRequest(req models.ChatCompletionRequest, entities []models.Entity) models.ChatCompletionRequest {
 	tokenized := req
-	for i := range tokenized.Messages {
-		content := tokenized.Messages[i].Content
-		for _, entity := range entities {
-			content = strings.ReplaceAll(content, entity.Original, entity.Token)
+	tokenized.Messages = make([]models.Message, len(req.Messages))
+	// Positions are only meaningful within message content, so tool call
+	// arguments are scrubbed by search alone.
+	unscoped := anonymizer.MessageEntities(entities, -1)
+	for i, msg := range req.Messages {
+		scoped := anonymizer.MessageEntities(entities, i)
+		if msg.Parts != nil {
+			msg.Parts = anonymizeParts(msg.Parts, scoped)
+		} else {
+			msg.Content = anonymizer.Anonymize(msg.Content, scoped)
 		}
-		tokenized.Messages[i].Content = content
+		msg.ToolCalls = mapToolCalls(msg.ToolCalls, func(args string) string {
+			return anonymizer.AnonymizeJSON(args, unscoped)
+		})
+		msg.Extra = mapExtra(msg.Extra, func(value string) string {
+			return anonymizer.AnonymizeJSON(value, unscoped)
+		})
+		tokenized.Messages[i] = msg
 	}
+	tokenized.Extra = mapExtra(req.Extra, func(value string) string {
+		return anonymizer.AnonymizeJSON(value, unscoped)
+	})
 	return tokenized
 }
 
 func (h *ProxyHandler) restoreResponse(resp models.ChatCompletionResponse, entities []models.Entity) models.ChatCompletionResponse {
 	restored := resp
-	for i := range restored.Choices {
-		content := restored.Choices[i].Message.Content
-		for _, entity := range entities {
-			content = strings.ReplaceAll(content, entity.Token, entity.Original)
-		}
-		restored.Choices[i].Message.Content = content
+	restored.Choices = make([]models.Choice, len(resp.Choices))
+	for i, choice := range resp.Choices {
+		choice.Message.Content = anonymizer.Restore(choice.Message.Content, entities)
+		choice.Message.ToolCalls = mapToolCalls(choice.Message.ToolCalls, func(args string) string {
+			return anonymizer.RestoreJSON(args, entities)
+		})
+		choice.Message.Extra = mapExtra(choice.Message.Extra, func(value string) string {
+			return anonymizer.RestoreJSON(value, entities)
+		})
+		restored.Choices[i] = choice
 	}
 	return restored
 }
 
-func extractTextFromMessages(messages []models.Message) string {
-	var text string
-	for _, msg := range messages {
-		text += msg.Content + "\n"
+// anonymizeParts scrubs the text parts of a message, which were scanned as
+// one text by messageTexts. Other parts are returned as they are.
+func anonymizeParts(parts []models.ContentPart, entities []models.Entity) []models.ContentPart {
+	var texts []string
+	for _, part := range parts {
+		if text, ok := part.ScannedText(); ok {
+			texts = append(texts, text)
+		}
+	}
+	texts = anonymizer.AnonymizeSegments(texts, entities)
+
+	scrubbed := make([]models.ContentPart, len(parts))
+	for i, part := range parts {
+		if models.IsTextPart(part.Type) {
+			part, texts = part.WithText(texts[0]), texts[1:]
+		}
+		scrubbed[i] = part
+	}
+	return scrubbed
+}
+
+// mapToolCalls returns a copy of calls with fn applied to each one's
+// arguments.
+func mapToolCalls(calls []models.ToolCall, fn func(args string) string) []models.ToolCall {
+	if calls == nil {
+		return nil
+	}
+	mapped := make([]models.ToolCall, len(calls))
+	for i, call := range calls {
+		call.Function.Arguments = fn(call.Function.Arguments)
+		mapped[i] = call
+	}
+	return mapped
+}
+
+// unscannedFields are unmodeled fields whose strings are settings rather
+// than text, so they are neither scanned nor rewritten.
+var unscannedFields = map[string]bool{
+	"response_format":  true,
+	"stream_options":   true,
+	"logit_bias":       true,
+	"reasoning_effort": true,
+	"service_tier":     true,
+	"modalities":       true,
+}
+
+// mapExtra returns a copy of extra with fn applied to the raw JSON value of
+// each field not in unscannedFields.
+func mapExtra(extra models.Extra, fn func(value string) string) models.Extra {
+	if extra == nil {
+		return nil
+	}
+	mapped := make(models.Extra, len(extra))
+	for name, value := range extra {
+		if !unscannedFields[name] {
+			value = json.RawMessage(fn(string(value)))
+		}
+		mapped[name] = value
+	}
+	return mapped
+}
+
+// extraStrings returns the string values in the fields of extra that
+// mapExtra rewrites, in field name order and joined by newlines.
+func extraStrings(extra models.Extra) string {
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		if !unscannedFields[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var values []string
+	for _, name := range names {
+		if value := anonymizer.JSONStrings(string(extra[name])); value != "" {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, "\n")
+}
+
+// ensureRequestID returns the ID set by middleware.RequestID, or mints and
+// echoes one when the handler is served without that middleware.
+func ensureRequestID(w http.ResponseWriter, r *http.Request) string {
+	if id, ok := requestid.FromContext(r.Context()); ok {
+		return id
+	}
+	id := requestid.New()
+	w.Header().Set(requestid.Header, id)
+	return id
+}
+
+// vaultKey scopes a server-minted handle to the request's tenant, so that
+// one tenant can neither overwrite nor restore another's entities. The
+// client's X-Request-ID is never used: any caller can choose it, and two
+// requests sharing it would overwrite each other's entities.
+func vaultKey(ctx context.Context, handle string) string {
+	tenant, ok := auth.TenantFromContext(ctx)
+	if !ok {
+		return handle
+	}
+	sum := sha256.Sum256([]byte(tenant.ID))
+	return hex.EncodeToString(sum[:8]) + "." + handle
+}
+
+// messageTexts returns the text to scan in each message: its content (the
+// text parts joined by newlines), followed by the string values in the
+// arguments of any tool calls it carries and in its unmodeled fields, such
+// as refusal or reasoning_content. Entity positions stay valid for the
+// content, which comes first.
+func messageTexts(messages []models.Message) []string {
+	texts := make([]string, len(messages))
+	for i, msg := range messages {
+		texts[i] = msg.Text()
+		for _, call := range msg.ToolCalls {
+			texts[i] += "\n" + anonymizer.JSONStrings(call.Function.Arguments)
+		}
+		if values := extraStrings(msg.Extra); values != "" {
+			texts[i] += "\n" + values
+		}
+	}
+	return texts
+}
+
+// requestTexts returns messageTexts followed, when there are any, by the
+// string values in the request's own unmodeled fields, such as user or
+// metadata. Entities found there are tokenized by search wherever they
+// appear.
+func requestTexts(req models.ChatCompletionRequest) []string {
+	texts := messageTexts(req.Messages)
+	if values := extraStrings(req.Extra); values != "" {
+		texts = append(texts, values)
+	}
+	return texts
+}
+
+// entityTypeCounts counts entities by type, for logs that must not carry
+// the detected values.
+func entityTypeCounts(entities []models.Entity) map[string]int {
+	counts := make(map[string]int)
+	for _, entity := range entities {
+		counts[entity.Type]++
+	}
+	return counts
+}
+
+// flattenEntities tags each entity with the message it was found in.
+func flattenEntities(perMessage [][]models.Entity) []models.Entity {
+	var entities []models.Entity
+	for i, detected := range perMessage {
+		for _, entity := range detected {
+			entity.MessageIndex = i
+			entities = append(entities, entity)
+		}
+	}
+	return entities
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// apiFormat writes the pipeline's responses and errors in the wire format
+// of one API: OpenAI chat completions or Anthropic messages.
+type apiFormat interface {
+	respondError(w http.ResponseWriter, message string, status int)
+	respondQuotaExceeded(w http.ResponseWriter, exceeded *quota.ExceededError)
+	respondCompletion(w http.ResponseWriter, resp models.ChatCompletionResponse)
+	newStreamEncoder(w http.ResponseWriter, rc *http.ResponseController) streamEncoder
+}
+
+// chatFormat is the OpenAI chat completions format.
+type chatFormat struct{}
+
+func (chatFormat) respondError(w http.ResponseWriter, message string, status int) {
+	respondError(w, message, status)
+}
+
+func (chatFormat) respondQuotaExceeded(w http.ResponseWriter, exceeded *quota.ExceededError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": "Token quota exceeded",
+		"quota": exceeded,
+	})
+}
+
+func (chatFormat) respondCompletion(w http.ResponseWriter, resp models.ChatCompletionResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (chatFormat) newStreamEncoder(w http.ResponseWriter, rc *http.ResponseController) streamEncoder {
+	return &chatStreamEncoder{w: w, rc: rc}
+}
+
+func respondLLMError(w http.ResponseWriter, f apiFormat, err error) {
+	switch {
+	case errors.Is(err, services.ErrModelNotRouted):
+		f.respondError(w, "Unsupported model", http.StatusBadRequest)
+	case errors.Is(err, services.ErrStreamingUnsupported):
+		f.respondError(w, "Streaming not supported by LLM provider", http.StatusNotImplemented)
+	case errors.Is(err, services.ErrEmbeddingsUnsupported):
+		f.respondError(w, "Embeddings not supported by LLM provider", http.StatusNotImplemented)
+	case errors.Is(err, services.ErrInvalidRequest):
+		f.respondError(w, "Invalid request for LLM provider", http.StatusBadRequest)
+	default:
+		f.respondError(w, "LLM service unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+func respondPolicyError(w http.ResponseWriter, f apiFormat, err error) {
+	var blocked *policy.BlockedError
+	if errors.As(err, &blocked) {
+		f.respondError(w, "Request contains blocked entity types: "+strings.Join(blocked.Types, ", "), http.StatusUnprocessableEntity)
+		return
+	}
+	var rejected *policy.PartsRejectedError
+	if errors.As(err, &rejected) {
+		f.respondError(w, "Request contains rejected content parts: "+strings.Join(rejected.Types, ", "), http.StatusUnprocessableEntity)
+		return
 	}
-	return text
+	f.respondError(w, "Policy evaluation failed", http.StatusInternalServerError)
 }
 
 func respondError(w http.ResponseWriter, message string, status int) {
@@ -5,11 +5,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/saferoute/proxy/internal/auth"
 	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/policy"
+	"github.com/saferoute/proxy/internal/requestid"
 	"github.com/saferoute/proxy/internal/services"
 )
 
@@ -97,7 +103,7 @@ func TestHandleAnonymize(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 
 	// Add request_id to context
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -133,7 +139,7 @@ func TestHandleRestore(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 
 	// Add request_id to context
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -162,7 +168,7 @@ func TestHandleChatCompletion(t *testing.T) {
 
 	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -188,7 +194,7 @@ func TestHandleChatCompletion_InvalidJSON(t *testing.T) {
 	handler := NewProxyHandler(nerClient, vaultClient, llmClient)
 
 	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer([]byte("invalid json")))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -215,7 +221,7 @@ func TestHandleChatCompletion_NERFailure(t *testing.T) {
 	body, _ := json.Marshal(reqBody)
 
 	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -242,7 +248,7 @@ func TestHandleChatCompletion_VaultStoreFailure(t *testing.T) {
 	body, _ := json.Marshal(reqBody)
 
 	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -269,7 +275,7 @@ func TestHandleChatCompletion_LLMFailure(t *testing.T) {
 	body, _ := json.Marshal(reqBody)
 
 	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -296,7 +302,7 @@ func TestHandleChatCompletion_VaultRetrieveFailure(t *testing.T) {
 	body, _ := json.Marshal(reqBody)
 
 	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -315,7 +321,7 @@ func TestHandleAnonymize_InvalidJSON(t *testing.T) {
 	handler := NewProxyHandler(nerClient, vaultClient, llmClient)
 
 	req := httptest.NewRequest("POST", "/v1/anonymize", bytes.NewBuffer([]byte("invalid json")))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -337,7 +343,7 @@ func TestHandleAnonymize_NERFailure(t *testing.T) {
 	body, _ := json.Marshal(reqBody)
 
 	req := httptest.NewRequest("POST", "/v1/anonymize", bytes.NewBuffer(body))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -359,7 +365,7 @@ func TestHandleAnonymize_VaultFailure(t *testing.T) {
 	body, _ := json.Marshal(reqBody)
 
 	req := httptest.NewRequest("POST", "/v1/anonymize", bytes.NewBuffer(body))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -378,7 +384,7 @@ func TestHandleRestore_InvalidJSON(t *testing.T) {
 	handler := NewProxyHandler(nerClient, vaultClient, llmClient)
 
 	req := httptest.NewRequest("POST", "/v1/restore", bytes.NewBuffer([]byte("invalid json")))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -403,7 +409,7 @@ func TestHandleRestore_VaultFailure(t *testing.T) {
 	body, _ := json.Marshal(reqBody)
 
 	req := httptest.NewRequest("POST", "/v1/restore", bytes.NewBuffer(body))
-	ctx := context.WithValue(req.Context(), "request_id", "test-request-123")
+	ctx := requestid.With(req.Context(), "test-request-123")
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
@@ -413,3 +419,522 @@ func TestHandleRestore_VaultFailure(t *testing.T) {
 		t.Errorf("Expected status 500, got %d", w.Code)
 	}
 }
+
+type mockUnroutedLLMClient struct{}
+
+func (m *mockUnroutedLLMClient) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	return models.ChatCompletionResponse{}, fmt.Errorf("%w: %q", services.ErrModelNotRouted, req.Model)
+}
+
+func TestHandleChatCompletion_UnsupportedModel(t *testing.T) {
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockUnroutedLLMClient{})
+
+	reqBody := models.ChatCompletionRequest{
+		Model: "unknown-model",
+		Messages: []models.Message{
+			{Role: "user", Content: "Test message"},
+		},
+	}
+	body, _ := json.Marshal(reqBody)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	ctx := requestid.With(req.Context(), "test-request-123")
+	req = req.WithContext(ctx)
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+type mockBatchNERClient struct {
+	mockNERClient
+	batchCalls int
+	texts      []string
+}
+
+func (m *mockBatchNERClient) DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	m.batchCalls++
+	m.texts = texts
+	return [][]models.Entity{
+		{{Original: "Ann Lee", Token: "[PERSON_001]", Type: "PERSON", Position: 11, Confidence: 0.9}},
+		{},
+		{
+			{Original: "ann@example.com", Token: "[EMAIL_001]", Type: "EMAIL", Position: 14, Confidence: 0.98},
+			{Original: "Ann Lee", Token: "[PERSON_002]", Type: "PERSON", Position: 31, Confidence: 0.9},
+		},
+	}, nil
+}
+
+type recordingVaultClient struct {
+	mockVaultClient
+	stored []models.Entity
+}
+
+func (m *recordingVaultClient) StoreEntities(ctx context.Context, requestID string, entities []models.Entity) error {
+	m.stored = entities
+	return nil
+}
+
+type capturingLLMClient struct {
+	mockLLMClient
+	lastReq models.ChatCompletionRequest
+}
+
+func (m *capturingLLMClient) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	m.lastReq = req
+	return m.mockLLMClient.ChatCompletion(ctx, req)
+}
+
+func TestHandleChatCompletion_PerMessageEntities(t *testing.T) {
+	nerClient := &mockBatchNERClient{}
+	vaultClient := &recordingVaultClient{}
+	llmClient := &capturingLLMClient{}
+
+	handler := NewProxyHandler(nerClient, vaultClient, llmClient)
+
+	messages := []models.Message{
+		{Role: "system", Content: "You assist Ann Lee."},
+		{Role: "assistant", Content: "How can I help?"},
+		{Role: "user", Content: "My email is ann@example.com, I'm Ann Lee"},
+	}
+	body, _ := json.Marshal(models.ChatCompletionRequest{Model: "claude-3", Messages: messages})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	ctx := requestid.With(req.Context(), "test-request-123")
+	req = req.WithContext(ctx)
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	if nerClient.batchCalls != 1 || len(nerClient.texts) != len(messages) {
+		t.Errorf("Expected one batched NER call with %d texts, got %d calls with %d texts", len(messages), nerClient.batchCalls, len(nerClient.texts))
+	}
+
+	want := []string{
+		"You assist [PERSON_001].",
+		"How can I help?",
+		"My email is [EMAIL_001], I'm [PERSON_001]",
+	}
+	for i, msg := range llmClient.lastReq.Messages {
+		if msg.Content != want[i] {
+			t.Errorf("Message %d: expected %q, got %q", i, want[i], msg.Content)
+		}
+	}
+
+	wantIndexes := []int{0, 2, 2}
+	if len(vaultClient.stored) != len(wantIndexes) {
+		t.Fatalf("Expected %d stored entities, got %d", len(wantIndexes), len(vaultClient.stored))
+	}
+	for i, entity := range vaultClient.stored {
+		if entity.MessageIndex != wantIndexes[i] {
+			t.Errorf("Entity %d: expected message index %d, got %d", i, wantIndexes[i], entity.MessageIndex)
+		}
+	}
+}
+
+func TestHandleChatCompletion_Policy(t *testing.T) {
+	vaultClient := &recordingVaultClient{}
+	llmClient := &capturingLLMClient{}
+	p := &policy.Policy{
+		Default:  policy.Rule{Action: policy.ActionTokenize},
+		Entities: map[string]policy.Rule{"SSN": {Action: policy.ActionMask}},
+	}
+
+	handler := NewProxyHandler(&mockNERClient{}, vaultClient, llmClient, WithPolicy(p))
+
+	body, _ := json.Marshal(models.ChatCompletionRequest{
+		Model:    "claude-3",
+		Messages: []models.Message{{Role: "user", Content: "My email is john@example.com and SSN is 123-45-6789"}},
+	})
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	req = req.WithContext(requestid.With(req.Context(), "test-request-123"))
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if got := llmClient.lastReq.Messages[0].Content; got != "My email is [EMAIL_001] and SSN is ***-**-6789" {
+		t.Errorf("Unexpected forwarded content %q", got)
+	}
+	if len(vaultClient.stored) != 1 || vaultClient.stored[0].Type != "EMAIL" {
+		t.Errorf("Expected only the tokenized email in the vault, got %+v", vaultClient.stored)
+	}
+}
+
+func TestHandleChatCompletion_PolicyBlocks(t *testing.T) {
+	llmClient := &capturingLLMClient{}
+	p := &policy.Policy{
+		Default:  policy.Rule{Action: policy.ActionTokenize},
+		Entities: map[string]policy.Rule{"SSN": {Action: policy.ActionBlock}},
+	}
+
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient, WithPolicy(p))
+
+	body, _ := json.Marshal(models.ChatCompletionRequest{
+		Model:    "claude-3",
+		Messages: []models.Message{{Role: "user", Content: "My email is john@example.com and SSN is 123-45-6789"}},
+	})
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	req = req.WithContext(requestid.With(req.Context(), "test-request-123"))
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", w.Code)
+	}
+	if llmClient.lastReq.Model != "" {
+		t.Error("Expected the blocked request not to reach the LLM")
+	}
+}
+
+type toolCallingLLMClient struct {
+	capturingLLMClient
+}
+
+func (m *toolCallingLLMClient) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	m.lastReq = req
+	return models.ChatCompletionResponse{
+		ID:    "test-tools",
+		Model: req.Model,
+		Choices: []models.Choice{{
+			Message: models.Message{
+				Role: "assistant",
+				ToolCalls: []models.ToolCall{{
+					ID:       "call_2",
+					Type:     "function",
+					Function: models.FunctionCall{Name: "send_email", Arguments: `{"to":"[EMAIL_001]","ssn":"[SSN_001]"}`},
+				}},
+			},
+			FinishReason: "tool_calls",
+		}},
+	}, nil
+}
+
+func TestHandleChatCompletion_ToolCalls(t *testing.T) {
+	llmClient := &toolCallingLLMClient{}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	body, _ := json.Marshal(models.ChatCompletionRequest{
+		Model: "claude-3",
+		Messages: []models.Message{
+			{Role: "user", Content: "Send my SSN to my own address"},
+			{Role: "assistant", ToolCalls: []models.ToolCall{{ID: "call_1", Type: "function", Function: models.FunctionCall{Name: "lookup", Arguments: `{"email":"john@example.com"}`}}}},
+			{Role: "tool", ToolCallID: "call_1", Content: "Address john@example.com has SSN 123-45-6789"},
+		},
+		Tools: []models.Tool{{Type: "function", Function: models.FunctionDefinition{Name: "send_email", Parameters: json.RawMessage(`{"type":"object"}`)}}},
+	})
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	req = req.WithContext(requestid.With(req.Context(), "test-request-123"))
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	upstream := llmClient.lastReq
+	if len(upstream.Tools) != 1 || upstream.Messages[2].ToolCallID != "call_1" {
+		t.Errorf("Expected tools and tool_call_id to be forwarded, got %+v", upstream)
+	}
+	if got := upstream.Messages[1].ToolCalls[0].Function.Arguments; got != `{"email":"[EMAIL_001]"}` {
+		t.Errorf("Expected tool call arguments to be tokenized, got %q", got)
+	}
+	if got := upstream.Messages[2].Content; got != "Address [EMAIL_001] has SSN [SSN_001]" {
+		t.Errorf("Expected the tool result to be tokenized, got %q", got)
+	}
+
+	var resp models.ChatCompletionResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	calls := resp.Choices[0].Message.ToolCalls
+	if len(calls) != 1 || calls[0].ID != "call_2" {
+		t.Fatalf("Unexpected tool calls: %+v", calls)
+	}
+	if got := calls[0].Function.Arguments; got != `{"to":"john@example.com","ssn":"123-45-6789"}` {
+		t.Errorf("Expected tool call arguments to be restored, got %q", got)
+	}
+}
+
+func TestHandleChatCompletion_ContentParts(t *testing.T) {
+	body := `{"model": "claude-3", "messages": [{"role": "user", "content": [
+		{"type": "text", "text": "My email is john@example.com"},
+		{"type": "image_url", "image_url": {"url": "https://example.com/scan.png", "detail": "high"}},
+		{"type": "text", "text": "and SSN is 123-45-6789"}
+	]}]}`
+
+	tests := []struct {
+		name       string
+		action     policy.PartAction
+		wantStatus int
+		wantParts  int
+	}{
+		{"forward", policy.PartForward, http.StatusOK, 3},
+		{"strip", policy.PartStrip, http.StatusOK, 2},
+		{"reject", policy.PartReject, http.StatusUnprocessableEntity, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llmClient := &capturingLLMClient{}
+			p := &policy.Policy{
+				Default:      policy.Rule{Action: policy.ActionTokenize},
+				ContentParts: policy.ContentParts{Types: map[string]policy.PartAction{models.PartImageURL: tt.action}},
+			}
+			handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient, WithPolicy(p))
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req = req.WithContext(requestid.With(req.Context(), "test-request-123"))
+			w := httptest.NewRecorder()
+			handler.HandleChatCompletion(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if llmClient.lastReq.Model != "" {
+					t.Error("Expected the rejected request not to reach the LLM")
+				}
+				return
+			}
+
+			parts := llmClient.lastReq.Messages[0].Parts
+			if len(parts) != tt.wantParts {
+				t.Fatalf("Expected %d parts upstream, got %+v", tt.wantParts, parts)
+			}
+			if parts[0].Text != "My email is [EMAIL_001]" || parts[len(parts)-1].Text != "and SSN is [SSN_001]" {
+				t.Errorf("Expected text parts to be tokenized, got %+v", parts)
+			}
+			if tt.action == policy.PartForward && (parts[1].ImageURL == nil || parts[1].ImageURL.URL != "https://example.com/scan.png") {
+				t.Errorf("Expected the image part to be forwarded, got %+v", parts[1])
+			}
+			if wire, _ := json.Marshal(llmClient.lastReq.Messages[0]); !strings.Contains(string(wire), `"content":[{"type":"text"`) {
+				t.Errorf("Expected content to be encoded as parts, got %s", wire)
+			}
+		})
+	}
+}
+
+func TestHandleChatCompletion_TextBearingParts(t *testing.T) {
+	body := `{"model": "claude-3", "messages": [{"role": "assistant", "content": [
+		{"type": "output_text", "text": "My email is john@example.com"},
+		{"type": "refusal", "refusal": "and SSN is 123-45-6789"}
+	]}]}`
+	llmClient := &capturingLLMClient{}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	parts := llmClient.lastReq.Messages[0].Parts
+	if len(parts) != 2 || parts[0].Text != "My email is [EMAIL_001]" || parts[1].Refusal != "and SSN is [SSN_001]" {
+		t.Errorf("Expected output_text and refusal parts to be tokenized, got %+v", parts)
+	}
+}
+
+func TestHandleChatCompletion_NERDegraded(t *testing.T) {
+	p := &policy.Policy{
+		Default: policy.Rule{Action: policy.ActionTokenize},
+		Degraded: policy.Degradation{
+			Default: policy.DegradeRegexOnly,
+			Tenants: map[string]policy.DegradedMode{"open": policy.DegradePassThrough, "strict": policy.DegradeBlock},
+		},
+	}
+	content := "My email is john@example.com"
+
+	tests := []struct {
+		tenant     string
+		mode       policy.DegradedMode
+		wantStatus int
+		wantSent   string
+	}{
+		{"acme", policy.DegradeRegexOnly, http.StatusOK, "My email is [EMAIL_001]"},
+		{"open", policy.DegradePassThrough, http.StatusOK, content},
+		{"strict", policy.DegradeBlock, http.StatusServiceUnavailable, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			llmClient := &capturingLLMClient{}
+			handler := NewProxyHandler(&mockNERClient{shouldFail: true}, &mockVaultClient{}, llmClient, WithPolicy(p))
+			counter := nerDegradedTotal.WithLabelValues(string(tt.mode), "/v1/chat/completions")
+			before := testutil.ToFloat64(counter)
+
+			body, _ := json.Marshal(models.ChatCompletionRequest{
+				Model:    "claude-3",
+				Messages: []models.Message{{Role: "user", Content: content}},
+			})
+			req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+			ctx := requestid.With(req.Context(), "test-request-123")
+			req = req.WithContext(auth.WithTenant(ctx, auth.Tenant{ID: tt.tenant}))
+
+			w := httptest.NewRecorder()
+			handler.HandleChatCompletion(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.mode == policy.DegradeBlock {
+				if got := w.Header().Get("X-SafeRoute-Degraded"); got != "" {
+					t.Errorf("Expected no degraded header on a blocked request, got %q", got)
+				}
+				if got := testutil.ToFloat64(counter) - before; got != 0 {
+					t.Errorf("Expected blocked requests not to be counted, got %v", got)
+				}
+				return
+			}
+			if got := w.Header().Get("X-SafeRoute-Degraded"); got != string(tt.mode) {
+				t.Errorf("Expected degraded header %q, got %q", tt.mode, got)
+			}
+			if got := llmClient.lastReq.Messages[0].Content; got != tt.wantSent {
+				t.Errorf("Expected forwarded content %q, got %q", tt.wantSent, got)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("Expected 1 degraded request counted, got %v", got)
+			}
+		})
+	}
+}
+
+func TestHandleAnonymize_PolicyRedacts(t *testing.T) {
+	p := &policy.Policy{
+		Default:  policy.Rule{Action: policy.ActionRedact},
+		Entities: map[string]policy.Rule{"EMAIL": {Action: policy.ActionAllow}},
+	}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{}, WithPolicy(p))
+
+	body, _ := json.Marshal(map[string]string{"text": "My email is john@example.com and SSN is 123-45-6789"})
+	req := httptest.NewRequest("POST", "/v1/anonymize", bytes.NewBuffer(body))
+	req = req.WithContext(requestid.With(req.Context(), "test-request-123"))
+
+	w := httptest.NewRecorder()
+	handler.HandleAnonymize(w, req)
+
+	var resp map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["anonymized_text"] != "My email is john@example.com and SSN is [REDACTED_SSN]" {
+		t.Errorf("Unexpected anonymized text %v", resp["anonymized_text"])
+	}
+}
+
+func TestHandleAnonymize_WithoutRequestIDMiddleware(t *testing.T) {
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{})
+
+	body, _ := json.Marshal(map[string]string{"text": "My email is john@example.com"})
+	req := httptest.NewRequest("POST", "/v1/anonymize", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	handler.HandleAnonymize(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&response)
+	if w.Header().Get("X-Request-ID") == "" {
+		t.Error("Expected a minted request ID echoed in the header")
+	}
+	if response["request_id"] == "" || response["request_id"] == w.Header().Get("X-Request-ID") {
+		t.Errorf("Expected a restore ID separate from X-Request-ID, got %v", response["request_id"])
+	}
+}
+
+// memoryVaultClient keeps entities by vault ID, as the vault does.
+type memoryVaultClient struct {
+	entries map[string][]models.Entity
+}
+
+func (m *memoryVaultClient) StoreEntities(ctx context.Context, vaultID string, entities []models.Entity) error {
+	if m.entries == nil {
+		m.entries = make(map[string][]models.Entity)
+	}
+	m.entries[vaultID] = entities
+	return nil
+}
+
+func (m *memoryVaultClient) GetEntities(ctx context.Context, vaultID string) ([]models.Entity, error) {
+	entities, ok := m.entries[vaultID]
+	if !ok {
+		return nil, &services.StatusError{Service: "vault", StatusCode: http.StatusNotFound}
+	}
+	return entities, nil
+}
+
+func tenantRequest(path, tenant string, body any) *http.Request {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewBuffer(data))
+	ctx := requestid.With(req.Context(), "client-chosen-id")
+	return req.WithContext(auth.WithTenant(ctx, auth.Tenant{ID: tenant}))
+}
+
+func TestVaultKeys_IgnoreClientRequestID(t *testing.T) {
+	vaultClient := &memoryVaultClient{}
+	handler := NewProxyHandler(&mockNERClient{}, vaultClient, &mockLLMClient{})
+
+	for i := 0; i < 2; i++ {
+		req := tenantRequest("/v1/chat/completions", "acme", models.ChatCompletionRequest{
+			Model:    "claude-3",
+			Messages: []models.Message{{Role: "user", Content: "My email is john@example.com"}},
+		})
+		handler.HandleChatCompletion(httptest.NewRecorder(), req)
+	}
+
+	if len(vaultClient.entries) != 2 {
+		t.Fatalf("Expected two requests with one X-Request-ID to get two vault entries, got %d", len(vaultClient.entries))
+	}
+	for key := range vaultClient.entries {
+		if strings.Contains(key, "client-chosen-id") {
+			t.Errorf("Expected a server-minted vault key, got %q", key)
+		}
+	}
+}
+
+func TestHandleRestore_OtherTenant(t *testing.T) {
+	handler := NewProxyHandler(&mockNERClient{}, &memoryVaultClient{}, &mockLLMClient{})
+
+	w := httptest.NewRecorder()
+	handler.HandleAnonymize(w, tenantRequest("/v1/anonymize", "acme", map[string]string{"text": "My email is john@example.com"}))
+	var anonymized struct {
+		RequestID      string `json:"request_id"`
+		AnonymizedText string `json:"anonymized_text"`
+	}
+	json.NewDecoder(w.Body).Decode(&anonymized)
+
+	restore := map[string]string{"request_id": anonymized.RequestID, "text": anonymized.AnonymizedText}
+
+	w = httptest.NewRecorder()
+	handler.HandleRestore(w, tenantRequest("/v1/restore", "acme", restore))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "john@example.com") {
+		t.Errorf("Expected the owning tenant to restore, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.HandleRestore(w, tenantRequest("/v1/restore", "globex", restore))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected another tenant to get 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleRestore_InvalidRequestID(t *testing.T) {
+	handler := NewProxyHandler(&mockNERClient{}, &memoryVaultClient{}, &mockLLMClient{})
+
+	w := httptest.NewRecorder()
+	handler.HandleRestore(w, tenantRequest("/v1/restore", "acme", map[string]string{"request_id": "../acme", "text": "x"}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/saferoute/proxy/internal/auth"
+	"github.com/saferoute/proxy/internal/logging"
+	"github.com/saferoute/proxy/internal/quota"
+)
+
+// WithQuota enforces per-tenant token budgets. Requests that do not set
+// max_tokens are estimated to use defaultCompletion completion tokens.
+// Requests without a tenant (authentication disabled) are not counted.
+func WithQuota(m *quota.Manager, defaultCompletion int) Option {
+	return func(h *ProxyHandler) {
+		h.quota = m
+		h.quotaDefaultCompletion = defaultCompletion
+	}
+}
+
+// reserveQuota charges a request's estimated tokens to its tenant. It
+// returns false once it has responded because a budget is exhausted; a nil
+// reservation means nothing was charged.
+func (h *ProxyHandler) reserveQuota(w http.ResponseWriter, r *http.Request, estimated int64, f apiFormat) (*quota.Reservation, bool) {
+	if h.quota == nil {
+		return nil, true
+	}
+	tenant, ok := auth.TenantFromContext(r.Context())
+	if !ok {
+		return nil, true
+	}
+
+	res, err := h.quota.Reserve(r.Context(), tenant.ID, estimated)
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		logging.FromContext(r.Context()).Warn("token quota exceeded", "period", exceeded.Period, "limit", exceeded.Limit)
+		respondQuotaExceeded(w, f, exceeded)
+		return nil, false
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("quota check unavailable, allowing request", "error", err)
+		return nil, true
+	}
+	return &res, true
+}
+
+// settleQuota replaces a reservation with the tokens actually used. It runs
+// after the response, so it must not depend on the client still waiting.
+func (h *ProxyHandler) settleQuota(ctx context.Context, res *quota.Reservation, used int64) {
+	if res == nil {
+		return
+	}
+	if err := h.quota.Reconcile(context.WithoutCancel(ctx), *res, used); err != nil {
+		logging.FromContext(ctx).Error("quota reconcile failed", "error", err)
+	}
+}
+
+func respondQuotaExceeded(w http.ResponseWriter, f apiFormat, exceeded *quota.ExceededError) {
+	retryAfter := math.Ceil(time.Until(exceeded.ResetsAt).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(int(max(retryAfter, 1))))
+	f.respondQuotaExceeded(w, exceeded)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/saferoute/proxy/internal/auth"
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/quota"
+	"github.com/saferoute/proxy/internal/requestid"
+)
+
+func newQuotaRequest(t *testing.T, tenant string, maxTokens int) *http.Request {
+	body, _ := json.Marshal(models.ChatCompletionRequest{
+		Model:     "claude-3",
+		Messages:  []models.Message{{Role: "user", Content: "Hello"}},
+		MaxTokens: maxTokens,
+	})
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	ctx := requestid.With(req.Context(), "test-request-123")
+	return req.WithContext(auth.WithTenant(ctx, auth.Tenant{ID: tenant}))
+}
+
+func TestHandleChatCompletion_QuotaReconciled(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{},
+		WithQuota(quota.NewManager(client, quota.Limits{Daily: 1000}), 256))
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newQuotaRequest(t, "acme", 500))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	// The mock LLM reports 15 total tokens.
+	if len(mr.Keys()) != 2 {
+		t.Fatalf("Expected daily and monthly counters, got %v", mr.Keys())
+	}
+	for _, key := range mr.Keys() {
+		if got, _ := mr.Get(key); got != "15" {
+			t.Errorf("Expected %s reconciled to 15 tokens, got %s", key, got)
+		}
+	}
+}
+
+func TestHandleChatCompletion_QuotaExceeded(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	llmClient := &capturingLLMClient{}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient,
+		WithQuota(quota.NewManager(client, quota.Limits{Daily: 100}), 256))
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newQuotaRequest(t, "acme", 500))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+	if llmClient.lastReq.Model != "" {
+		t.Error("Expected the request not to reach the LLM")
+	}
+
+	var resp struct {
+		Error string `json:"error"`
+		Quota struct {
+			Period string `json:"period"`
+			Limit  int64  `json:"limit"`
+		} `json:"quota"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Error != "Token quota exceeded" || resp.Quota.Period != "daily" || resp.Quota.Limit != 100 {
+		t.Errorf("Unexpected error body %+v", resp)
+	}
+}
+
+func TestHandleChatCompletion_QuotaReleasedOnFailure(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{shouldFail: true},
+		WithQuota(quota.NewManager(client, quota.Limits{Daily: 1000}), 256))
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newQuotaRequest(t, "acme", 500))
+
+	if len(mr.Keys()) != 2 {
+		t.Fatalf("Expected daily and monthly counters, got %v", mr.Keys())
+	}
+	for _, key := range mr.Keys() {
+		if got, _ := mr.Get(key); got != "0" {
+			t.Errorf("Expected %s released to 0 tokens, got %s", key, got)
+		}
+	}
+}
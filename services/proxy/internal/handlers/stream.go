@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saferoute/proxy/internal/anonymizer"
+	"github.com/saferoute/proxy/internal/logging"
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/services"
+	"github.com/saferoute/proxy/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// streamWriteTimeout bounds each individual write to a streaming client so
+// that long generations are not cut off by the server-wide WriteTimeout.
+const streamWriteTimeout = 30 * time.Second
+
+// errStreamEncode marks a chunk an encoder could not render in its wire
+// format, as opposed to a failed write to the client.
+var errStreamEncode = errors.New("stream encoding failed")
+
+// streamEncoder writes restored chunks to a streaming client in the wire
+// format of the endpoint it called.
+type streamEncoder interface {
+	writeChunk(chunk models.ChatCompletionChunk) error
+	// writeError reports a failure after the stream has started.
+	writeError(message string)
+	// close ends a stream that completed normally.
+	close() error
+}
+
+// streamChatCompletion relays the LLM stream to the client, restoring
+// tokens as they arrive. It returns the usage the provider reported, if any,
+// and whether the request reached the provider at all.
+func (h *ProxyHandler) streamChatCompletion(w http.ResponseWriter, r *http.Request, vaultID string, req models.ChatCompletionRequest, f apiFormat) (usage *models.Usage, forwarded bool) {
+	streamer, ok := h.llmClient.(services.LLMStreamService)
+	if !ok {
+		f.respondError(w, "Streaming not supported by LLM provider", http.StatusNotImplemented)
+		return nil, false
+	}
+
+	logger := logging.FromContext(r.Context())
+
+	// The span covers the whole relay, not just the time to first byte.
+	ctx, span := tracing.Start(r.Context(), "llm.chat_completion_stream", attribute.String("model", req.Model))
+	llmStart := time.Now()
+	var streamErr error
+	defer func() {
+		llmDuration.WithLabelValues(h.modelLabel(req.Model), "true").Observe(seconds(llmStart))
+		recordUpstreamError(upstreamLLM, streamErr)
+		if usage != nil {
+			recordTokens(h.modelLabel(req.Model), *usage)
+			span.SetAttributes(attribute.Int("tokens", usage.TotalTokens))
+		}
+		tracing.End(span, streamErr)
+	}()
+
+	stream, err := streamer.ChatCompletionStream(ctx, req)
+	if err != nil {
+		streamErr = err
+		logger.Error("LLM stream failed", "error", err)
+		respondLLMError(w, f, err)
+		return nil, false
+	}
+	defer stream.Close()
+	logging.Add(r.Context(), "llm_first_byte_ms", milliseconds(time.Since(llmStart)))
+
+	entities, err := h.retrieveEntities(ctx, vaultID)
+	if err != nil {
+		logger.Error("vault retrieve failed", "error", err)
+		f.respondError(w, "Vault retrieve failed", http.StatusInternalServerError)
+		return nil, true
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	enc := f.newStreamEncoder(w, rc)
+
+	// A write error means the client has gone; an encoding error is ours,
+	// and the client is still there to be told.
+	writeFailed := func(err error) {
+		if errors.Is(err, errStreamEncode) {
+			streamErr = err
+			logger.Error("stream encoding failed", "error", err)
+			enc.writeError("Stream encoding failed")
+			return
+		}
+		logger.Warn("client stream write failed", "error", err)
+	}
+
+	restorers := make(map[int]*streamRestorer)
+	restorerFor := func(index int) *streamRestorer {
+		sr, ok := restorers[index]
+		if !ok {
+			sr = newStreamRestorer(entities)
+			restorers[index] = sr
+		}
+		return sr
+	}
+
+	// Tool call arguments are JSON, so originals are restored escaped. Each
+	// call streams its own arguments and gets its own restorer.
+	escaped := anonymizer.JSONEscaped(entities)
+	argRestorers := make(map[toolCallKey]*streamRestorer)
+	argRestorerFor := func(key toolCallKey) *streamRestorer {
+		sr, ok := argRestorers[key]
+		if !ok {
+			sr = newStreamRestorer(escaped)
+			argRestorers[key] = sr
+		}
+		return sr
+	}
+
+	var last models.ChatCompletionChunk
+	chunks := 0
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			streamErr = err
+			logger.Error("LLM stream interrupted", "error", err)
+			enc.writeError("LLM stream interrupted")
+			return usage, true
+		}
+		last = chunk
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		for i := range chunk.Choices {
+			choice := &chunk.Choices[i]
+			sr := restorerFor(choice.Index)
+			choice.Delta.Content = sr.Write(choice.Delta.Content)
+			var calls []models.ToolCall
+			for j, call := range choice.Delta.ToolCalls {
+				key := toolCallKey{choice.Index, toolCallIndex(call, j)}
+				if call.ID != "" {
+					// A new call completes the text and the calls streamed
+					// before it.
+					choice.Delta.Content += sr.Flush()
+					calls = append(calls, flushToolCalls(argRestorers, choice.Index, key.call)...)
+				}
+				call.Function.Arguments = argRestorerFor(key).Write(call.Function.Arguments)
+				calls = append(calls, call)
+			}
+			choice.Delta.ToolCalls = calls
+			if choice.FinishReason != nil {
+				choice.Delta.Content += sr.Flush()
+				delete(restorers, choice.Index)
+				choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, flushToolCalls(argRestorers, choice.Index, math.MaxInt)...)
+			}
+		}
+
+		if err := enc.writeChunk(chunk); err != nil {
+			writeFailed(err)
+			return usage, true
+		}
+		chunks++
+	}
+
+	// Providers that end the stream without a finish_reason still owe the
+	// client whatever was held back waiting for a possible token.
+	for index, sr := range restorers {
+		if rest := sr.Flush(); rest != "" {
+			tail := models.ChatCompletionChunk{
+				ID:      last.ID,
+				Object:  last.Object,
+				Created: last.Created,
+				Model:   last.Model,
+				Choices: []models.ChunkChoice{{Index: index, Delta: models.Delta{Content: rest}}},
+			}
+			if err := enc.writeChunk(tail); err != nil {
+				writeFailed(err)
+				return usage, true
+			}
+		}
+	}
+
+	for _, index := range pendingChoices(argRestorers) {
+		tail := models.ChatCompletionChunk{
+			ID:      last.ID,
+			Object:  last.Object,
+			Created: last.Created,
+			Model:   last.Model,
+			Choices: []models.ChunkChoice{{Index: index, Delta: models.Delta{ToolCalls: flushToolCalls(argRestorers, index, math.MaxInt)}}},
+		}
+		if len(tail.Choices[0].Delta.ToolCalls) == 0 {
+			continue
+		}
+		if err := enc.writeChunk(tail); err != nil {
+			writeFailed(err)
+			return usage, true
+		}
+	}
+
+	if err := enc.close(); err != nil {
+		writeFailed(err)
+		return usage, true
+	}
+
+	logging.Add(r.Context(), "llm_ms", milliseconds(time.Since(llmStart)), "chunks", chunks)
+	return usage, true
+}
+
+// toolCallKey identifies one streamed tool call within a choice.
+type toolCallKey struct {
+	choice int
+	call   int
+}
+
+// toolCallIndex returns the index a streamed tool call fragment belongs to.
+// Providers that send whole calls may omit it, so position stands in.
+func toolCallIndex(call models.ToolCall, position int) int {
+	if call.Index != nil {
+		return *call.Index
+	}
+	return position
+}
+
+// flushToolCalls releases the arguments held back for the tool calls of
+// choice numbered below limit, as fragments to add to its delta.
+func flushToolCalls(restorers map[toolCallKey]*streamRestorer, choice, limit int) []models.ToolCall {
+	var keys []toolCallKey
+	for key := range restorers {
+		if key.choice == choice && key.call < limit {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].call < keys[j].call })
+
+	var calls []models.ToolCall
+	for _, key := range keys {
+		if rest := restorers[key].Flush(); rest != "" {
+			calls = append(calls, models.ToolCall{Index: &key.call, Function: models.FunctionCall{Arguments: rest}})
+		}
+		delete(restorers, key)
+	}
+	return calls
+}
+
+// pendingChoices returns, in order, the choices with tool call restorers
+// left over after a stream ended without a finish_reason.
+func pendingChoices(restorers map[toolCallKey]*streamRestorer) []int {
+	seen := make(map[int]bool)
+	var choices []int
+	for key := range restorers {
+		if !seen[key.choice] {
+			seen[key.choice] = true
+			choices = append(choices, key.choice)
+		}
+	}
+	sort.Ints(choices)
+	return choices
+}
+
+// chatStreamEncoder writes OpenAI chat completion chunks, ending with
+// "data: [DONE]".
+type chatStreamEncoder struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (e *chatStreamEncoder) writeChunk(chunk models.ChatCompletionChunk) error {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errStreamEncode, err)
+	}
+	return writeSSE(e.w, e.rc, "", string(data))
+}
+
+func (e *chatStreamEncoder) writeError(message string) {
+	data, _ := json.Marshal(map[string]string{"error": message})
+	writeSSE(e.w, e.rc, "", string(data))
+}
+
+func (e *chatStreamEncoder) close() error {
+	return writeSSE(e.w, e.rc, "", "[DONE]")
+}
+
+// writeSSE writes one server-sent event, with an event name unless name is
+// empty, and flushes it to the client.
+func writeSSE(w http.ResponseWriter, rc *http.ResponseController, name, data string) error {
+	rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if name != "" {
+		if _, err := fmt.Fprintf(w, "event: %s\n", name); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// streamRestorer replaces vault tokens with their originals in a stream of
+// text deltas. Any trailing text that could still grow into a token is held
+// back until the next delta (or Flush) settles it.
+type streamRestorer struct {
+	entities []models.Entity
+	pending  string
+}
+
+func newStreamRestorer(entities []models.Entity) *streamRestorer {
+	return &streamRestorer{entities: entities}
+}
+
+func (s *streamRestorer) Write(delta string) string {
+	return s.restore(s.pending+delta, true)
+}
+
+func (s *streamRestorer) Flush() string {
+	return s.restore(s.pending, false)
+}
+
+func (s *streamRestorer) restore(text string, holdBack bool) string {
+	s.pending = ""
+
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		if entity, ok := s.tokenAt(rest); ok {
+			out.WriteString(entity.Original)
+			i += len(entity.Token)
+			continue
+		}
+
+		if holdBack && s.isTokenPrefix(rest) {
+			s.pending = rest
+			break
+		}
+
+		out.WriteByte(text[i])
+		i++
+	}
+	return out.String()
+}
+
+func (s *streamRestorer) tokenAt(text string) (models.Entity, bool) {
+	var best models.Entity
+	found := false
+	for _, entity := range s.entities {
+		if entity.Token == "" || !strings.HasPrefix(text, entity.Token) {
+			continue
+		}
+		if !found || len(entity.Token) > len(best.Token) {
+			best = entity
+			found = true
+		}
+	}
+	return best, found
+}
+
+func (s *streamRestorer) isTokenPrefix(text string) bool {
+	for _, entity := range s.entities {
+		if len(text) < len(entity.Token) && strings.HasPrefix(entity.Token, text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/requestid"
+	"github.com/saferoute/proxy/internal/services"
+)
+
+type mockStream struct {
+	chunks []models.ChatCompletionChunk
+	err    error
+	closed bool
+}
+
+func (s *mockStream) Recv() (models.ChatCompletionChunk, error) {
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return models.ChatCompletionChunk{}, s.err
+		}
+		return models.ChatCompletionChunk{}, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *mockStream) Close() error {
+	s.closed = true
+	return nil
+}
+
+type mockStreamingLLMClient struct {
+	mockLLMClient
+	stream     *mockStream
+	shouldFail bool
+	lastReq    models.ChatCompletionRequest
+}
+
+func (m *mockStreamingLLMClient) ChatCompletionStream(ctx context.Context, req models.ChatCompletionRequest) (services.ChatCompletionStream, error) {
+	m.lastReq = req
+	if m.shouldFail {
+		return nil, errors.New("LLM stream error")
+	}
+	return m.stream, nil
+}
+
+func deltaChunks(deltas ...string) []models.ChatCompletionChunk {
+	stop := "stop"
+	chunks := make([]models.ChatCompletionChunk, 0, len(deltas))
+	for i, d := range deltas {
+		chunk := models.ChatCompletionChunk{
+			ID:      "chunk-1",
+			Object:  "chat.completion.chunk",
+			Model:   "claude-3",
+			Choices: []models.ChunkChoice{{Index: 0, Delta: models.Delta{Content: d}}},
+		}
+		if i == len(deltas)-1 {
+			chunk.Choices[0].FinishReason = &stop
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func readSSEContent(t *testing.T, body io.Reader) (string, bool) {
+	t.Helper()
+
+	var content strings.Builder
+	done := false
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk models.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", data, err)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+		}
+	}
+	return content.String(), done
+}
+
+func newStreamRequest() *http.Request {
+	reqBody := models.ChatCompletionRequest{
+		Model:  "claude-3",
+		Stream: true,
+		Messages: []models.Message{
+			{Role: "user", Content: "My email is john@example.com and SSN is 123-45-6789"},
+		},
+	}
+	body, _ := json.Marshal(reqBody)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBuffer(body))
+	ctx := requestid.With(req.Context(), "test-request-123")
+	return req.WithContext(ctx)
+}
+
+func TestStreamRestorer_SplitTokens(t *testing.T) {
+	entities := []models.Entity{
+		{Original: "john@example.com", Token: "[EMAIL_001]"},
+		{Original: "123-45-6789", Token: "[SSN_001]"},
+	}
+
+	tests := []struct {
+		name   string
+		deltas []string
+		want   string
+	}{
+		{"whole tokens", []string{"Hi [EMAIL_001], SSN [SSN_001]."}, "Hi john@example.com, SSN 123-45-6789."},
+		{"split token", []string{"Hi [EMA", "IL_0", "01]!"}, "Hi john@example.com!"},
+		{"split per byte", strings.Split("[SSN_001][EMAIL_001]", ""), "123-45-6789john@example.com"},
+		{"bracket that is not a token", []string{"array[0", "] = 1"}, "array[0] = 1"},
+		{"trailing partial token", []string{"ends with [EMAIL_0"}, "ends with [EMAIL_0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr := newStreamRestorer(entities)
+			var got strings.Builder
+			for _, d := range tt.deltas {
+				got.WriteString(sr.Write(d))
+			}
+			got.WriteString(sr.Flush())
+
+			if got.String() != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got.String())
+			}
+		})
+	}
+}
+
+func TestStreamRestorer_HoldsBackOnlyTokenPrefixes(t *testing.T) {
+	sr := newStreamRestorer([]models.Entity{{Original: "john@example.com", Token: "[EMAIL_001]"}})
+
+	if got := sr.Write("Hello [EM"); got != "Hello " {
+		t.Errorf("Expected %q, got %q", "Hello ", got)
+	}
+	if got := sr.Write("AIL_001] there"); got != "john@example.com there" {
+		t.Errorf("Expected %q, got %q", "john@example.com there", got)
+	}
+}
+
+func TestHandleChatCompletion_Stream(t *testing.T) {
+	stream := &mockStream{chunks: deltaChunks("Contact [EMA", "IL_001] or [SSN", "_001] now")}
+	llmClient := &mockStreamingLLMClient{stream: stream}
+
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newStreamRequest())
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", ct)
+	}
+
+	content, done := readSSEContent(t, w.Body)
+	if want := "Contact john@example.com or 123-45-6789 now"; content != want {
+		t.Errorf("Expected %q, got %q", want, content)
+	}
+	if !done {
+		t.Error("Expected stream to end with [DONE]")
+	}
+	if !stream.closed {
+		t.Error("Expected upstream stream to be closed")
+	}
+
+	upstream := llmClient.lastReq.Messages[0].Content
+	if strings.Contains(upstream, "john@example.com") || strings.Contains(upstream, "123-45-6789") {
+		t.Errorf("Expected PII to be tokenized upstream, got %q", upstream)
+	}
+}
+
+func TestHandleChatCompletion_StreamWithoutFinishReason(t *testing.T) {
+	chunks := deltaChunks("Mail [EMAIL_0", "01")
+	chunks[len(chunks)-1].Choices[0].FinishReason = nil
+	llmClient := &mockStreamingLLMClient{stream: &mockStream{chunks: chunks}}
+
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newStreamRequest())
+
+	content, _ := readSSEContent(t, w.Body)
+	if want := "Mail [EMAIL_001"; content != want {
+		t.Errorf("Expected held-back text to be flushed, got %q", content)
+	}
+}
+
+type quotingVaultClient struct {
+	mockVaultClient
+}
+
+func (m *quotingVaultClient) GetEntities(ctx context.Context, requestID string) ([]models.Entity, error) {
+	return []models.Entity{{Original: `John "JJ" Doe`, Token: "[PERSON_001]", Type: "PERSON"}}, nil
+}
+
+func TestHandleChatCompletion_StreamToolCalls(t *testing.T) {
+	index := 0
+	toolChunk := func(call models.ToolCall, finish *string) models.ChatCompletionChunk {
+		call.Index = &index
+		return models.ChatCompletionChunk{
+			ID:      "chunk-1",
+			Choices: []models.ChunkChoice{{Delta: models.Delta{ToolCalls: []models.ToolCall{call}}, FinishReason: finish}},
+		}
+	}
+	stop := "tool_calls"
+	stream := &mockStream{chunks: []models.ChatCompletionChunk{
+		toolChunk(models.ToolCall{ID: "call_1", Type: "function", Function: models.FunctionCall{Name: "greet"}}, nil),
+		toolChunk(models.ToolCall{Function: models.FunctionCall{Arguments: `{"name":"[PER`}}, nil),
+		toolChunk(models.ToolCall{Function: models.FunctionCall{Arguments: `SON_001]"}`}}, &stop),
+	}}
+	llmClient := &mockStreamingLLMClient{stream: stream}
+	handler := NewProxyHandler(&mockNERClient{}, &quotingVaultClient{}, llmClient)
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newStreamRequest())
+
+	var args strings.Builder
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk models.ChatCompletionChunk
+		json.Unmarshal([]byte(data), &chunk)
+		for _, choice := range chunk.Choices {
+			for _, call := range choice.Delta.ToolCalls {
+				args.WriteString(call.Function.Arguments)
+			}
+		}
+	}
+
+	var decoded map[string]string
+	if err := json.Unmarshal([]byte(args.String()), &decoded); err != nil {
+		t.Fatalf("Expected restored arguments to be valid JSON, got %q: %v", args.String(), err)
+	}
+	if decoded["name"] != `John "JJ" Doe` {
+		t.Errorf("Expected the original to be restored, got %q", decoded["name"])
+	}
+}
+
+func TestHandleChatCompletion_StreamNotSupported(t *testing.T) {
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{})
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newStreamRequest())
+
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501, got %d", w.Code)
+	}
+}
+
+func TestHandleChatCompletion_StreamLLMFailure(t *testing.T) {
+	llmClient := &mockStreamingLLMClient{shouldFail: true}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newStreamRequest())
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
+
+func TestHandleChatCompletion_StreamInterrupted(t *testing.T) {
+	stream := &mockStream{chunks: deltaChunks("partial", "more")[:1], err: errors.New("connection reset")}
+	llmClient := &mockStreamingLLMClient{stream: stream}
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, llmClient)
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newStreamRequest())
+
+	body := w.Body.String()
+	if !strings.Contains(body, `"error":"LLM stream interrupted"`) {
+		t.Errorf("Expected error event in stream, got %q", body)
+	}
+	if strings.Contains(body, "[DONE]") {
+		t.Error("Expected no [DONE] after an interrupted stream")
+	}
+}
+
+func TestHandleChatCompletion_StreamCutShort(t *testing.T) {
+	handler := passthroughHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"partial\"}}]}\n\n")
+	})
+
+	w := httptest.NewRecorder()
+	handler.HandleChatCompletion(w, newStreamRequest())
+
+	body := w.Body.String()
+	if !strings.Contains(body, `"error":"LLM stream interrupted"`) {
+		t.Errorf("Expected error event when the upstream stream is cut short, got %q", body)
+	}
+	if strings.Contains(body, "[DONE]") {
+		t.Error("Expected no [DONE] after a truncated stream")
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/middleware"
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/tracing/tracingtest"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func serveTraced(handler *ProxyHandler, body []byte) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", handler.HandleChatCompletion)
+	chain := middleware.Chain(middleware.Routes(mux), middleware.RequestID, middleware.Tracing)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(body))
+	req.Header.Set("traceparent", testTraceparent)
+	w := httptest.NewRecorder()
+	chain.ServeHTTP(w, req)
+	return w
+}
+
+func TestTracing_ChatCompletionStages(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	handler := NewProxyHandler(&mockNERClient{}, &mockVaultClient{}, &mockLLMClient{})
+	body, _ := json.Marshal(models.ChatCompletionRequest{
+		Model:    "claude-3",
+		Messages: []models.Message{{Role: "user", Content: "My email is john@example.com"}},
+	})
+
+	w := serveTraced(handler, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	spans := exporter.GetSpans()
+	server, ok := tracingtest.Find(spans, "POST /v1/chat/completions")
+	if !ok {
+		t.Fatalf("Expected a server span named after the route, got %v", spans)
+	}
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the incoming trace to continue, got trace %s", got)
+	}
+
+	for _, name := range []string{"ner.detect", "policy.apply", "vault.store", "llm.chat_completion", "vault.retrieve"} {
+		span, ok := tracingtest.Find(spans, name)
+		if !ok {
+			t.Errorf("Expected a %s span", name)
+			continue
+		}
+		if span.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("Expected %s to be a child of the server span", name)
+		}
+		for _, attr := range span.Attributes {
+			if attr.Value.Emit() == "john@example.com" {
+				t.Errorf("Expected no PII in %s attributes, got %s", name, attr.Key)
+			}
+		}
+	}
+}
+
+func TestTracing_StageError(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	handler := NewProxyHandler(&mockNERClient{shouldFail: true}, &mockVaultClient{}, &mockLLMClient{})
+	body, _ := json.Marshal(models.ChatCompletionRequest{
+		Model:    "claude-3",
+		Messages: []models.Message{{Role: "user", Content: "Hello"}},
+	})
+
+	w := serveTraced(handler, body)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503, got %d", w.Code)
+	}
+
+	spans := exporter.GetSpans()
+	ner, ok := tracingtest.Find(spans, "ner.detect")
+	if !ok || ner.Status.Code != codes.Error {
+		t.Error("Expected the ner.detect span to record the error")
+	}
+	server, ok := tracingtest.Find(spans, "POST /v1/chat/completions")
+	if !ok || server.Status.Code != codes.Error {
+		t.Error("Expected the server span to be marked as an error")
+	}
+	if _, ok := tracingtest.Find(spans, "vault.store"); ok {
+		t.Error("Expected no vault.store span after NER failed")
+	}
+}
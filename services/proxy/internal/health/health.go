@@ -0,0 +1,190 @@
+// Package health checks the proxy's downstream dependencies for the
+// readiness probe. Results are cached so that frequent probes from several
+// kubelets do not turn into a steady load on the dependencies.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saferoute/proxy/internal/logging"
+)
+
+// Overall readiness states.
+const (
+	StatusReady    = "ready"
+	StatusDegraded = "degraded"
+	StatusNotReady = "not_ready"
+)
+
+// Dependency states.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// circuitOpen matches services.BreakerOpen.String().
+const circuitOpen = "open"
+
+var dependencyUp = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "dependency_up",
+		Help: "Whether a dependency passed its last readiness check (1) or not (0)",
+	},
+	[]string{"dependency", "critical"},
+)
+
+// Check probes one dependency. Probe should return promptly once its
+// context is done; Timeout bounds it. Circuit, if set, reports the state of
+// the circuit breaker guarding the dependency.
+type Check struct {
+	Name     string
+	Critical bool
+	Timeout  time.Duration
+	Probe    func(ctx context.Context) error
+	Circuit  func() string
+}
+
+// DependencyStatus is the outcome of one check. Error is logged but kept
+// out of the /ready body, which is unauthenticated: probe errors can name
+// internal hosts and addresses.
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"-"`
+	Circuit   string  `json:"circuit,omitempty"`
+}
+
+// Report is the readiness of the proxy as a whole. It is StatusNotReady
+// when any critical dependency is down and StatusDegraded when only
+// non-critical ones are, or when a breaker is open on a dependency that
+// answers its probe (the breaker recovers on its own).
+type Report struct {
+	Status       string                      `json:"status"`
+	CheckedAt    time.Time                   `json:"checked_at"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Ready reports whether the proxy should receive traffic.
+func (r Report) Ready() bool {
+	return r.Status != StatusNotReady
+}
+
+type Checker struct {
+	checks []Check
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	report Report
+}
+
+// NewChecker runs checks at most once per ttl.
+func NewChecker(ttl time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks: checks,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Report returns the cached report, refreshing it first when it is older
+// than the ttl. Concurrent callers share one refresh, which the caller
+// going away does not cut short: the result is cached for everyone.
+func (c *Checker) Report(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.report.CheckedAt.IsZero() && c.now().Sub(c.report.CheckedAt) < c.ttl {
+		return c.report
+	}
+	c.report = c.run(context.WithoutCancel(ctx))
+	return c.report
+}
+
+func (c *Checker) run(ctx context.Context) Report {
+	report := Report{
+		Status:       StatusReady,
+		CheckedAt:    c.now(),
+		Dependencies: make(map[string]DependencyStatus, len(c.checks)),
+	}
+
+	results := make([]DependencyStatus, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	for i, check := range c.checks {
+		result := results[i]
+		report.Dependencies[check.Name] = result
+
+		up := 0.0
+		if result.Status == StatusUp {
+			up = 1
+		}
+		dependencyUp.WithLabelValues(check.Name, criticalLabel(check.Critical)).Set(up)
+		if result.Error != "" {
+			logging.FromContext(ctx).Warn("readiness check failed", "dependency", check.Name, "critical", check.Critical, "error", result.Error)
+		}
+
+		switch {
+		case result.Status == StatusDown && check.Critical:
+			report.Status = StatusNotReady
+		case result.Status == StatusDown, result.Circuit == circuitOpen:
+			if report.Status == StatusReady {
+				report.Status = StatusDegraded
+			}
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, check Check) DependencyStatus {
+	if check.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, check.Timeout)
+		defer cancel()
+	}
+
+	// A probe that ignores its context still cannot hold up the report.
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() { done <- check.Probe(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	status := DependencyStatus{
+		Status:    StatusUp,
+		Critical:  check.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = StatusDown
+		status.Error = err.Error()
+	}
+	if check.Circuit != nil {
+		status.Circuit = check.Circuit()
+	}
+	return status
+}
+
+func criticalLabel(critical bool) string {
+	if critical {
+		return "true"
+	}
+	return "false"
+}
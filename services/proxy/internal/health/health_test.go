@@ -0,0 +1,136 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func probe(err error) func(context.Context) error {
+	return func(context.Context) error { return err }
+}
+
+func TestChecker_AllUp(t *testing.T) {
+	checker := NewChecker(time.Second,
+		Check{Name: "ner", Critical: true, Probe: probe(nil)},
+		Check{Name: "vault", Critical: true, Probe: probe(nil)},
+	)
+
+	report := checker.Report(context.Background())
+	if report.Status != StatusReady || !report.Ready() {
+		t.Errorf("Expected ready, got %s", report.Status)
+	}
+	if len(report.Dependencies) != 2 || report.Dependencies["ner"].Status != StatusUp {
+		t.Errorf("Unexpected dependencies: %+v", report.Dependencies)
+	}
+}
+
+func TestChecker_CriticalDown(t *testing.T) {
+	checker := NewChecker(time.Second,
+		Check{Name: "ner", Critical: true, Probe: probe(errors.New("connection refused"))},
+		Check{Name: "llm:openai", Probe: probe(nil)},
+	)
+
+	report := checker.Report(context.Background())
+	if report.Status != StatusNotReady || report.Ready() {
+		t.Errorf("Expected not_ready, got %s", report.Status)
+	}
+	ner := report.Dependencies["ner"]
+	if ner.Status != StatusDown || ner.Error != "connection refused" || !ner.Critical {
+		t.Errorf("Unexpected ner status: %+v", ner)
+	}
+	if got := testutil.ToFloat64(dependencyUp.WithLabelValues("ner", "true")); got != 0 {
+		t.Errorf("Expected dependency_up 0 for ner, got %v", got)
+	}
+	if got := testutil.ToFloat64(dependencyUp.WithLabelValues("llm:openai", "false")); got != 1 {
+		t.Errorf("Expected dependency_up 1 for llm:openai, got %v", got)
+	}
+}
+
+func TestChecker_NonCriticalDown(t *testing.T) {
+	checker := NewChecker(time.Second,
+		Check{Name: "vault", Critical: true, Probe: probe(nil)},
+		Check{Name: "redis", Probe: probe(errors.New("down"))},
+	)
+
+	report := checker.Report(context.Background())
+	if report.Status != StatusDegraded || !report.Ready() {
+		t.Errorf("Expected degraded but ready, got %s", report.Status)
+	}
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	checker := NewChecker(time.Second, Check{
+		Name:     "vault",
+		Critical: true,
+		Timeout:  20 * time.Millisecond,
+		// Ignores its context on purpose.
+		Probe: func(context.Context) error { <-block; return nil },
+	})
+
+	start := time.Now()
+	report := checker.Report(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the timeout to cut the check short, took %v", elapsed)
+	}
+	if report.Dependencies["vault"].Status != StatusDown {
+		t.Errorf("Expected vault down after timeout, got %+v", report.Dependencies["vault"])
+	}
+}
+
+func TestChecker_CachesWithinTTL(t *testing.T) {
+	calls := 0
+	checker := NewChecker(5*time.Second, Check{
+		Name:  "ner",
+		Probe: func(context.Context) error { calls++; return nil },
+	})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	checker.now = func() time.Time { return now }
+
+	checker.Report(context.Background())
+	now = now.Add(4 * time.Second)
+	checker.Report(context.Background())
+	if calls != 1 {
+		t.Errorf("Expected 1 probe within the TTL, got %d", calls)
+	}
+
+	now = now.Add(2 * time.Second)
+	checker.Report(context.Background())
+	if calls != 2 {
+		t.Errorf("Expected a new probe after the TTL, got %d", calls)
+	}
+}
+
+func TestChecker_IgnoresCallerCancellation(t *testing.T) {
+	checker := NewChecker(time.Second, Check{Name: "ner", Critical: true, Probe: func(ctx context.Context) error {
+		return ctx.Err()
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if report := checker.Report(ctx); !report.Ready() {
+		t.Errorf("Expected a canceled probe request not to mark dependencies down, got %+v", report)
+	}
+}
+
+func TestChecker_ReportsCircuitState(t *testing.T) {
+	checker := NewChecker(time.Second,
+		Check{Name: "ner", Critical: true, Probe: probe(nil), Circuit: func() string { return "open" }},
+		Check{Name: "vault", Critical: true, Probe: probe(nil), Circuit: func() string { return "closed" }},
+	)
+
+	report := checker.Report(context.Background())
+	if report.Status != StatusDegraded || !report.Ready() {
+		t.Errorf("Expected degraded but ready with an open breaker, got %s", report.Status)
+	}
+	if report.Dependencies["ner"].Circuit != "open" || report.Dependencies["vault"].Circuit != "closed" {
+		t.Errorf("Unexpected circuit states: %+v", report.Dependencies)
+	}
+}
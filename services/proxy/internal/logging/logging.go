@@ -0,0 +1,114 @@
+// Package logging sets up structured JSON logs and carries a request-scoped
+// logger through the context.
+//
+// Log fields must never hold detected values or prompt text. Entities and
+// messages implement slog.LogValuer (see the models package) so that even
+// logging one directly records only its type, token and sizes.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// New returns a JSON logger writing to w at the named level ("debug",
+// "info", "warn" or "error"; anything else means info).
+func New(w io.Writer, level string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       ParseLevel(level),
+		ReplaceAttr: resolveSlices,
+	}))
+}
+
+var logValuerType = reflect.TypeFor[slog.LogValuer]()
+
+// resolveSlices applies LogValue to the elements of logged slices. slog
+// only resolves top-level values, so without this a []models.Entity would
+// be encoded as JSON with every Original in it.
+func resolveSlices(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindAny {
+		return a
+	}
+	v := reflect.ValueOf(a.Value.Any())
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return a
+	}
+	if !v.Type().Elem().Implements(logValuerType) {
+		return a
+	}
+
+	items := make([]any, v.Len())
+	for i := range items {
+		items[i] = attrsToMap(v.Index(i).Interface().(slog.LogValuer).LogValue().Resolve())
+	}
+	return slog.Any(a.Key, items)
+}
+
+func attrsToMap(v slog.Value) any {
+	if v.Kind() != slog.KindGroup {
+		return v.Any()
+	}
+	m := make(map[string]any)
+	for _, attr := range v.Group() {
+		m[attr.Key] = attrsToMap(attr.Value.Resolve())
+	}
+	return m
+}
+
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// requestLog holds the fields gathered while a request is handled, so the
+// access log line written when it completes includes fields added deeper in
+// the chain, such as the tenant or the latency breakdown.
+type requestLog struct {
+	base *slog.Logger
+
+	mu    sync.Mutex
+	attrs []any
+}
+
+type contextKey struct{}
+
+// Start returns a context carrying a request logger derived from base with
+// attrs attached.
+func Start(ctx context.Context, base *slog.Logger, attrs ...any) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestLog{base: base, attrs: attrs})
+}
+
+// Add attaches attrs to the request logger in ctx, for this and every later
+// log line of the request. It does nothing if ctx has no request logger.
+func Add(ctx context.Context, attrs ...any) {
+	rl, ok := ctx.Value(contextKey{}).(*requestLog)
+	if !ok {
+		return
+	}
+	rl.mu.Lock()
+	rl.attrs = append(rl.attrs, attrs...)
+	rl.mu.Unlock()
+}
+
+// FromContext returns the request logger in ctx, or slog.Default().
+func FromContext(ctx context.Context) *slog.Logger {
+	rl, ok := ctx.Value(contextKey{}).(*requestLog)
+	if !ok {
+		return slog.Default()
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.base.With(rl.attrs...)
+}
@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+func TestLogValuers_OmitOriginals(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "debug")
+
+	entities := []models.Entity{
+		{Original: "john@example.com", Token: "[EMAIL_001]", Type: "EMAIL"},
+		{Original: "123-45-6789", Token: "[SSN_001]", Type: "SSN"},
+	}
+	req := models.ChatCompletionRequest{
+		Model:    "claude-3",
+		Messages: []models.Message{{Role: "user", Content: "secret prompt text"}},
+	}
+
+	logger.Info("entity", "entity", entities[0])
+	logger.Info("entities", "entities", entities)
+	logger.Info("array", "entities", [2]models.Entity{entities[0], entities[1]})
+	logger.Info("request", "request", req)
+	logger.Info("messages", "messages", req.Messages)
+	logger.Info("grouped", slog.Group("g", "entities", entities))
+
+	out := buf.String()
+	for _, leak := range []string{"john@example.com", "123-45-6789", "secret prompt text"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("Log output leaked %q:\n%s", leak, out)
+		}
+	}
+	if !strings.Contains(out, "[EMAIL_001]") || !strings.Contains(out, `"content_length":18`) {
+		t.Errorf("Expected tokens and sizes in the log, got:\n%s", out)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := Start(context.Background(), New(&buf, "info"), "request_id", "req-1")
+	Add(ctx, "tenant", "acme")
+
+	FromContext(ctx).Debug("hidden")
+	FromContext(ctx).Info("done", "status", 200)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a single JSON line, got %q", buf.String())
+	}
+	if line["request_id"] != "req-1" || line["tenant"] != "acme" || line["status"] != float64(200) {
+		t.Errorf("Unexpected fields %v", line)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+		"":        slog.LevelInfo,
+	}
+	for value, want := range tests {
+		if got := ParseLevel(value); got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
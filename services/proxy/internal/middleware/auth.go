@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/saferoute/proxy/internal/auth"
+	"github.com/saferoute/proxy/internal/logging"
+)
+
+// Authenticate requires a tenant API key on /v1/ routes, sent as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>", and attaches the
+// owning tenant to the request context. Other routes (health, readiness and
+// metrics) stay open.
+func Authenticate(store auth.KeyStore) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/v1/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := apiKey(r)
+			if key == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="saferoute"`)
+				respondJSONError(w, "Missing API key", http.StatusUnauthorized)
+				return
+			}
+
+			tenant, err := store.Lookup(r.Context(), auth.HashKey(key))
+			if errors.Is(err, auth.ErrUnknownKey) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="saferoute", error="invalid_token"`)
+				respondJSONError(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				logging.FromContext(r.Context()).Error("API key lookup failed", "error", err)
+				respondJSONError(w, "Authentication unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			logging.Add(r.Context(), "tenant", tenant.ID)
+			next.ServeHTTP(w, r.WithContext(auth.WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+func apiKey(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+func respondJSONError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/auth"
+)
+
+type mapKeyStore map[string]auth.Tenant
+
+func (m mapKeyStore) Lookup(ctx context.Context, keyHash string) (auth.Tenant, error) {
+	tenant, ok := m[keyHash]
+	if !ok {
+		return auth.Tenant{}, auth.ErrUnknownKey
+	}
+	return tenant, nil
+}
+
+type failingKeyStore struct{}
+
+func (failingKeyStore) Lookup(ctx context.Context, keyHash string) (auth.Tenant, error) {
+	return auth.Tenant{}, errors.New("redis down")
+}
+
+func TestAuthenticate(t *testing.T) {
+	store := mapKeyStore{auth.HashKey("sk-acme"): {ID: "acme"}}
+
+	var gotTenant auth.Tenant
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant, _ = auth.TenantFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	wrapped := Authenticate(store)(handler)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{"bearer", "/v1/chat/completions", "Authorization", "Bearer sk-acme", http.StatusOK},
+		{"x-api-key", "/v1/messages", "X-API-Key", "sk-acme", http.StatusOK},
+		{"missing", "/v1/anonymize", "", "", http.StatusUnauthorized},
+		{"unknown", "/v1/anonymize", "Authorization", "Bearer sk-other", http.StatusUnauthorized},
+		{"health open", "/health", "", "", http.StatusOK},
+		{"metrics open", "/metrics", "", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTenant = auth.Tenant{}
+			req := httptest.NewRequest("POST", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+
+			wrapped.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+			if tt.want == http.StatusOK && tt.header != "" && gotTenant.ID != "acme" {
+				t.Errorf("Expected tenant acme in context, got %+v", gotTenant)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_StoreFailure(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the request not to reach the handler")
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer sk-acme")
+	w := httptest.NewRecorder()
+
+	Authenticate(failingKeyStore{})(handler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/auth"
+	"github.com/saferoute/proxy/internal/logging"
+)
+
+func TestLogger_AccessLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(&buf, "info"))
+	defer slog.SetDefault(previous)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	store := mapKeyStore{auth.HashKey("sk-acme"): {ID: "acme"}}
+	wrapped := Chain(Routes(mux), RequestID, Logger, Authenticate(store))
+
+	req := httptest.NewRequest("GET", "/v1/items/42", nil)
+	req.Header.Set("Authorization", "Bearer sk-acme")
+	wrapped.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON log line, got %q", buf.String())
+	}
+	if entry["status"] != float64(404) || entry["tenant"] != "acme" || entry["route"] != "GET /v1/items/{id}" {
+		t.Errorf("Unexpected access log %v", entry)
+	}
+	if entry["request_id"] == nil || entry["latency_ms"] == nil {
+		t.Errorf("Expected request_id and latency_ms, got %v", entry)
+	}
+}
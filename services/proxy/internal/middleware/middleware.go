@@ -2,15 +2,18 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
-	"strings"
-	"sync"
+	"strconv"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saferoute/proxy/internal/clientip"
+	"github.com/saferoute/proxy/internal/logging"
+	"github.com/saferoute/proxy/internal/requestid"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Middleware func(http.Handler) http.Handler
@@ -22,102 +25,135 @@ func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
 	return h
 }
 
+// RequestID attaches the request ID to the context and echoes it in the
+// X-Request-ID response header, error responses included. A valid ID sent
+// by the client is kept so it can correlate retries in logs; anything else
+// is replaced with a fresh UUIDv7. It is for correlation only and never
+// keys vault entries.
 func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := uuid.New().String()
-		ctx := context.WithValue(r.Context(), "request_id", requestID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.With(r.Context(), id)))
 	})
 }
 
+// Logger starts the request-scoped logger (see logging.FromContext) and
+// writes one access log line per request with its status and latency, plus
+// any fields handlers added along the way.
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		requestID := r.Context().Value("request_id")
+		id, _ := requestid.FromContext(r.Context())
+		ctx := logging.Start(r.Context(), slog.Default(),
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"client_ip", clientip.String(r),
+		)
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			logging.Add(ctx, "trace_id", sc.TraceID().String())
+		}
 
-		log.Printf("[%v] %s %s", requestID, r.Method, r.URL.Path)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
 
-		next.ServeHTTP(w, r)
-
-		log.Printf("[%v] Completed in %v", requestID, time.Since(start))
+		logging.FromContext(ctx).Info("request completed",
+			"status", sw.Status(),
+			"latency_ms", milliseconds(time.Since(start)),
+		)
 	})
 }
 
-func CORS(next http.Handler) http.Handler {
+// Routes tags each request's log fields and server span with the mux
+// pattern that serves it, which groups requests better than the raw path.
+// It must wrap the mux directly.
+func Routes(mux *http.ServeMux) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+		if _, pattern := mux.Handler(r); pattern != "" {
+			logging.Add(r.Context(), "route", pattern)
+			if route, ok := r.Context().Value(routeKey{}).(*matchedRoute); ok {
+				route.pattern = pattern
+			}
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + pattern)
+			span.SetAttributes(semconv.HTTPRoute(pattern))
 		}
-
-		next.ServeHTTP(w, r)
+		mux.ServeHTTP(w, r)
 	})
 }
 
-func RateLimit(requestsPerSecond int, window time.Duration) Middleware {
-	type client struct {
-		limiter  *time.Ticker
-		lastSeen time.Time
-		count    int
+type routeKey struct{}
+
+// matchedRoute carries the pattern Routes matched back out to middleware
+// that wraps the mux from further out.
+type matchedRoute struct {
+	pattern string
+}
+
+// statusWriter records the status code written through it. Unwrap lets
+// http.ResponseController reach the underlying writer for flushing.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
 	}
+	w.ResponseWriter.WriteHeader(status)
+}
 
-	clients := make(map[string]*client)
-	var mu sync.Mutex
-
-	// Cleanup old clients every minute
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-			for ip, c := range clients {
-				if time.Since(c.lastSeen) > time.Minute {
-					c.limiter.Stop()
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
-		}
-	}()
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
 
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
-			if idx := strings.LastIndex(ip, ":"); idx != -1 {
-				ip = ip[:idx]
-			}
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
 
-			mu.Lock()
-			c, exists := clients[ip]
-			if !exists {
-				c = &client{
-					limiter:  time.NewTicker(window),
-					lastSeen: time.Now(),
-					count:    0,
-				}
-				clients[ip] = c
-			}
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
 
-			c.lastSeen = time.Now()
+// CORS answers cross-origin requests from allowedOrigins only. An entry of
+// "*" allows any origin.
+func CORS(allowedOrigins []string) Middleware {
+	allowed := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		allowed[origin] = true
+	}
 
-			select {
-			case <-c.limiter.C:
-				c.count = 0
-			default:
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			if origin != "" && (allowed[origin] || allowed["*"]) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 			}
 
-			if c.count >= requestsPerSecond {
-				mu.Unlock()
-				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
 				return
 			}
 
-			c.count++
-			mu.Unlock()
-
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -127,7 +163,7 @@ func Recovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
+				logging.FromContext(r.Context()).Error("panic recovered", "panic", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
@@ -141,7 +177,7 @@ var (
 			Name: "http_requests_total",
 			Help: "Total number of HTTP requests",
 		},
-		[]string{"method", "path", "status"},
+		[]string{"method", "route", "status"},
 	)
 
 	httpRequestDuration = promauto.NewHistogramVec(
@@ -150,18 +186,36 @@ var (
 			Help:    "HTTP request duration in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"method", "path"},
+		[]string{"method", "route", "status"},
 	)
 )
 
+// Metrics counts and times requests by method, route and the status
+// actually written. The route is the mux pattern found by Routes, or
+// "unmatched", so raw paths never become label values. It should wrap the
+// middleware that can reject requests, so that those count too.
 func Metrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		route := &matchedRoute{pattern: "unmatched"}
+		sw := &statusWriter{ResponseWriter: w}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), routeKey{}, route)))
 
-		duration := time.Since(start).Seconds()
-		httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(duration)
-		httpRequestsTotal.WithLabelValues(r.Method, r.URL.Path, "200").Inc()
+		method := methodLabel(r.Method)
+		status := strconv.Itoa(sw.Status())
+		httpRequestDuration.WithLabelValues(method, route.pattern, status).Observe(time.Since(start).Seconds())
+		httpRequestsTotal.WithLabelValues(method, route.pattern, status).Inc()
 	})
 }
+
+// methodLabel keeps arbitrary client-chosen methods out of metric labels.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
@@ -3,16 +3,22 @@ package middleware
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
-	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/saferoute/proxy/internal/requestid"
 )
 
 func TestRequestID(t *testing.T) {
+	var seen string
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := r.Context().Value("request_id")
-		if requestID == nil {
-			t.Error("Expected request_id in context")
+		id, ok := requestid.FromContext(r.Context())
+		if !ok {
+			t.Error("Expected a request ID in context")
 		}
+		seen = id
 		w.WriteHeader(http.StatusOK)
 	})
 
@@ -26,6 +32,61 @@ func TestRequestID(t *testing.T) {
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
+	if parsed, err := uuid.Parse(seen); err != nil || parsed.Version() != 7 {
+		t.Errorf("Expected a generated UUIDv7, got %q", seen)
+	}
+	if w.Header().Get("X-Request-ID") != seen {
+		t.Errorf("Expected X-Request-ID %q, got %q", seen, w.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestRequestID_ClientSupplied(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		kept   bool
+	}{
+		{"valid", "retry-7f3a.2", true},
+		{"path traversal", "../purge", false},
+		{"too long", strings.Repeat("x", requestid.MaxLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			wrapped := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen, _ = requestid.FromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.Header.Set("X-Request-ID", tt.header)
+			w := httptest.NewRecorder()
+			wrapped.ServeHTTP(w, req)
+
+			if kept := seen == tt.header; kept != tt.kept {
+				t.Errorf("Expected kept=%v, got request ID %q", tt.kept, seen)
+			}
+			if w.Header().Get("X-Request-ID") != seen {
+				t.Errorf("Expected the request ID to be echoed, got %q", w.Header().Get("X-Request-ID"))
+			}
+		})
+	}
+}
+
+func TestRequestID_EchoedOnErrors(t *testing.T) {
+	wrapped := Chain(http.NotFoundHandler(), RequestID, Authenticate(mapKeyStore{}), Recovery)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	req.Header.Set("X-Request-ID", "client-123")
+	w := httptest.NewRecorder()
+	wrapped.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401, got %d", w.Code)
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "client-123" {
+		t.Errorf("Expected X-Request-ID client-123 on the error response, got %q", got)
+	}
 }
 
 func TestCORS(t *testing.T) {
@@ -33,7 +94,7 @@ func TestCORS(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	wrapped := CORS(handler)
+	wrapped := CORS([]string{"http://localhost:3000"})(handler)
 
 	req := httptest.NewRequest("OPTIONS", "/test", nil)
 	req.Header.Set("Origin", "http://localhost:3000")
@@ -50,37 +111,6 @@ func TestCORS(t *testing.T) {
 	}
 }
 
-func TestRateLimit(t *testing.T) {
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-
-	wrapped := RateLimit(2, 1*time.Second)(handler)
-
-	// Use same IP for all requests to trigger rate limiting
-	// First two requests should succeed
-	for i := range 2 {
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.RemoteAddr = "192.168.1.1:1234"
-		w := httptest.NewRecorder()
-		wrapped.ServeHTTP(w, req)
-
-		if w.Code != http.StatusOK {
-			t.Errorf("Request %d: Expected status 200, got %d", i+1, w.Code)
-		}
-	}
-
-	// Third request from same IP should be rate limited
-	req := httptest.NewRequest("GET", "/test", nil)
-	req.RemoteAddr = "192.168.1.1:1234"
-	w := httptest.NewRecorder()
-	wrapped.ServeHTTP(w, req)
-
-	if w.Code != http.StatusTooManyRequests {
-		t.Errorf("Expected status 429, got %d", w.Code)
-	}
-}
-
 func TestRecovery(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		panic("test panic")
@@ -121,15 +151,16 @@ func TestCORS_NonOptions(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	wrapped := CORS(handler)
+	wrapped := CORS([]string{"http://localhost:3000"})(handler)
 
 	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
 	w := httptest.NewRecorder()
 
 	wrapped.ServeHTTP(w, req)
 
-	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
-		t.Error("Expected CORS headers to be set")
+	if w.Header().Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Error("Expected the allowed origin to be echoed")
 	}
 
 	if w.Code != http.StatusOK {
@@ -137,29 +168,30 @@ func TestCORS_NonOptions(t *testing.T) {
 	}
 }
 
-func TestMetrics(t *testing.T) {
+func TestCORS_DisallowedOrigin(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	wrapped := Metrics(handler)
+	wrapped := CORS([]string{"http://localhost:3000"})(handler)
 
 	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Origin", "https://evil.example")
 	w := httptest.NewRecorder()
 
 	wrapped.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no CORS headers for a disallowed origin, got %q", got)
 	}
 }
 
-func TestChain(t *testing.T) {
+func TestMetrics(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	wrapped := Chain(handler, RequestID, Logger, CORS)
+	wrapped := Metrics(handler)
 
 	req := httptest.NewRequest("GET", "/test", nil)
 	w := httptest.NewRecorder()
@@ -171,75 +203,42 @@ func TestChain(t *testing.T) {
 	}
 }
 
-func TestRateLimit_IPParsing(t *testing.T) {
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+func TestMetrics_StatusAndRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/widgets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
 	})
+	wrapped := Metrics(Routes(mux))
 
-	wrapped := RateLimit(10, 1*time.Second)(handler)
+	before := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("POST", "/v1/widgets/{id}", "418"))
+	beforeUnmatched := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("OTHER", "unmatched", "404"))
 
-	// Test with IPv6 format
-	req := httptest.NewRequest("GET", "/test", nil)
-	req.RemoteAddr = "[::1]:8080"
-	w := httptest.NewRecorder()
-
-	wrapped.ServeHTTP(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+	for _, id := range []string{"1", "2"} {
+		wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/widgets/"+id, nil))
 	}
-}
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/no/such/path", nil))
 
-func TestRateLimit_WindowReset(t *testing.T) {
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-
-	wrapped := RateLimit(2, 100*time.Millisecond)(handler)
-
-	// First two requests should succeed
-	for i := range 2 {
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.RemoteAddr = "192.168.1.100:5678"
-		w := httptest.NewRecorder()
-		wrapped.ServeHTTP(w, req)
-
-		if w.Code != http.StatusOK {
-			t.Errorf("Request %d: Expected status 200, got %d", i+1, w.Code)
-		}
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("POST", "/v1/widgets/{id}", "418")) - before; got != 2 {
+		t.Errorf("Expected 2 requests under the route pattern with status 418, got %v", got)
 	}
-
-	// Wait for window to reset
-	time.Sleep(150 * time.Millisecond)
-
-	// Should succeed after window reset
-	req := httptest.NewRequest("GET", "/test", nil)
-	req.RemoteAddr = "192.168.1.100:5678"
-	w := httptest.NewRecorder()
-	wrapped.ServeHTTP(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200 after window reset, got %d", w.Code)
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("OTHER", "unmatched", "404")) - beforeUnmatched; got != 1 {
+		t.Errorf("Expected 1 unmatched request with status 404, got %v", got)
 	}
 }
 
-func TestRateLimit_CleanupOldClients(t *testing.T) {
+func TestChain(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	wrapped := RateLimit(10, 1*time.Second)(handler)
+	wrapped := Chain(handler, RequestID, Logger, CORS([]string{"*"}))
 
-	// Make request to create client entry
 	req := httptest.NewRequest("GET", "/test", nil)
-	req.RemoteAddr = "192.168.1.200:9999"
 	w := httptest.NewRecorder()
+
 	wrapped.ServeHTTP(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
-
-	// Wait for cleanup goroutine to run (it runs every minute, but we just verify it doesn't crash)
-	time.Sleep(10 * time.Millisecond)
 }
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saferoute/proxy/internal/auth"
+	"github.com/saferoute/proxy/internal/clientip"
+	"github.com/saferoute/proxy/internal/logging"
+)
+
+// Rate limit keys select who shares a bucket.
+const (
+	RateLimitByTenant = "tenant"
+	RateLimitByAPIKey = "api_key"
+	RateLimitByIP     = "ip"
+)
+
+const rateLimitKeyPrefix = "saferoute:ratelimit:"
+
+var rateLimitRejectionsTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rate_limit_rejections_total",
+		Help: "Requests rejected by the distributed rate limiter",
+	},
+	[]string{"key_type"},
+)
+
+// tokenBucketScript refills the bucket for the time elapsed since the last
+// request and takes one token, atomically, so every replica shares it.
+// It returns {allowed, remaining, retry_after_ms, reset_ms}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+
+return {allowed, math.floor(tokens), retry, math.ceil((burst - tokens) * 1000 / rate)}
+`)
+
+// RateLimitResult is the state of a bucket after one request.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// TokenBucket is a rate limiter shared by all proxy replicas through Redis.
+// Each key gets burst tokens, refilled at rate per second.
+type TokenBucket struct {
+	client *redis.Client
+	rate   float64
+	burst  int
+	now    func() time.Time
+}
+
+func NewTokenBucket(client *redis.Client, rate float64, burst int) *TokenBucket {
+	return &TokenBucket{client: client, rate: rate, burst: burst, now: time.Now}
+}
+
+// Take removes one token from the bucket for key.
+func (b *TokenBucket) Take(ctx context.Context, key string) (RateLimitResult, error) {
+	now := b.now().UnixMilli()
+	values, err := tokenBucketScript.Run(ctx, b.client, []string{rateLimitKeyPrefix + key},
+		b.rate, b.burst, now).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      b.burst,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+// DistributedRateLimit limits /v1/ requests per tenant, API key or client
+// IP (keyBy) using bucket, and reports the bucket in X-RateLimit-* headers.
+// Health, readiness and metrics are never limited. Requests without the
+// tenant or API key to key by are let through: the per-IP limiter that runs
+// before authentication covers them. If Redis is unreachable, requests are
+// let through rather than failing the proxy.
+func DistributedRateLimit(bucket *TokenBucket, keyBy string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyType, key, ok := rateLimitKey(r, keyBy)
+			if !ok || !strings.HasPrefix(r.URL.Path, "/v1/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := bucket.Take(r.Context(), keyType+":"+key)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("rate limiter unavailable, allowing request", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				rateLimitRejectionsTotal.WithLabelValues(keyType).Inc()
+				logging.FromContext(r.Context()).Info("rate limit exceeded", "key_type", keyType)
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				respondJSONError(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func rateLimitKey(r *http.Request, keyBy string) (keyType, key string, ok bool) {
+	switch keyBy {
+	case RateLimitByTenant:
+		tenant, ok := auth.TenantFromContext(r.Context())
+		return RateLimitByTenant, tenant.ID, ok
+	case RateLimitByAPIKey:
+		key := apiKey(r)
+		return RateLimitByAPIKey, auth.HashKey(key), key != ""
+	default:
+		return RateLimitByIP, clientip.String(r), true
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
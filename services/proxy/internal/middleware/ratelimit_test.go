@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/saferoute/proxy/internal/auth"
+)
+
+func newTestBucket(t *testing.T, rate float64, burst int) (*TokenBucket, *time.Time, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	now := time.Unix(1700000000, 0)
+	bucket := NewTokenBucket(client, rate, burst)
+	bucket.now = func() time.Time { return now }
+	return bucket, &now, mr
+}
+
+func TestDistributedRateLimit(t *testing.T) {
+	bucket, now, _ := newTestBucket(t, 1, 2)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	wrapped := DistributedRateLimit(bucket, RateLimitByIP)(handler)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		wrapped.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		w := send()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i, w.Code)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != wantRemaining {
+			t.Errorf("Request %d: unexpected headers %v", i, w.Header())
+		}
+	}
+
+	w := send()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", w.Header().Get("Retry-After"))
+	}
+
+	*now = now.Add(time.Second)
+	if w := send(); w.Code != http.StatusOK {
+		t.Errorf("Expected a refilled token after a second, got %d", w.Code)
+	}
+}
+
+func TestDistributedRateLimit_KeysByTenant(t *testing.T) {
+	bucket, _, _ := newTestBucket(t, 1, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	wrapped := DistributedRateLimit(bucket, RateLimitByTenant)(handler)
+
+	send := func(tenant, ip string) int {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.RemoteAddr = ip + ":1234"
+		req = req.WithContext(auth.WithTenant(req.Context(), auth.Tenant{ID: tenant}))
+		w := httptest.NewRecorder()
+		wrapped.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if send("acme", "10.0.0.1") != http.StatusOK || send("globex", "10.0.0.1") != http.StatusOK {
+		t.Error("Expected each tenant to have its own bucket")
+	}
+	if send("acme", "10.0.0.2") != http.StatusTooManyRequests {
+		t.Error("Expected a tenant's bucket to be shared across IPs")
+	}
+}
+
+func TestDistributedRateLimit_SkipsProbesAndUnkeyedRequests(t *testing.T) {
+	bucket, _, _ := newTestBucket(t, 1, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	send := func(limiter Middleware, path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		limiter(handler).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	byIP := DistributedRateLimit(bucket, RateLimitByIP)
+	for _, path := range []string{"/health", "/ready", "/metrics", "/health"} {
+		if code := send(byIP, path); code != http.StatusOK {
+			t.Errorf("Expected %s not to be limited, got %d", path, code)
+		}
+	}
+
+	byTenant := DistributedRateLimit(bucket, RateLimitByTenant)
+	for i := 0; i < 2; i++ {
+		if code := send(byTenant, "/v1/anonymize"); code != http.StatusOK {
+			t.Errorf("Expected a request without a tenant to be left to the IP limiter, got %d", code)
+		}
+	}
+}
+
+func TestDistributedRateLimit_FailsOpen(t *testing.T) {
+	bucket, _, mr := newTestBucket(t, 1, 1)
+	mr.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/v1/anonymize", nil)
+	w := httptest.NewRecorder()
+	DistributedRateLimit(bucket, RateLimitByIP)(handler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the request through when Redis is down, got %d", w.Code)
+	}
+}
@@ -0,0 +1,298 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Extra holds the JSON fields of an object that its Go type does not model,
+// such as top_p, response_format or a provider's own extensions. They are
+// kept as raw JSON and written back unchanged, so a request or response
+// survives a decode and encode round trip through the proxy.
+type Extra map[string]json.RawMessage
+
+// unmarshalExtra decodes data into v, a pointer to a struct, and returns
+// the fields that v's type has no field for.
+func unmarshalExtra(data []byte, v any) (Extra, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	return extraFields(data, reflect.TypeOf(v).Elem())
+}
+
+func extraFields(data []byte, t reflect.Type) (Extra, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	known := knownFields(t)
+	var extra Extra
+	for name, value := range fields {
+		// encoding/json matches names case-insensitively.
+		if known[strings.ToLower(name)] {
+			continue
+		}
+		if extra == nil {
+			extra = make(Extra)
+		}
+		extra[name] = value
+	}
+	return extra, nil
+}
+
+// marshalExtra encodes v, which must encode as a JSON object, followed by
+// the fields in extra in name order.
+func marshalExtra(v any, extra Extra) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	out.Write(data[:len(data)-1])
+	for i, name := range names {
+		if i > 0 || len(data) > 2 {
+			out.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		out.Write(key)
+		out.WriteByte(':')
+		out.Write(extra[name])
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+var knownFieldsCache sync.Map // reflect.Type -> map[string]bool
+
+// knownFields returns the lower-cased JSON names of t's fields.
+func knownFields(t reflect.Type) map[string]bool {
+	if known, ok := knownFieldsCache.Load(t); ok {
+		return known.(map[string]bool)
+	}
+
+	known := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		known[strings.ToLower(name)] = true
+	}
+
+	knownFieldsCache.Store(t, known)
+	return known
+}
+
+// The methods below keep each type's Extra fields. Message and
+// EmbeddingRequest have their own in types.go.
+
+func (c *ContentPart) UnmarshalJSON(data []byte) error {
+	type plain ContentPart
+	extra, err := unmarshalExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+func (c ContentPart) MarshalJSON() ([]byte, error) {
+	type plain ContentPart
+	return marshalExtra(plain(c), c.Extra)
+}
+
+func (i *ImageURL) UnmarshalJSON(data []byte) error {
+	type plain ImageURL
+	extra, err := unmarshalExtra(data, (*plain)(i))
+	i.Extra = extra
+	return err
+}
+
+func (i ImageURL) MarshalJSON() ([]byte, error) {
+	type plain ImageURL
+	return marshalExtra(plain(i), i.Extra)
+}
+
+func (t *ToolCall) UnmarshalJSON(data []byte) error {
+	type plain ToolCall
+	extra, err := unmarshalExtra(data, (*plain)(t))
+	t.Extra = extra
+	return err
+}
+
+func (t ToolCall) MarshalJSON() ([]byte, error) {
+	type plain ToolCall
+	return marshalExtra(plain(t), t.Extra)
+}
+
+func (f *FunctionCall) UnmarshalJSON(data []byte) error {
+	type plain FunctionCall
+	extra, err := unmarshalExtra(data, (*plain)(f))
+	f.Extra = extra
+	return err
+}
+
+func (f FunctionCall) MarshalJSON() ([]byte, error) {
+	type plain FunctionCall
+	return marshalExtra(plain(f), f.Extra)
+}
+
+func (t *Tool) UnmarshalJSON(data []byte) error {
+	type plain Tool
+	extra, err := unmarshalExtra(data, (*plain)(t))
+	t.Extra = extra
+	return err
+}
+
+func (t Tool) MarshalJSON() ([]byte, error) {
+	type plain Tool
+	return marshalExtra(plain(t), t.Extra)
+}
+
+func (f *FunctionDefinition) UnmarshalJSON(data []byte) error {
+	type plain FunctionDefinition
+	extra, err := unmarshalExtra(data, (*plain)(f))
+	f.Extra = extra
+	return err
+}
+
+func (f FunctionDefinition) MarshalJSON() ([]byte, error) {
+	type plain FunctionDefinition
+	return marshalExtra(plain(f), f.Extra)
+}
+
+func (c *ChatCompletionRequest) UnmarshalJSON(data []byte) error {
+	type plain ChatCompletionRequest
+	extra, err := unmarshalExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+func (c ChatCompletionRequest) MarshalJSON() ([]byte, error) {
+	type plain ChatCompletionRequest
+	return marshalExtra(plain(c), c.Extra)
+}
+
+func (c *ChatCompletionResponse) UnmarshalJSON(data []byte) error {
+	type plain ChatCompletionResponse
+	extra, err := unmarshalExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+func (c ChatCompletionResponse) MarshalJSON() ([]byte, error) {
+	type plain ChatCompletionResponse
+	return marshalExtra(plain(c), c.Extra)
+}
+
+func (c *Choice) UnmarshalJSON(data []byte) error {
+	type plain Choice
+	extra, err := unmarshalExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+func (c Choice) MarshalJSON() ([]byte, error) {
+	type plain Choice
+	return marshalExtra(plain(c), c.Extra)
+}
+
+func (c *ChatCompletionChunk) UnmarshalJSON(data []byte) error {
+	type plain ChatCompletionChunk
+	extra, err := unmarshalExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+func (c ChatCompletionChunk) MarshalJSON() ([]byte, error) {
+	type plain ChatCompletionChunk
+	return marshalExtra(plain(c), c.Extra)
+}
+
+func (c *ChunkChoice) UnmarshalJSON(data []byte) error {
+	type plain ChunkChoice
+	extra, err := unmarshalExtra(data, (*plain)(c))
+	c.Extra = extra
+	return err
+}
+
+func (c ChunkChoice) MarshalJSON() ([]byte, error) {
+	type plain ChunkChoice
+	return marshalExtra(plain(c), c.Extra)
+}
+
+func (d *Delta) UnmarshalJSON(data []byte) error {
+	type plain Delta
+	extra, err := unmarshalExtra(data, (*plain)(d))
+	d.Extra = extra
+	return err
+}
+
+func (d Delta) MarshalJSON() ([]byte, error) {
+	type plain Delta
+	return marshalExtra(plain(d), d.Extra)
+}
+
+func (u *Usage) UnmarshalJSON(data []byte) error {
+	type plain Usage
+	extra, err := unmarshalExtra(data, (*plain)(u))
+	u.Extra = extra
+	return err
+}
+
+func (u Usage) MarshalJSON() ([]byte, error) {
+	type plain Usage
+	return marshalExtra(plain(u), u.Extra)
+}
+
+func (e *EmbeddingResponse) UnmarshalJSON(data []byte) error {
+	type plain EmbeddingResponse
+	extra, err := unmarshalExtra(data, (*plain)(e))
+	e.Extra = extra
+	return err
+}
+
+func (e EmbeddingResponse) MarshalJSON() ([]byte, error) {
+	type plain EmbeddingResponse
+	return marshalExtra(plain(e), e.Extra)
+}
+
+func (e *Embedding) UnmarshalJSON(data []byte) error {
+	type plain Embedding
+	extra, err := unmarshalExtra(data, (*plain)(e))
+	e.Extra = extra
+	return err
+}
+
+func (e Embedding) MarshalJSON() ([]byte, error) {
+	type plain Embedding
+	return marshalExtra(plain(e), e.Extra)
+}
+
+func (u *EmbeddingUsage) UnmarshalJSON(data []byte) error {
+	type plain EmbeddingUsage
+	extra, err := unmarshalExtra(data, (*plain)(u))
+	u.Extra = extra
+	return err
+}
+
+func (u EmbeddingUsage) MarshalJSON() ([]byte, error) {
+	type plain EmbeddingUsage
+	return marshalExtra(plain(u), u.Extra)
+}
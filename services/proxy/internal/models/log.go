@@ -0,0 +1,53 @@
+package models
+
+import "log/slog"
+
+// The LogValue methods below keep detected values and message text out of
+// logs: logging any of these types records only identifiers and sizes.
+
+func (e Entity) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("type", e.Type),
+		slog.String("token", e.Token),
+		slog.Int("position", e.Position),
+		slog.Int("length", len(e.Original)),
+		slog.Int("message_index", e.MessageIndex),
+		slog.Float64("confidence", e.Confidence),
+	)
+}
+
+func (m Message) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("role", m.Role),
+		slog.Int("content_length", len(m.Text())),
+		slog.Int("parts", len(m.Parts)),
+		slog.Int("tool_calls", len(m.ToolCalls)),
+	)
+}
+
+func (r ChatCompletionRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("model", r.Model),
+		slog.Int("messages", len(r.Messages)),
+		slog.Int("tools", len(r.Tools)),
+		slog.Int("max_tokens", r.MaxTokens),
+		slog.Bool("stream", r.Stream),
+	)
+}
+
+func (r ChatCompletionResponse) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", r.ID),
+		slog.String("model", r.Model),
+		slog.Int("choices", len(r.Choices)),
+		slog.Int("total_tokens", r.Usage.TotalTokens),
+	)
+}
+
+func (c ChatCompletionChunk) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", c.ID),
+		slog.String("model", c.Model),
+		slog.Int("choices", len(c.Choices)),
+	)
+}
@@ -1,15 +1,203 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Message is one conversation turn. Assistant turns may carry ToolCalls
+// instead of, or as well as, Content; "tool" turns answer the call named by
+// ToolCallID.
+//
+// On the wire "content" is a string, an array of parts or null. A string is
+// decoded into Content and an array into Parts; Parts, when set, is what
+// gets encoded.
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string        `json:"role"`
+	Content    string        `json:"content"`
+	Parts      []ContentPart `json:"-"`
+	Name       string        `json:"name,omitempty"`
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID string        `json:"tool_call_id,omitempty"`
+	Extra      Extra         `json:"-"`
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message
+	var wire struct {
+		message
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*m = Message(wire.message)
+
+	extra, err := extraFields(data, reflect.TypeOf(*m))
+	if err != nil {
+		return err
+	}
+	m.Extra = extra
+
+	switch {
+	case len(wire.Content) == 0 || string(wire.Content) == "null":
+		return nil
+	case wire.Content[0] == '[':
+		if err := json.Unmarshal(wire.Content, &m.Parts); err != nil {
+			return err
+		}
+		if m.Parts == nil {
+			m.Parts = []ContentPart{}
+		}
+		return nil
+	default:
+		return json.Unmarshal(wire.Content, &m.Content)
+	}
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	var content any = m.Content
+	switch {
+	case m.Parts != nil:
+		content = m.Parts
+	case m.Content == "" && len(m.ToolCalls) > 0:
+		content = nil
+	}
+	return marshalExtra(struct {
+		message
+		Content any `json:"content"`
+	}{message(m), content}, m.Extra)
 }
 
+// Text returns the message's text: Content, or the text of its parts
+// joined by newlines.
+func (m Message) Text() string {
+	if m.Parts == nil {
+		return m.Content
+	}
+	var texts []string
+	for _, part := range m.Parts {
+		if text, ok := part.ScannedText(); ok {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Content part types.
+const (
+	PartText       = "text"
+	PartInputText  = "input_text"
+	PartOutputText = "output_text"
+	PartRefusal    = "refusal"
+	PartImageURL   = "image_url"
+	PartInputAudio = "input_audio"
+	PartFile       = "file"
+)
+
+// IsTextPart reports whether parts of partType carry text, which is scanned
+// like string content.
+func IsTextPart(partType string) bool {
+	switch partType {
+	case PartText, PartInputText, PartOutputText, PartRefusal:
+		return true
+	default:
+		return false
+	}
+}
+
+// ContentPart is one element of an array "content". Text parts carry their
+// text in Text, refusal parts in Refusal; the other kinds are kept as sent.
+type ContentPart struct {
+	Type       string          `json:"type"`
+	Text       string          `json:"text,omitempty"`
+	Refusal    string          `json:"refusal,omitempty"`
+	ImageURL   *ImageURL       `json:"image_url,omitempty"`
+	InputAudio json.RawMessage `json:"input_audio,omitempty"`
+	File       json.RawMessage `json:"file,omitempty"`
+	Extra      Extra           `json:"-"`
+}
+
+// ScannedText returns the part's text and whether it is a text part.
+func (c ContentPart) ScannedText() (string, bool) {
+	switch {
+	case c.Type == PartRefusal:
+		return c.Refusal, true
+	case IsTextPart(c.Type):
+		return c.Text, true
+	default:
+		return "", false
+	}
+}
+
+// WithText returns a copy of the text part c with its text replaced.
+func (c ContentPart) WithText(text string) ContentPart {
+	if c.Type == PartRefusal {
+		c.Refusal = text
+	} else {
+		c.Text = text
+	}
+	return c
+}
+
+// ImageURL is an http(s) URL or a base64 "data:" URL.
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+	Extra  Extra  `json:"-"`
+}
+
+// ToolCall is a function call made by the model. Arguments holds a JSON
+// object as a string. In a streamed Delta, Index identifies the call that a
+// fragment of Arguments belongs to; only the first fragment carries the ID
+// and name.
+type ToolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+	Extra    Extra        `json:"-"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+	Extra     Extra  `json:"-"`
+}
+
+// Tool declares a function the model may call. Parameters is a JSON Schema.
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+	Extra    Extra              `json:"-"`
+}
+
+type FunctionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+	Extra       Extra           `json:"-"`
+}
+
+// ChatCompletionRequest is the OpenAI chat completions request. ToolChoice
+// is kept raw since it is either a string ("auto", "none", "required") or an
+// object naming a function; Stop likewise is a string or an array.
 type ChatCompletionRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Model             string          `json:"model"`
+	Messages          []Message       `json:"messages"`
+	Temperature       *float64        `json:"temperature,omitempty"`
+	TopP              *float64        `json:"top_p,omitempty"`
+	MaxTokens         int             `json:"max_tokens,omitempty"`
+	Stop              json.RawMessage `json:"stop,omitempty"`
+	Stream            bool            `json:"stream,omitempty"`
+	Tools             []Tool          `json:"tools,omitempty"`
+	ToolChoice        json.RawMessage `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+	Extra             Extra           `json:"-"`
 }
 
 type ChatCompletionResponse struct {
@@ -19,26 +207,122 @@ type ChatCompletionResponse struct {
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
 	Usage   Usage    `json:"usage"`
+	Extra   Extra    `json:"-"`
 }
 
 type Choice struct {
 	Index        int     `json:"index"`
 	Message      Message `json:"message"`
 	FinishReason string  `json:"finish_reason"`
+	Extra        Extra   `json:"-"`
+}
+
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+	Extra   Extra         `json:"-"`
+}
+
+type ChunkChoice struct {
+	Index        int     `json:"index"`
+	Delta        Delta   `json:"delta"`
+	FinishReason *string `json:"finish_reason"`
+	Extra        Extra   `json:"-"`
+}
+
+type Delta struct {
+	Role      string     `json:"role,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Extra     Extra      `json:"-"`
 }
 
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens     int   `json:"prompt_tokens"`
+	CompletionTokens int   `json:"completion_tokens"`
+	TotalTokens      int   `json:"total_tokens"`
+	Extra            Extra `json:"-"`
+}
+
+// EmbeddingRequest is the OpenAI embeddings request. On the wire "input"
+// is a string or an array of strings; it is always sent upstream as an
+// array. Arrays of token IDs are rejected, since they cannot be scanned.
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+	Extra Extra    `json:"-"`
+}
+
+func (e *EmbeddingRequest) UnmarshalJSON(data []byte) error {
+	type plain EmbeddingRequest
+	var wire struct {
+		plain
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*e = EmbeddingRequest(wire.plain)
+
+	extra, err := extraFields(data, reflect.TypeOf(*e))
+	if err != nil {
+		return err
+	}
+	e.Extra = extra
+
+	var input string
+	if err := json.Unmarshal(wire.Input, &input); err == nil {
+		e.Input = []string{input}
+		return nil
+	}
+	if err := json.Unmarshal(wire.Input, &e.Input); err != nil {
+		return errors.New("embedding input must be a string or an array of strings")
+	}
+	return nil
+}
+
+func (e EmbeddingRequest) MarshalJSON() ([]byte, error) {
+	type plain EmbeddingRequest
+	return marshalExtra(plain(e), e.Extra)
 }
 
+// EmbeddingResponse is the OpenAI embeddings response. Each vector is kept
+// as raw JSON, so it reaches the client exactly as the provider sent it,
+// whether as floats or base64.
+type EmbeddingResponse struct {
+	Object string         `json:"object"`
+	Data   []Embedding    `json:"data"`
+	Model  string         `json:"model"`
+	Usage  EmbeddingUsage `json:"usage"`
+	Extra  Extra          `json:"-"`
+}
+
+type Embedding struct {
+	Object    string          `json:"object"`
+	Index     int             `json:"index"`
+	Embedding json.RawMessage `json:"embedding"`
+	Extra     Extra           `json:"-"`
+}
+
+type EmbeddingUsage struct {
+	PromptTokens int   `json:"prompt_tokens"`
+	TotalTokens  int   `json:"total_tokens"`
+	Extra        Extra `json:"-"`
+}
+
+// Entity is a detected value and its vault token. Position is the offset of
+// Original within the message identified by MessageIndex.
 type Entity struct {
-	Original   string  `json:"original"`
-	Token      string  `json:"token"`
-	Type       string  `json:"type"`
-	Position   int     `json:"position"`
-	Confidence float64 `json:"confidence"`
+	Original     string  `json:"original"`
+	Token        string  `json:"token"`
+	Type         string  `json:"type"`
+	Position     int     `json:"position"`
+	Confidence   float64 `json:"confidence"`
+	MessageIndex int     `json:"message_index,omitempty"`
 }
 
 type NERRequest struct {
@@ -52,6 +336,21 @@ type NERResponse struct {
 	Domain   string   `json:"domain"`
 }
 
+type NERBatchRequest struct {
+	Texts  []string `json:"texts"`
+	Domain string   `json:"domain,omitempty"`
+}
+
+type NERBatchResponse struct {
+	Results []NERBatchResult `json:"results"`
+	Domain  string           `json:"domain"`
+}
+
+type NERBatchResult struct {
+	Entities []Entity `json:"entities"`
+	Count    int      `json:"count"`
+}
+
 type VaultStoreRequest struct {
 	RequestID string   `json:"request_id"`
 	Entities  []Entity `json:"entities"`
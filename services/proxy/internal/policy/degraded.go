@@ -0,0 +1,69 @@
+package policy
+
+import "fmt"
+
+// DegradedMode is what happens to a request when entity detection fails.
+type DegradedMode string
+
+const (
+	// DegradeBlock rejects the request, as when no degradation is set.
+	DegradeBlock DegradedMode = "block"
+	// DegradeRegexOnly scrubs the request with the local regex detector
+	// alone.
+	DegradeRegexOnly DegradedMode = "regex_only"
+	// DegradePassThrough forwards the request unscrubbed, flagged with a
+	// warning header.
+	DegradePassThrough DegradedMode = "pass_through"
+)
+
+// Degradation picks the DegradedMode for a request. A tenant's mode wins
+// over its route's, which wins over Default.
+type Degradation struct {
+	Default DegradedMode            `json:"default" yaml:"default"`
+	Routes  map[string]DegradedMode `json:"routes" yaml:"routes"`
+	Tenants map[string]DegradedMode `json:"tenants" yaml:"tenants"`
+}
+
+// DegradedMode returns the mode for a request to route from tenant, which
+// may be empty.
+func (p *Policy) DegradedMode(route, tenant string) DegradedMode {
+	d := p.Degraded
+	if mode, ok := d.Tenants[tenant]; ok && tenant != "" {
+		return mode
+	}
+	if mode, ok := d.Routes[route]; ok {
+		return mode
+	}
+	if d.Default != "" {
+		return d.Default
+	}
+	return DegradeBlock
+}
+
+func (d Degradation) validate() error {
+	if d.Default != "" {
+		if err := d.Default.validate(); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	for route, mode := range d.Routes {
+		if err := mode.validate(); err != nil {
+			return fmt.Errorf("route %s: %w", route, err)
+		}
+	}
+	for tenant, mode := range d.Tenants {
+		if err := mode.validate(); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+func (m DegradedMode) validate() error {
+	switch m {
+	case DegradeBlock, DegradeRegexOnly, DegradePassThrough:
+		return nil
+	default:
+		return fmt.Errorf("unknown degraded mode %q", m)
+	}
+}
@@ -0,0 +1,49 @@
+package policy
+
+import "testing"
+
+func TestDegradedMode(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", `
+degraded:
+  default: regex_only
+  routes:
+    /v1/anonymize: block
+  tenants:
+    hospital: block
+    internal-tools: pass_through
+`)
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		route, tenant string
+		want          DegradedMode
+	}{
+		{"/v1/chat/completions", "", DegradeRegexOnly},
+		{"/v1/anonymize", "", DegradeBlock},
+		{"/v1/chat/completions", "hospital", DegradeBlock},
+		{"/v1/anonymize", "internal-tools", DegradePassThrough},
+	}
+	for _, tt := range tests {
+		if got := p.DegradedMode(tt.route, tt.tenant); got != tt.want {
+			t.Errorf("DegradedMode(%q, %q): expected %s, got %s", tt.route, tt.tenant, tt.want, got)
+		}
+	}
+}
+
+func TestDegradedMode_DefaultsToBlock(t *testing.T) {
+	if got := Default().DegradedMode("/v1/chat/completions", "acme"); got != DegradeBlock {
+		t.Errorf("Expected block without a degraded section, got %s", got)
+	}
+}
+
+func TestLoad_RejectsUnknownDegradedMode(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", "degraded:\n  tenants:\n    acme: fail_open\n")
+
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an error for an unknown degraded mode")
+	}
+}
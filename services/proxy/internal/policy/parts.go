@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+// PartAction is what happens to a content part that carries no text (see
+// models.IsTextPart). Such parts are never scanned for entities.
+type PartAction string
+
+const (
+	// PartForward sends the part upstream unchanged.
+	PartForward PartAction = "forward"
+	// PartStrip drops the part from the request.
+	PartStrip PartAction = "strip"
+	// PartReject rejects the whole request.
+	PartReject PartAction = "reject"
+)
+
+// ContentParts picks the action for each non-text part type ("image_url",
+// "input_audio", "file", ...). Types without an entry use Default. Unless
+// it is set, images and audio are forwarded and every other type, files
+// included, is rejected: a file's name and document may hold PII, and an
+// unknown type may hold text under a field the proxy does not scan.
+type ContentParts struct {
+	Default PartAction            `json:"default" yaml:"default"`
+	Types   map[string]PartAction `json:"types" yaml:"types"`
+}
+
+// PartsRejectedError reports the content part types that caused a request
+// to be rejected.
+type PartsRejectedError struct {
+	Types []string
+}
+
+func (e *PartsRejectedError) Error() string {
+	return "request contains rejected content parts: " + strings.Join(e.Types, ", ")
+}
+
+// PartAction returns the action for a part of partType.
+func (p *Policy) PartAction(partType string) PartAction {
+	if action, ok := p.ContentParts.Types[partType]; ok {
+		return action
+	}
+	if p.ContentParts.Default != "" {
+		return p.ContentParts.Default
+	}
+	switch partType {
+	case models.PartImageURL, models.PartInputAudio:
+		return PartForward
+	default:
+		return PartReject
+	}
+}
+
+// FilterParts applies the content part actions to messages, returning a
+// copy without stripped parts. It returns a *PartsRejectedError if any part
+// type is rejected.
+func (p *Policy) FilterParts(messages []models.Message) ([]models.Message, error) {
+	filtered := make([]models.Message, len(messages))
+	rejected := make(map[string]bool)
+
+	for i, msg := range messages {
+		if msg.Parts != nil {
+			parts := make([]models.ContentPart, 0, len(msg.Parts))
+			for _, part := range msg.Parts {
+				if models.IsTextPart(part.Type) {
+					parts = append(parts, part)
+					continue
+				}
+				switch p.PartAction(part.Type) {
+				case PartForward:
+					parts = append(parts, part)
+				case PartReject:
+					rejected[part.Type] = true
+				}
+			}
+			if len(parts) == 0 {
+				// Nothing left; send an empty string rather than an empty
+				// array, which providers reject.
+				parts = nil
+			}
+			msg.Parts = parts
+		}
+		filtered[i] = msg
+	}
+
+	if len(rejected) > 0 {
+		types := make([]string, 0, len(rejected))
+		for partType := range rejected {
+			types = append(types, partType)
+		}
+		sort.Strings(types)
+		return nil, &PartsRejectedError{Types: types}
+	}
+	return filtered, nil
+}
+
+func (c ContentParts) validate() error {
+	if c.Default != "" {
+		if err := c.Default.validate(); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	for partType, action := range c.Types {
+		if models.IsTextPart(partType) {
+			return fmt.Errorf("%s parts are always scanned", partType)
+		}
+		if err := action.validate(); err != nil {
+			return fmt.Errorf("type %s: %w", partType, err)
+		}
+	}
+	return nil
+}
+
+func (a PartAction) validate() error {
+	switch a {
+	case PartForward, PartStrip, PartReject:
+		return nil
+	default:
+		return fmt.Errorf("unknown content part action %q", a)
+	}
+}
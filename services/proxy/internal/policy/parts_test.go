@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+func imageMessage() models.Message {
+	return models.Message{Role: "user", Parts: []models.ContentPart{
+		{Type: models.PartText, Text: "What is this?"},
+		{Type: models.PartImageURL, ImageURL: &models.ImageURL{URL: "https://example.com/a.png"}},
+	}}
+}
+
+func TestFilterParts(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", `
+content_parts:
+  default: strip
+  types:
+    input_audio: reject
+`)
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	messages := []models.Message{{Role: "system", Content: "Be brief."}, imageMessage()}
+	filtered, err := p.FilterParts(messages)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(filtered[0], messages[0]) {
+		t.Errorf("Expected string content to be untouched, got %+v", filtered[0])
+	}
+	if parts := filtered[1].Parts; len(parts) != 1 || parts[0].Type != models.PartText {
+		t.Errorf("Expected only the text part to remain, got %+v", parts)
+	}
+	if len(messages[1].Parts) != 2 {
+		t.Error("Expected the original messages not to be modified")
+	}
+
+	audio := models.Message{Role: "user", Parts: []models.ContentPart{{Type: models.PartInputAudio}}}
+	_, err = p.FilterParts([]models.Message{audio})
+	var rejected *PartsRejectedError
+	if !errors.As(err, &rejected) || !reflect.DeepEqual(rejected.Types, []string{models.PartInputAudio}) {
+		t.Errorf("Expected input_audio to be rejected, got %v", err)
+	}
+}
+
+func TestFilterParts_StripsToEmptyContent(t *testing.T) {
+	p := &Policy{ContentParts: ContentParts{Default: PartStrip}}
+
+	only := models.Message{Role: "user", Parts: []models.ContentPart{{Type: models.PartImageURL}}}
+	filtered, err := p.FilterParts([]models.Message{only})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if filtered[0].Parts != nil {
+		t.Errorf("Expected no parts left, got %+v", filtered[0].Parts)
+	}
+}
+
+func TestFilterParts_ForwardsByDefault(t *testing.T) {
+	filtered, err := Default().FilterParts([]models.Message{imageMessage()})
+	if err != nil || len(filtered[0].Parts) != 2 {
+		t.Errorf("Expected parts to be forwarded, got %+v, %v", filtered, err)
+	}
+}
+
+func TestFilterParts_RejectsFilesAndUnknownTypesByDefault(t *testing.T) {
+	msg := models.Message{Role: "user", Parts: []models.ContentPart{
+		{Type: models.PartInputText, Text: "Summarize this"},
+		{Type: models.PartFile},
+		{Type: "input_file"},
+	}}
+
+	_, err := Default().FilterParts([]models.Message{msg})
+	var rejected *PartsRejectedError
+	if !errors.As(err, &rejected) || !reflect.DeepEqual(rejected.Types, []string{models.PartFile, "input_file"}) {
+		t.Errorf("Expected file and input_file to be rejected, got %v", err)
+	}
+}
+
+func TestLoad_RejectsActionForTextParts(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", "content_parts:\n  types:\n    refusal: forward\n")
+
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an error for an action on a text part type")
+	}
+}
+
+func TestLoad_RejectsUnknownPartAction(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", "content_parts:\n  types:\n    image_url: blur\n")
+
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an error for an unknown content part action")
+	}
+}
@@ -0,0 +1,249 @@
+// Package policy decides per entity type whether a detected value is
+// tokenized, redacted, masked, allowed through or blocks the request.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/saferoute/proxy/internal/anonymizer"
+	"github.com/saferoute/proxy/internal/models"
+	"gopkg.in/yaml.v2"
+)
+
+type Action string
+
+const (
+	// ActionTokenize replaces the value with a vault token that is restored
+	// in the response.
+	ActionTokenize Action = "tokenize"
+	// ActionRedact replaces the value irreversibly with "[REDACTED_TYPE]".
+	ActionRedact Action = "redact"
+	// ActionMask hides all but the last Keep letters and digits.
+	ActionMask Action = "mask"
+	// ActionBlock rejects the whole request.
+	ActionBlock Action = "block"
+	// ActionAllow forwards the value unchanged.
+	ActionAllow Action = "allow"
+)
+
+const defaultMaskKeep = 4
+
+// Rule is the action for one entity type. In a policy file it is written
+// either as the bare action or as an object with "action" and "keep".
+type Rule struct {
+	Action Action `json:"action" yaml:"action"`
+	Keep   int    `json:"keep,omitempty" yaml:"keep,omitempty"`
+}
+
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var action string
+	if err := json.Unmarshal(data, &action); err == nil {
+		*r = Rule{Action: Action(action)}
+		return nil
+	}
+
+	type rule Rule
+	return json.Unmarshal(data, (*rule)(r))
+}
+
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var action string
+	if err := unmarshal(&action); err == nil {
+		*r = Rule{Action: Action(action)}
+		return nil
+	}
+
+	type rule Rule
+	return unmarshal((*rule)(r))
+}
+
+// Policy maps entity types to rules. Types without a rule use Default.
+// Degraded decides what happens when entities cannot be detected at all,
+// and ContentParts what happens to content parts other than text.
+type Policy struct {
+	Default      Rule            `json:"default" yaml:"default"`
+	Entities     map[string]Rule `json:"entities" yaml:"entities"`
+	Degraded     Degradation     `json:"degraded" yaml:"degraded"`
+	ContentParts ContentParts    `json:"content_parts" yaml:"content_parts"`
+}
+
+// Default tokenizes every entity, which is the proxy's behavior without a
+// policy file.
+func Default() *Policy {
+	return &Policy{Default: Rule{Action: ActionTokenize}}
+}
+
+// Load reads a policy from a YAML file, or JSON when the file ends in
+// ".json".
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	p := Default()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, p)
+	} else {
+		err = yaml.Unmarshal(data, p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Policy) validate() error {
+	if p.Default.Action == "" {
+		p.Default.Action = ActionTokenize
+	}
+	if err := p.Default.validate(); err != nil {
+		return fmt.Errorf("default rule: %w", err)
+	}
+	for entityType, rule := range p.Entities {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule for %s: %w", entityType, err)
+		}
+	}
+	if err := p.Degraded.validate(); err != nil {
+		return fmt.Errorf("degraded: %w", err)
+	}
+	if err := p.ContentParts.validate(); err != nil {
+		return fmt.Errorf("content_parts: %w", err)
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	switch r.Action {
+	case ActionTokenize, ActionRedact, ActionMask, ActionBlock, ActionAllow:
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	if r.Keep < 0 {
+		return fmt.Errorf("keep must not be negative")
+	}
+	return nil
+}
+
+// Rule returns the rule for entityType.
+func (p *Policy) Rule(entityType string) Rule {
+	if rule, ok := p.Entities[entityType]; ok {
+		return rule
+	}
+	return p.Default
+}
+
+// BlockedError reports the entity types that caused a request to be
+// rejected.
+type BlockedError struct {
+	Types []string
+}
+
+func (e *BlockedError) Error() string {
+	return "request contains blocked entity types: " + strings.Join(e.Types, ", ")
+}
+
+// Decision is the outcome of applying a policy to detected entities.
+type Decision struct {
+	// Vault holds the tokenized entities, with final tokens, to store for
+	// restoring the response.
+	Vault []models.Entity
+	// Replace holds every entity to scrub from the request, with Token set
+	// to its replacement text.
+	Replace []models.Entity
+}
+
+// Apply sorts entities by their rule. It returns a *BlockedError if any
+// entity's type is blocked.
+func (p *Policy) Apply(entities []models.Entity) (Decision, error) {
+	var tokenize, irreversible []models.Entity
+	blocked := make(map[string]bool)
+
+	for _, entity := range entities {
+		rule := p.Rule(entity.Type)
+		switch rule.Action {
+		case ActionTokenize:
+			tokenize = append(tokenize, entity)
+		case ActionRedact:
+			entity.Token = redaction(entity.Type)
+			irreversible = append(irreversible, entity)
+		case ActionMask:
+			keep := rule.Keep
+			if keep == 0 {
+				keep = defaultMaskKeep
+			}
+			entity.Token = mask(entity.Original, keep)
+			irreversible = append(irreversible, entity)
+		case ActionBlock:
+			blocked[entity.Type] = true
+		}
+	}
+
+	if len(blocked) > 0 {
+		types := make([]string, 0, len(blocked))
+		for entityType := range blocked {
+			types = append(types, entityType)
+		}
+		sort.Strings(types)
+		return Decision{}, &BlockedError{Types: types}
+	}
+
+	vault := anonymizer.AssignTokens(tokenize)
+	return Decision{
+		Vault:   vault,
+		Replace: append(append([]models.Entity(nil), vault...), irreversible...),
+	}, nil
+}
+
+// Irreversible returns the decision for a request whose response is never
+// restored, such as an embeddings request: tokenized entities get token's
+// replacement instead, or are redacted when token is nil, and nothing is
+// left to store in the vault.
+func (d Decision) Irreversible(token func(models.Entity) string) Decision {
+	replace := make([]models.Entity, len(d.Replace))
+	copy(replace, d.Replace)
+	for i := range d.Vault {
+		if token != nil {
+			replace[i].Token = token(replace[i])
+		} else {
+			replace[i].Token = redaction(replace[i].Type)
+		}
+	}
+	return Decision{Replace: replace}
+}
+
+func redaction(entityType string) string {
+	if entityType == "" {
+		entityType = "ENTITY"
+	}
+	return "[REDACTED_" + entityType + "]"
+}
+
+// mask replaces every letter and digit except the last keep with '*',
+// leaving separators in place: "4111 1111 1111 1234" becomes
+// "**** **** **** 1234".
+func mask(value string, keep int) string {
+	runes := []rune(value)
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		runes[i] = '*'
+	}
+	return string(runes)
+}
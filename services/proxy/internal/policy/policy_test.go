@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/anonymizer"
+	"github.com/saferoute/proxy/internal/models"
+)
+
+func writePolicy(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_YAML(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", `
+default: tokenize
+entities:
+  SSN: redact
+  CREDIT_CARD:
+    action: mask
+    keep: 4
+  LOCATION: allow
+`)
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if p.Rule("SSN").Action != ActionRedact {
+		t.Errorf("Expected SSN to be redacted, got %+v", p.Rule("SSN"))
+	}
+	if rule := p.Rule("CREDIT_CARD"); rule.Action != ActionMask || rule.Keep != 4 {
+		t.Errorf("Expected card to be masked keeping 4, got %+v", rule)
+	}
+	if p.Rule("PERSON").Action != ActionTokenize {
+		t.Errorf("Expected default rule for PERSON, got %+v", p.Rule("PERSON"))
+	}
+}
+
+func TestLoad_JSON(t *testing.T) {
+	path := writePolicy(t, "policy.json", `{"entities": {"API_KEY": "block", "EMAIL": {"action": "redact"}}}`)
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.Rule("API_KEY").Action != ActionBlock || p.Rule("EMAIL").Action != ActionRedact {
+		t.Errorf("Unexpected rules %+v", p.Entities)
+	}
+	if p.Default.Action != ActionTokenize {
+		t.Errorf("Expected tokenize as the default, got %s", p.Default.Action)
+	}
+}
+
+func TestLoad_RejectsUnknownAction(t *testing.T) {
+	path := writePolicy(t, "policy.yaml", "entities:\n  SSN: shred\n")
+
+	if _, err := Load(path); err == nil {
+		t.Error("Expected an error for an unknown action")
+	}
+}
+
+func TestApply(t *testing.T) {
+	p := &Policy{
+		Default: Rule{Action: ActionTokenize},
+		Entities: map[string]Rule{
+			"SSN":         {Action: ActionRedact},
+			"CREDIT_CARD": {Action: ActionMask},
+			"LOCATION":    {Action: ActionAllow},
+		},
+	}
+
+	text := "Ann, 123-45-6789, 4111 1111 1111 1111, Paris"
+	entities := []models.Entity{
+		{Original: "Ann", Token: "[PERSON_001]", Type: "PERSON", Position: 0},
+		{Original: "123-45-6789", Token: "[SSN_001]", Type: "SSN", Position: 5},
+		{Original: "4111 1111 1111 1111", Token: "[CREDIT_CARD_001]", Type: "CREDIT_CARD", Position: 18},
+		{Original: "Paris", Token: "[LOCATION_001]", Type: "LOCATION", Position: 39},
+	}
+
+	decision, err := p.Apply(entities)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(decision.Vault) != 1 || decision.Vault[0].Original != "Ann" {
+		t.Errorf("Expected only the tokenized entity in the vault, got %+v", decision.Vault)
+	}
+
+	got := anonymizer.Anonymize(text, decision.Replace)
+	want := "[PERSON_001], [REDACTED_SSN], **** **** **** 1111, Paris"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestDecision_Irreversible(t *testing.T) {
+	p := &Policy{
+		Default:  Rule{Action: ActionTokenize},
+		Entities: map[string]Rule{"CREDIT_CARD": {Action: ActionMask}},
+	}
+
+	decision, err := p.Apply([]models.Entity{
+		{Original: "Ann", Token: "[PERSON_001]", Type: "PERSON", Position: 0},
+		{Original: "4111 1111 1111 1111", Type: "CREDIT_CARD", Position: 5},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	text := "Ann, 4111 1111 1111 1111"
+	redacted := decision.Irreversible(nil)
+	if len(redacted.Vault) != 0 {
+		t.Errorf("Expected nothing to store in the vault, got %+v", redacted.Vault)
+	}
+	if got := anonymizer.Anonymize(text, redacted.Replace); got != "[REDACTED_PERSON], **** **** **** 1111" {
+		t.Errorf("Unexpected redaction %q", got)
+	}
+
+	hashed := decision.Irreversible(func(e models.Entity) string { return "<" + e.Original + ">" })
+	if got := anonymizer.Anonymize(text, hashed.Replace); got != "<Ann>, **** **** **** 1111" {
+		t.Errorf("Unexpected replacement %q", got)
+	}
+	if decision.Replace[0].Token != "[PERSON_001]" {
+		t.Error("Expected the original decision to be left unchanged")
+	}
+}
+
+func TestApply_Block(t *testing.T) {
+	p := &Policy{
+		Default:  Rule{Action: ActionTokenize},
+		Entities: map[string]Rule{"API_KEY": {Action: ActionBlock}, "SSN": {Action: ActionBlock}},
+	}
+
+	_, err := p.Apply([]models.Entity{
+		{Original: "sk-abc", Type: "API_KEY"},
+		{Original: "123-45-6789", Type: "SSN"},
+		{Original: "sk-def", Type: "API_KEY"},
+	})
+
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("Expected a BlockedError, got %v", err)
+	}
+	if len(blocked.Types) != 2 || blocked.Types[0] != "API_KEY" || blocked.Types[1] != "SSN" {
+		t.Errorf("Expected sorted unique types, got %v", blocked.Types)
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		value string
+		keep  int
+		want  string
+	}{
+		{"4111-1111-1111-1234", 4, "****-****-****-1234"},
+		{"jose@example.com", 3, "****@*******.com"},
+		{"abc", 5, "abc"},
+		{"Ñandú", 1, "****ú"},
+	}
+
+	for _, tt := range tests {
+		if got := mask(tt.value, tt.keep); got != tt.want {
+			t.Errorf("mask(%q, %d) = %q, want %q", tt.value, tt.keep, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package quota enforces per-tenant daily and monthly LLM token budgets in
+// Redis. A request reserves an estimate before it is forwarded, and the
+// reservation is reconciled with the provider's reported usage afterwards.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/saferoute/proxy/internal/models"
+)
+
+const (
+	keyPrefix = "saferoute:quota:"
+
+	// LimitsKeyPrefix is prepended to a tenant ID to form the Redis hash
+	// whose "daily" and "monthly" fields override the default limits.
+	LimitsKeyPrefix = keyPrefix + "limits:"
+
+	// charsPerToken is a rough average for English text across tokenizers.
+	charsPerToken = 4
+	// messageOverheadTokens covers role markers and separators per message.
+	messageOverheadTokens = 4
+)
+
+// Limits are token budgets per UTC day and month. Zero means unlimited.
+type Limits struct {
+	Daily   int64
+	Monthly int64
+}
+
+// ExceededError reports the budget a request would have overrun.
+type ExceededError struct {
+	Period    string    `json:"period"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Requested int64     `json:"requested"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s token quota exceeded: %d of %d used, %d requested", e.Period, e.Used, e.Limit, e.Requested)
+}
+
+// Reservation is an estimate charged against a tenant's budgets. It keeps
+// the counters it was charged to, so reconciling after midnight still
+// corrects the right day.
+type Reservation struct {
+	Tokens int64
+	keys   []string
+}
+
+// reserveScript charges ARGV[1] tokens to the day and month counters unless
+// either would go over its limit. Per-tenant limits in KEYS[3] take
+// precedence over the defaults in ARGV[2] and ARGV[3]. It returns
+// {exceeded_period_index, used, limit}, with index 0 on success.
+var reserveScript = redis.NewScript(`
+local tokens = tonumber(ARGV[1])
+local overrides = redis.call("HMGET", KEYS[3], "daily", "monthly")
+local limits = {tonumber(overrides[1]) or tonumber(ARGV[2]), tonumber(overrides[2]) or tonumber(ARGV[3])}
+
+for i = 1, 2 do
+	local used = tonumber(redis.call("GET", KEYS[i])) or 0
+	if limits[i] > 0 and used + tokens > limits[i] then
+		return {i, used, limits[i]}
+	end
+end
+
+for i = 1, 2 do
+	redis.call("INCRBY", KEYS[i], tokens)
+	redis.call("EXPIRE", KEYS[i], tonumber(ARGV[3 + i]))
+end
+return {0, 0, 0}
+`)
+
+// Manager tracks token usage for all tenants.
+type Manager struct {
+	client *redis.Client
+	limits Limits
+	now    func() time.Time
+}
+
+func NewManager(client *redis.Client, limits Limits) *Manager {
+	return &Manager{client: client, limits: limits, now: time.Now}
+}
+
+// Reserve charges tokens to the tenant's budgets. It returns an
+// *ExceededError if that would overrun a daily or monthly limit.
+func (m *Manager) Reserve(ctx context.Context, tenantID string, tokens int64) (Reservation, error) {
+	now := m.now().UTC()
+	keys := []string{
+		keyPrefix + tenantID + ":day:" + now.Format("2006-01-02"),
+		keyPrefix + tenantID + ":month:" + now.Format("2006-01"),
+	}
+
+	values, err := reserveScript.Run(ctx, m.client, append(keys, LimitsKeyPrefix+tenantID),
+		tokens, m.limits.Daily, m.limits.Monthly,
+		int((48 * time.Hour).Seconds()), int((32 * 24 * time.Hour).Seconds()),
+	).Int64Slice()
+	if err != nil {
+		return Reservation{}, fmt.Errorf("failed to reserve tokens: %w", err)
+	}
+
+	switch values[0] {
+	case 1:
+		return Reservation{}, &ExceededError{Period: "daily", Used: values[1], Limit: values[2], Requested: tokens, ResetsAt: nextDay(now)}
+	case 2:
+		return Reservation{}, &ExceededError{Period: "monthly", Used: values[1], Limit: values[2], Requested: tokens, ResetsAt: nextMonth(now)}
+	}
+	return Reservation{Tokens: tokens, keys: keys}, nil
+}
+
+// Reconcile replaces the reserved estimate with the tokens actually used.
+// Passing zero releases the reservation entirely.
+func (m *Manager) Reconcile(ctx context.Context, res Reservation, used int64) error {
+	delta := used - res.Tokens
+	if delta == 0 || len(res.keys) == 0 {
+		return nil
+	}
+
+	pipe := m.client.TxPipeline()
+	for _, key := range res.keys {
+		pipe.IncrBy(ctx, key, delta)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to reconcile tokens: %w", err)
+	}
+	return nil
+}
+
+// Estimate guesses the tokens a request will use: its prompt, tool calls
+// and tool definitions at about four characters per token, plus max_tokens
+// or defaultCompletion when unset.
+func Estimate(req models.ChatCompletionRequest, defaultCompletion int) int64 {
+	var tokens int64
+	for _, msg := range req.Messages {
+		chars := len(msg.Text())
+		for _, call := range msg.ToolCalls {
+			chars += len(call.Function.Name) + len(call.Function.Arguments)
+		}
+		tokens += int64((chars+charsPerToken-1)/charsPerToken) + messageOverheadTokens
+	}
+	for _, tool := range req.Tools {
+		chars := len(tool.Function.Name) + len(tool.Function.Description) + len(tool.Function.Parameters)
+		tokens += int64((chars + charsPerToken - 1) / charsPerToken)
+	}
+
+	if req.MaxTokens > 0 {
+		return tokens + int64(req.MaxTokens)
+	}
+	return tokens + int64(defaultCompletion)
+}
+
+// EstimateEmbeddings guesses the tokens an embeddings request will use: its
+// inputs at about four characters per token. Embeddings have no
+// completion.
+func EstimateEmbeddings(inputs []string) int64 {
+	var tokens int64
+	for _, input := range inputs {
+		tokens += int64((len(input) + charsPerToken - 1) / charsPerToken)
+	}
+	return tokens
+}
+
+// Used returns the total tokens in usage.
+func Used(usage models.Usage) int64 {
+	if usage.TotalTokens > 0 {
+		return int64(usage.TotalTokens)
+	}
+	return int64(usage.PromptTokens + usage.CompletionTokens)
+}
+
+func nextDay(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,117 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/saferoute/proxy/internal/models"
+)
+
+func newTestManager(t *testing.T, limits Limits) (*Manager, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	m := NewManager(client, limits)
+	m.now = func() time.Time { return time.Date(2024, 3, 31, 22, 0, 0, 0, time.UTC) }
+	return m, mr
+}
+
+func TestReserve_DailyLimit(t *testing.T) {
+	m, _ := newTestManager(t, Limits{Daily: 1000, Monthly: 10000})
+	ctx := context.Background()
+
+	if _, err := m.Reserve(ctx, "acme", 600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err := m.Reserve(ctx, "acme", 500)
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("Expected ExceededError, got %v", err)
+	}
+	if exceeded.Period != "daily" || exceeded.Used != 600 || exceeded.Limit != 1000 {
+		t.Errorf("Unexpected error details %+v", exceeded)
+	}
+	if !exceeded.ResetsAt.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected reset at next midnight, got %v", exceeded.ResetsAt)
+	}
+
+	if _, err := m.Reserve(ctx, "globex", 500); err != nil {
+		t.Errorf("Expected tenants to have separate budgets, got %v", err)
+	}
+}
+
+func TestReserve_TenantOverride(t *testing.T) {
+	m, mr := newTestManager(t, Limits{Daily: 1000})
+	mr.HSet(LimitsKeyPrefix+"acme", "monthly", "100")
+
+	_, err := m.Reserve(context.Background(), "acme", 200)
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Period != "monthly" || exceeded.Limit != 100 {
+		t.Fatalf("Expected the monthly override to apply, got %v", err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	m, mr := newTestManager(t, Limits{Daily: 1000})
+	ctx := context.Background()
+
+	res, err := m.Reserve(ctx, "acme", 800)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := m.Reconcile(ctx, res, 150); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got, _ := mr.Get("saferoute:quota:acme:day:2024-03-31"); got != "150" {
+		t.Errorf("Expected 150 tokens used today, got %s", got)
+	}
+	if got, _ := mr.Get("saferoute:quota:acme:month:2024-03"); got != "150" {
+		t.Errorf("Expected 150 tokens used this month, got %s", got)
+	}
+
+	if _, err := m.Reserve(ctx, "acme", 800); err != nil {
+		t.Errorf("Expected reconciled tokens to free the budget, got %v", err)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	req := models.ChatCompletionRequest{
+		Messages: []models.Message{{Content: "12345678"}, {Content: "123"}},
+	}
+
+	if got := Estimate(req, 100); got != 2+4+1+4+100 {
+		t.Errorf("Unexpected estimate %d", got)
+	}
+
+	req.MaxTokens = 50
+	if got := Estimate(req, 100); got != 2+4+1+4+50 {
+		t.Errorf("Expected max_tokens to replace the default, got %d", got)
+	}
+}
+
+func TestEstimate_ToolCalls(t *testing.T) {
+	req := models.ChatCompletionRequest{
+		Messages: []models.Message{{
+			Role:      "assistant",
+			ToolCalls: []models.ToolCall{{Function: models.FunctionCall{Name: "f", Arguments: "{\"a\":1}"}}},
+		}},
+		Tools: []models.Tool{{Function: models.FunctionDefinition{Name: "f", Parameters: []byte("{}")}}},
+	}
+
+	if got := Estimate(req, 100); got != 2+4+1+100 {
+		t.Errorf("Expected tool calls and definitions to count, got %d", got)
+	}
+}
+
+func TestEstimateEmbeddings(t *testing.T) {
+	if got := EstimateEmbeddings([]string{"12345678", "123"}); got != 2+1 {
+		t.Errorf("Unexpected estimate %d", got)
+	}
+}
@@ -0,0 +1,58 @@
+// Package requestid carries the request ID through contexts. The ID
+// correlates logs and responses and is echoed to clients in X-Request-ID,
+// so one supplied by the client is accepted only if it is safe to put in a
+// log line. It never keys vault entries, which get server-minted IDs.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header that carries the request ID both ways.
+const Header = "X-Request-ID"
+
+// MaxLength bounds client-supplied IDs.
+const MaxLength = 128
+
+type contextKey struct{}
+
+// With returns a copy of ctx carrying id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the ID set by With.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// New returns a fresh UUIDv7, whose time ordering keeps IDs minted close
+// together near each other in logs and indexes.
+func New() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+// Valid reports whether a client-supplied ID can be used as is: 1 to
+// MaxLength characters from letters, digits, '-', '_', '.' and ':'.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestWithAndFromContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("Expected no request ID in an empty context")
+	}
+
+	id, ok := FromContext(With(context.Background(), "req-1"))
+	if !ok || id != "req-1" {
+		t.Errorf("Expected req-1, got %q", id)
+	}
+}
+
+func TestNew_IsUUIDv7(t *testing.T) {
+	parsed, err := uuid.Parse(New())
+	if err != nil {
+		t.Fatalf("Expected a UUID, got error %v", err)
+	}
+	if parsed.Version() != 7 {
+		t.Errorf("Expected version 7, got %d", parsed.Version())
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"0190b6f4-7c3e-7d2a-9a64-1f0c2a5e8b11", true},
+		{"client-retry_42.attempt:3", true},
+		{"", false},
+		{strings.Repeat("a", MaxLength), true},
+		{strings.Repeat("a", MaxLength+1), false},
+		{"../../purge", false},
+		{"id with spaces", false},
+		{"id\r\nX-Injected: 1", false},
+		{"idé", false},
+	}
+
+	for _, tt := range tests {
+		if got := Valid(tt.id); got != tt.want {
+			t.Errorf("Valid(%q): expected %v, got %v", tt.id, tt.want, got)
+		}
+	}
+}
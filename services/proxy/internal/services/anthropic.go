@@ -0,0 +1,425 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+
+	// Anthropic requires max_tokens on every request; OpenAI clients often
+	// omit it.
+	anthropicDefaultMaxTokens = 4096
+)
+
+type anthropicStreamEvent struct {
+	Type         string                        `json:"type"`
+	Message      *models.AnthropicResponse     `json:"message,omitempty"`
+	Index        int                           `json:"index"`
+	ContentBlock *models.AnthropicContentBlock `json:"content_block,omitempty"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta,omitempty"`
+	Usage *models.AnthropicUsage `json:"usage,omitempty"`
+	Error *models.AnthropicError `json:"error,omitempty"`
+}
+
+type anthropicAdapter struct{}
+
+func (anthropicAdapter) path() string {
+	return "/v1/messages"
+}
+
+func (anthropicAdapter) setHeaders(header http.Header, apiKey string) {
+	header.Set("x-api-key", apiKey)
+	header.Set("anthropic-version", anthropicVersion)
+}
+
+func (anthropicAdapter) encodeRequest(req models.ChatCompletionRequest) ([]byte, error) {
+	out, err := toAnthropicRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+func (anthropicAdapter) decodeResponse(body io.Reader) (models.ChatCompletionResponse, error) {
+	var resp models.AnthropicResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return models.ChatCompletionResponse{}, err
+	}
+	return fromAnthropicResponse(resp, time.Now().Unix()), nil
+}
+
+func (anthropicAdapter) newStreamDecoder() streamDecoder {
+	return &anthropicStreamDecoder{created: time.Now().Unix()}
+}
+
+func toAnthropicRequest(req models.ChatCompletionRequest) (models.AnthropicRequest, error) {
+	out := models.AnthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = anthropicDefaultMaxTokens
+	}
+
+	// Anthropic has no developer role; like system messages, developer
+	// messages instruct the model rather than speak in the conversation.
+	var system []string
+	for _, msg := range req.Messages {
+		if msg.Role == "system" || msg.Role == "developer" {
+			system = append(system, msg.Text())
+			continue
+		}
+
+		role, content, err := toAnthropicContent(msg)
+		if err != nil {
+			return models.AnthropicRequest{}, err
+		}
+		if len(content) == 0 {
+			continue
+		}
+
+		// The Messages API rejects consecutive turns from the same role.
+		if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == role {
+			out.Messages[n-1].Content = appendContent(out.Messages[n-1].Content, content)
+			continue
+		}
+		out.Messages = append(out.Messages, models.AnthropicMessage{Role: role, Content: content})
+	}
+	if text := strings.Join(system, "\n\n"); text != "" {
+		out.System = models.AnthropicContent{{Type: "text", Text: text}}
+	}
+
+	stop, err := stopSequences(req.Stop)
+	if err != nil {
+		return models.AnthropicRequest{}, err
+	}
+	out.StopSequences = stop
+
+	for _, tool := range req.Tools {
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object"}`)
+		}
+		out.Tools = append(out.Tools, models.AnthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	choice, err := toAnthropicToolChoice(req.ToolChoice)
+	if err != nil {
+		return models.AnthropicRequest{}, err
+	}
+	if req.ParallelToolCalls != nil && !*req.ParallelToolCalls && len(out.Tools) > 0 {
+		if choice == nil {
+			choice = &models.AnthropicToolChoice{Type: "auto"}
+		}
+		choice.DisableParallelToolUse = true
+	}
+	out.ToolChoice = choice
+
+	return out, nil
+}
+
+// toAnthropicContent maps one OpenAI message to a role and content blocks:
+// tool results become user tool_result blocks, and tool calls become
+// tool_use blocks after the assistant's text.
+func toAnthropicContent(msg models.Message) (string, models.AnthropicContent, error) {
+	if msg.Role == "tool" {
+		result := models.AnthropicContentBlock{Type: "tool_result", ToolUseID: msg.ToolCallID}
+		if text := msg.Text(); text != "" {
+			result.Content = models.AnthropicContent{{Type: "text", Text: text}}
+		}
+		return "user", models.AnthropicContent{result}, nil
+	}
+
+	role := msg.Role
+	if role != "assistant" {
+		role = "user"
+	}
+
+	var content models.AnthropicContent
+	if msg.Content != "" {
+		content = append(content, models.AnthropicContentBlock{Type: "text", Text: msg.Content})
+	}
+	for _, part := range msg.Parts {
+		block, ok, err := toAnthropicBlock(part)
+		if err != nil {
+			return "", nil, err
+		}
+		if ok {
+			content = append(content, block)
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		input := json.RawMessage(call.Function.Arguments)
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		if !json.Valid(input) {
+			return "", nil, fmt.Errorf("%w: tool call %s has invalid JSON arguments", ErrInvalidRequest, call.ID)
+		}
+		content = append(content, models.AnthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+	}
+	return role, content, nil
+}
+
+// toAnthropicBlock maps a content part to a text or image block. Empty text
+// parts are dropped, since the API rejects empty text blocks.
+func toAnthropicBlock(part models.ContentPart) (models.AnthropicContentBlock, bool, error) {
+	if text, ok := part.ScannedText(); ok {
+		return models.AnthropicContentBlock{Type: "text", Text: text}, text != "", nil
+	}
+	switch part.Type {
+	case models.PartImageURL:
+		if part.ImageURL == nil {
+			return models.AnthropicContentBlock{}, false, fmt.Errorf("%w: image_url part without a URL", ErrInvalidRequest)
+		}
+		source, err := toAnthropicImageSource(part.ImageURL.URL)
+		if err != nil {
+			return models.AnthropicContentBlock{}, false, err
+		}
+		return models.AnthropicContentBlock{Type: "image", Source: source}, true, nil
+	default:
+		return models.AnthropicContentBlock{}, false, fmt.Errorf("%w: unsupported content part %q", ErrInvalidRequest, part.Type)
+	}
+}
+
+// toAnthropicImageSource turns a "data:<media type>;base64,<data>" URL into
+// a base64 source and anything else into a URL source.
+func toAnthropicImageSource(url string) (*models.AnthropicImageSource, error) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return &models.AnthropicImageSource{Type: "url", URL: url}, nil
+	}
+	mediaType, data, ok := strings.Cut(rest, ";base64,")
+	if !ok {
+		return nil, fmt.Errorf("%w: image data URL is not base64", ErrInvalidRequest)
+	}
+	return &models.AnthropicImageSource{Type: "base64", MediaType: mediaType, Data: data}, nil
+}
+
+// appendContent merges the blocks of two same-role turns, joining adjacent
+// text with a blank line.
+func appendContent(content, next models.AnthropicContent) models.AnthropicContent {
+	if n := len(content); n > 0 && content[n-1].Type == "text" && next[0].Type == "text" {
+		content[n-1].Text += "\n\n" + next[0].Text
+		next = next[1:]
+	}
+	return append(content, next...)
+}
+
+// stopSequences accepts OpenAI's stop, a single string or an array.
+func stopSequences(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var stop string
+	if err := json.Unmarshal(raw, &stop); err == nil {
+		return []string{stop}, nil
+	}
+	var stops []string
+	if err := json.Unmarshal(raw, &stops); err != nil {
+		return nil, fmt.Errorf("%w: unsupported stop %s", ErrInvalidRequest, raw)
+	}
+	return stops, nil
+}
+
+// toAnthropicToolChoice maps "auto", "none", "required" or a named function
+// to Anthropic's tool_choice.
+func toAnthropicToolChoice(raw json.RawMessage) (*models.AnthropicToolChoice, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		switch mode {
+		case "auto":
+			return &models.AnthropicToolChoice{Type: "auto"}, nil
+		case "none":
+			return &models.AnthropicToolChoice{Type: "none"}, nil
+		case "required":
+			return &models.AnthropicToolChoice{Type: "any"}, nil
+		default:
+			return nil, fmt.Errorf("%w: unsupported tool_choice %q", ErrInvalidRequest, mode)
+		}
+	}
+
+	var named struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &named); err != nil || named.Function.Name == "" {
+		return nil, fmt.Errorf("%w: unsupported tool_choice %s", ErrInvalidRequest, raw)
+	}
+	return &models.AnthropicToolChoice{Type: "tool", Name: named.Function.Name}, nil
+}
+
+func fromAnthropicResponse(resp models.AnthropicResponse, created int64) models.ChatCompletionResponse {
+	message := models.Message{Role: "assistant", Content: models.AnthropicContent(resp.Content).Text()}
+	for _, block := range resp.Content {
+		if block.Type == "tool_use" {
+			message.ToolCalls = append(message.ToolCalls, models.ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: models.FunctionCall{Name: block.Name, Arguments: string(block.Input)},
+			})
+		}
+	}
+
+	return models.ChatCompletionResponse{
+		ID:      resp.ID,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   resp.Model,
+		Choices: []models.Choice{
+			{
+				Index:        0,
+				Message:      message,
+				FinishReason: anthropicFinishReason(resp.StopReason),
+			},
+		},
+		Usage: models.Usage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
+		},
+	}
+}
+
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return stopReason
+	}
+}
+
+type anthropicStreamDecoder struct {
+	id           string
+	model        string
+	created      int64
+	promptTokens int
+	// toolCalls maps the content block index of each tool_use block to its
+	// OpenAI tool call index.
+	toolCalls map[int]int
+}
+
+func (d *anthropicStreamDecoder) decode(event sseEvent) (models.ChatCompletionChunk, bool, error) {
+	if event.Data == "" {
+		return models.ChatCompletionChunk{}, false, nil
+	}
+
+	var ev anthropicStreamEvent
+	if err := json.Unmarshal([]byte(event.Data), &ev); err != nil {
+		return models.ChatCompletionChunk{}, false, err
+	}
+
+	switch ev.Type {
+	case "message_start":
+		if ev.Message != nil {
+			d.id = ev.Message.ID
+			d.model = ev.Message.Model
+			d.promptTokens = ev.Message.Usage.InputTokens
+		}
+		return d.chunk(models.Delta{Role: "assistant"}, nil, nil), true, nil
+
+	case "content_block_start":
+		if ev.ContentBlock == nil || ev.ContentBlock.Type != "tool_use" {
+			return models.ChatCompletionChunk{}, false, nil
+		}
+		if d.toolCalls == nil {
+			d.toolCalls = make(map[int]int)
+		}
+		index := len(d.toolCalls)
+		d.toolCalls[ev.Index] = index
+		call := models.ToolCall{
+			Index:    &index,
+			ID:       ev.ContentBlock.ID,
+			Type:     "function",
+			Function: models.FunctionCall{Name: ev.ContentBlock.Name},
+		}
+		return d.chunk(models.Delta{ToolCalls: []models.ToolCall{call}}, nil, nil), true, nil
+
+	case "content_block_delta":
+		if ev.Delta == nil {
+			return models.ChatCompletionChunk{}, false, nil
+		}
+		switch ev.Delta.Type {
+		case "text_delta":
+			return d.chunk(models.Delta{Content: ev.Delta.Text}, nil, nil), true, nil
+		case "input_json_delta":
+			index, ok := d.toolCalls[ev.Index]
+			if !ok || ev.Delta.PartialJSON == "" {
+				return models.ChatCompletionChunk{}, false, nil
+			}
+			call := models.ToolCall{Index: &index, Function: models.FunctionCall{Arguments: ev.Delta.PartialJSON}}
+			return d.chunk(models.Delta{ToolCalls: []models.ToolCall{call}}, nil, nil), true, nil
+		default:
+			return models.ChatCompletionChunk{}, false, nil
+		}
+
+	case "message_delta":
+		if ev.Delta == nil || ev.Delta.StopReason == "" {
+			return models.ChatCompletionChunk{}, false, nil
+		}
+		reason := anthropicFinishReason(ev.Delta.StopReason)
+		var usage *models.Usage
+		if ev.Usage != nil {
+			usage = &models.Usage{
+				PromptTokens:     d.promptTokens,
+				CompletionTokens: ev.Usage.OutputTokens,
+				TotalTokens:      d.promptTokens + ev.Usage.OutputTokens,
+			}
+		}
+		return d.chunk(models.Delta{}, &reason, usage), true, nil
+
+	case "message_stop":
+		return models.ChatCompletionChunk{}, false, io.EOF
+
+	case "error":
+		if ev.Error != nil {
+			return models.ChatCompletionChunk{}, false, fmt.Errorf("LLM provider stream error: %s: %s", ev.Error.Type, ev.Error.Message)
+		}
+		return models.ChatCompletionChunk{}, false, errors.New("LLM provider stream error")
+
+	default:
+		return models.ChatCompletionChunk{}, false, nil
+	}
+}
+
+func (d *anthropicStreamDecoder) chunk(delta models.Delta, finishReason *string, usage *models.Usage) models.ChatCompletionChunk {
+	return models.ChatCompletionChunk{
+		ID:      d.id,
+		Object:  "chat.completion.chunk",
+		Created: d.created,
+		Model:   d.model,
+		Choices: []models.ChunkChoice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+		Usage:   usage,
+	}
+}
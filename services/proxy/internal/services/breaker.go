@@ -0,0 +1,343 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saferoute/proxy/internal/models"
+)
+
+// ErrCircuitOpen is returned without calling the service while its breaker
+// is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen lets a few trial calls through to see whether the
+	// service has recovered.
+	BreakerHalfOpen
+	// BreakerOpen fails every call fast.
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half_open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	circuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "Circuit breaker state: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"name"},
+	)
+
+	circuitBreakerRejectionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_rejections_total",
+			Help: "Total number of calls failed fast by an open circuit breaker",
+		},
+		[]string{"name"},
+	)
+)
+
+// BreakerSettings configures a CircuitBreaker. A FailureThreshold of zero
+// disables the breaker.
+type BreakerSettings struct {
+	// FailureThreshold is the number of consecutive failures that opens
+	// the breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before trying again.
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls is the number of trial calls let through while half
+	// open; that many successes close the breaker, one failure reopens it.
+	HalfOpenMaxCalls int
+}
+
+// CircuitBreaker stops calling a failing service for a while so callers
+// fail fast instead of each waiting for a timeout. Only failures that say
+// something about the service's health count: client errors do not, and
+// cancellations are ignored altogether.
+type CircuitBreaker struct {
+	name     string
+	settings BreakerSettings
+	now      func() time.Time
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	inFlight  int
+	successes int
+	// generation changes with every state change, so that calls started
+	// in an earlier state are not counted in the current one.
+	generation uint64
+}
+
+func NewCircuitBreaker(name string, settings BreakerSettings) *CircuitBreaker {
+	if settings.HalfOpenMaxCalls < 1 {
+		settings.HalfOpenMaxCalls = 1
+	}
+	b := &CircuitBreaker{
+		name:     name,
+		settings: settings,
+		now:      time.Now,
+	}
+	circuitBreakerState.WithLabelValues(name).Set(float64(BreakerClosed))
+	return b
+}
+
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+// State returns the current state, moving from open to half-open once the
+// open timeout has passed.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// Do runs call unless the breaker is open and records its outcome.
+func (b *CircuitBreaker) Do(call func() error) error {
+	if b.settings.FailureThreshold <= 0 {
+		return call()
+	}
+
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = call()
+	b.record(generation, err)
+	return err
+}
+
+func (b *CircuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+
+	switch b.state {
+	case BreakerOpen:
+	case BreakerHalfOpen:
+		if b.inFlight < b.settings.HalfOpenMaxCalls-b.successes {
+			b.inFlight++
+			return b.generation, nil
+		}
+	default:
+		return b.generation, nil
+	}
+
+	circuitBreakerRejectionsTotal.WithLabelValues(b.name).Inc()
+	return 0, fmt.Errorf("%w: %s", ErrCircuitOpen, b.name)
+}
+
+func (b *CircuitBreaker) record(generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	outcome := breakerOutcome(err)
+	switch b.state {
+	case BreakerHalfOpen:
+		b.inFlight--
+		switch outcome {
+		case breakerFailure:
+			b.setState(BreakerOpen)
+			return
+		case breakerNeutral:
+			// Free the trial slot for another call without deciding.
+			return
+		}
+		b.successes++
+		if b.successes >= b.settings.HalfOpenMaxCalls {
+			b.setState(BreakerClosed)
+		}
+	case BreakerClosed:
+		switch outcome {
+		case breakerSuccess:
+			b.failures = 0
+		case breakerFailure:
+			b.failures++
+			if b.failures >= b.settings.FailureThreshold {
+				b.setState(BreakerOpen)
+			}
+		}
+	}
+}
+
+// advance must be called with mu held.
+func (b *CircuitBreaker) advance() {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.settings.OpenTimeout {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+// setState must be called with mu held.
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.inFlight = 0
+	b.successes = 0
+	if state == BreakerOpen {
+		b.openedAt = b.now()
+	}
+	circuitBreakerState.WithLabelValues(b.name).Set(float64(state))
+}
+
+// callOutcome is what a call says about the service's health.
+type callOutcome int
+
+const (
+	breakerSuccess callOutcome = iota
+	breakerFailure
+	breakerNeutral
+)
+
+// breakerOutcome classifies err. A service that answers with a client error
+// is up. A call that was cancelled, or never reached the service, says
+// nothing about its health either way: it neither counts as a failure nor
+// resets the failure count or closes a half-open breaker.
+func breakerOutcome(err error) callOutcome {
+	switch ErrorCause(err) {
+	case "", "invalid_request", "status_4xx":
+		return breakerSuccess
+	case "canceled", "unrouted", "streaming_unsupported", "embeddings_unsupported", "circuit_open":
+		return breakerNeutral
+	default:
+		return breakerFailure
+	}
+}
+
+// NERBreaker guards a NERService with a CircuitBreaker.
+type NERBreaker struct {
+	next    NERService
+	breaker *CircuitBreaker
+}
+
+func NewNERBreaker(next NERService, breaker *CircuitBreaker) *NERBreaker {
+	return &NERBreaker{next: next, breaker: breaker}
+}
+
+func (n *NERBreaker) DetectEntities(ctx context.Context, text string) ([]models.Entity, error) {
+	var entities []models.Entity
+	err := n.breaker.Do(func() error {
+		var err error
+		entities, err = n.next.DetectEntities(ctx, text)
+		return err
+	})
+	return entities, err
+}
+
+// DetectEntitiesBatch keeps batching when the wrapped service supports it.
+func (n *NERBreaker) DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	var results [][]models.Entity
+	err := n.breaker.Do(func() error {
+		var err error
+		results, err = DetectEntitiesBatch(ctx, n.next, texts)
+		return err
+	})
+	return results, err
+}
+
+// VaultBreaker guards a VaultService with a CircuitBreaker.
+type VaultBreaker struct {
+	next    VaultService
+	breaker *CircuitBreaker
+}
+
+func NewVaultBreaker(next VaultService, breaker *CircuitBreaker) *VaultBreaker {
+	return &VaultBreaker{next: next, breaker: breaker}
+}
+
+func (v *VaultBreaker) StoreEntities(ctx context.Context, requestID string, entities []models.Entity) error {
+	return v.breaker.Do(func() error {
+		return v.next.StoreEntities(ctx, requestID, entities)
+	})
+}
+
+func (v *VaultBreaker) GetEntities(ctx context.Context, requestID string) ([]models.Entity, error) {
+	var entities []models.Entity
+	err := v.breaker.Do(func() error {
+		var err error
+		entities, err = v.next.GetEntities(ctx, requestID)
+		return err
+	})
+	return entities, err
+}
+
+// LLMBreaker guards an LLMService with a CircuitBreaker. Only opening a
+// stream counts; failures mid-stream do not.
+type LLMBreaker struct {
+	next    LLMService
+	breaker *CircuitBreaker
+}
+
+func NewLLMBreaker(next LLMService, breaker *CircuitBreaker) *LLMBreaker {
+	return &LLMBreaker{next: next, breaker: breaker}
+}
+
+func (l *LLMBreaker) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	var resp models.ChatCompletionResponse
+	err := l.breaker.Do(func() error {
+		var err error
+		resp, err = l.next.ChatCompletion(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (l *LLMBreaker) ChatCompletionStream(ctx context.Context, req models.ChatCompletionRequest) (ChatCompletionStream, error) {
+	streamer, ok := l.next.(LLMStreamService)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStreamingUnsupported, req.Model)
+	}
+
+	var stream ChatCompletionStream
+	err := l.breaker.Do(func() error {
+		var err error
+		stream, err = streamer.ChatCompletionStream(ctx, req)
+		return err
+	})
+	return stream, err
+}
+
+func (l *LLMBreaker) Embeddings(ctx context.Context, req models.EmbeddingRequest) (models.EmbeddingResponse, error) {
+	embedder, ok := l.next.(EmbeddingService)
+	if !ok {
+		return models.EmbeddingResponse{}, fmt.Errorf("%w: %s", ErrEmbeddingsUnsupported, req.Model)
+	}
+
+	var resp models.EmbeddingResponse
+	err := l.breaker.Do(func() error {
+		var err error
+		resp, err = embedder.Embeddings(ctx, req)
+		return err
+	})
+	return resp, err
+}
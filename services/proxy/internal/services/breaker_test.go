@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/saferoute/proxy/internal/models"
+)
+
+var errUnavailable = &StatusError{Service: "NER", StatusCode: http.StatusServiceUnavailable}
+
+func newTestBreaker(name string) (*CircuitBreaker, *time.Time) {
+	b := NewCircuitBreaker(name, BreakerSettings{
+		FailureThreshold: 3,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenMaxCalls: 1,
+	})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestCircuitBreaker_OpensAfterConsecutiveFailures(t *testing.T) {
+	b, _ := newTestBreaker("test-open")
+
+	for range 3 {
+		b.Do(func() error { return errUnavailable })
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("Expected open after 3 failures, got %s", b.State())
+	}
+	if got := testutil.ToFloat64(circuitBreakerState.WithLabelValues("test-open")); got != float64(BreakerOpen) {
+		t.Errorf("Expected state gauge 2, got %v", got)
+	}
+
+	called := false
+	err := b.Do(func() error { called = true; return nil })
+	if called || !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected a fast failure without calling, got called=%v err=%v", called, err)
+	}
+	if ErrorCause(err) != "circuit_open" {
+		t.Errorf("Expected cause circuit_open, got %s", ErrorCause(err))
+	}
+}
+
+func TestCircuitBreaker_SuccessResetsFailureCount(t *testing.T) {
+	b, _ := newTestBreaker("test-reset")
+
+	b.Do(func() error { return errUnavailable })
+	b.Do(func() error { return errUnavailable })
+	b.Do(func() error { return nil })
+	b.Do(func() error { return errUnavailable })
+
+	if b.State() != BreakerClosed {
+		t.Errorf("Expected closed when failures are not consecutive, got %s", b.State())
+	}
+}
+
+func TestCircuitBreaker_ClientErrorsDoNotCount(t *testing.T) {
+	b, _ := newTestBreaker("test-client-errors")
+
+	for _, err := range []error{
+		&ProviderError{StatusCode: http.StatusBadRequest},
+		context.Canceled,
+		ErrModelNotRouted,
+	} {
+		for range 3 {
+			b.Do(func() error { return err })
+		}
+	}
+	if b.State() != BreakerClosed {
+		t.Errorf("Expected client errors to leave the breaker closed, got %s", b.State())
+	}
+}
+
+func TestCircuitBreaker_HalfOpenRecovery(t *testing.T) {
+	b, now := newTestBreaker("test-half-open")
+	for range 3 {
+		b.Do(func() error { return errUnavailable })
+	}
+
+	*now = now.Add(10 * time.Second)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("Expected half-open after the open timeout, got %s", b.State())
+	}
+
+	// Only one trial call at a time while half open.
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- b.Do(func() error { <-release; return nil }) }()
+	for inFlight(b) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := b.Do(func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected a second trial call to be rejected, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected trial error: %v", err)
+	}
+
+	if b.State() != BreakerClosed {
+		t.Errorf("Expected closed after a successful trial, got %s", b.State())
+	}
+}
+
+func TestCircuitBreaker_CanceledCallsAreNeutral(t *testing.T) {
+	b, now := newTestBreaker("test-canceled")
+
+	b.Do(func() error { return errUnavailable })
+	b.Do(func() error { return errUnavailable })
+	b.Do(func() error { return context.Canceled })
+	b.Do(func() error { return errUnavailable })
+	if b.State() != BreakerOpen {
+		t.Fatalf("Expected a canceled call not to reset the failure count, got %s", b.State())
+	}
+
+	*now = now.Add(10 * time.Second)
+	b.Do(func() error { return context.Canceled })
+	if b.State() != BreakerHalfOpen {
+		t.Errorf("Expected a canceled trial to leave the breaker half-open, got %s", b.State())
+	}
+	if inFlight(b) != 0 {
+		t.Errorf("Expected a canceled trial to free its slot, got %d in flight", inFlight(b))
+	}
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Errorf("Expected another trial call to be let through, got %v", err)
+	}
+	if b.State() != BreakerClosed {
+		t.Errorf("Expected closed after a successful trial, got %s", b.State())
+	}
+}
+
+func TestCircuitBreaker_HalfOpenFailureReopens(t *testing.T) {
+	b, now := newTestBreaker("test-reopen")
+	for range 3 {
+		b.Do(func() error { return errUnavailable })
+	}
+
+	*now = now.Add(10 * time.Second)
+	b.Do(func() error { return errUnavailable })
+	if b.State() != BreakerOpen {
+		t.Fatalf("Expected open again after a failed trial, got %s", b.State())
+	}
+
+	*now = now.Add(5 * time.Second)
+	if b.State() != BreakerOpen {
+		t.Errorf("Expected the open timeout to restart, got %s", b.State())
+	}
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	b := NewCircuitBreaker("test-disabled", BreakerSettings{})
+	for range 10 {
+		b.Do(func() error { return errUnavailable })
+	}
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Errorf("Expected a disabled breaker to let calls through, got %v", err)
+	}
+}
+
+type countingBatchNER struct {
+	batches int
+}
+
+func (c *countingBatchNER) DetectEntities(ctx context.Context, text string) ([]models.Entity, error) {
+	return nil, nil
+}
+
+func (c *countingBatchNER) DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	c.batches++
+	return make([][]models.Entity, len(texts)), nil
+}
+
+func TestNERBreaker_KeepsBatching(t *testing.T) {
+	next := &countingBatchNER{}
+	b, _ := newTestBreaker("test-ner-batch")
+
+	results, err := DetectEntitiesBatch(context.Background(), NewNERBreaker(next, b), []string{"a", "b"})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Unexpected result: %v, %v", results, err)
+	}
+	if next.batches != 1 {
+		t.Errorf("Expected one batch call through the breaker, got %d", next.batches)
+	}
+}
+
+func TestLLMBreaker_FailoverSkipsOpenTarget(t *testing.T) {
+	primaryBreaker, _ := newTestBreaker("test-llm-primary")
+	for range 3 {
+		primaryBreaker.Do(func() error { return errUnavailable })
+	}
+
+	primary := &scriptedLLM{}
+	fallback := &scriptedLLM{}
+	f, sleeps := newTestFailover(NewLLMBreaker(primary, primaryBreaker),
+		LLMTarget{Name: "fallback", Service: fallback, Model: "gpt-4o"})
+
+	resp, err := f.ChatCompletion(context.Background(), models.ChatCompletionRequest{Model: "claude-3"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if primary.calls != 0 || fallback.calls != 1 || resp.Model != "gpt-4o" {
+		t.Errorf("Expected the fallback to serve without touching the open target, got primary=%d fallback=%d", primary.calls, fallback.calls)
+	}
+	if len(*sleeps) != 0 {
+		t.Errorf("Expected no backoff for an open circuit, got %v", *sleeps)
+	}
+}
+
+func inFlight(b *CircuitBreaker) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inFlight
+}
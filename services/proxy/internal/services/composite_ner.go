@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saferoute/proxy/internal/anonymizer"
+	"github.com/saferoute/proxy/internal/models"
+)
+
+var nerPartialResultsTotal = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "ner_partial_results_total",
+		Help: "Total number of detections answered by some detectors after others failed",
+	},
+)
+
+// CompositeNER runs several detectors over the same texts in parallel and
+// merges their results. Entities below the confidence threshold for their
+// type are dropped first; of the remaining overlapping spans the longest,
+// then the most confident, wins. Positions are returned as byte offsets.
+//
+// By default the detection fails when any detector does, so that the
+// degraded-mode policy decides what happens to the request. With partial
+// results allowed it fails only when every detector does.
+type CompositeNER struct {
+	detectors  []NERService
+	thresholds map[string]float64
+	partial    bool
+}
+
+// NewCompositeNER creates a composite over detectors. thresholds maps an
+// entity type to its minimum confidence; the "*" key applies to any type
+// without its own entry.
+func NewCompositeNER(thresholds map[string]float64, detectors ...NERService) *CompositeNER {
+	return &CompositeNER{detectors: detectors, thresholds: thresholds}
+}
+
+// AllowPartialResults makes the composite return what the detectors that
+// succeeded found when others fail, such as the regex results when the
+// remote NER service is down. Such requests skip the degraded-mode policy.
+func (c *CompositeNER) AllowPartialResults() *CompositeNER {
+	c.partial = true
+	return c
+}
+
+func (c *CompositeNER) DetectEntities(ctx context.Context, text string) ([]models.Entity, error) {
+	results, err := c.DetectEntitiesBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+func (c *CompositeNER) DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	perDetector := make([][][]models.Entity, len(c.detectors))
+	errs := make([]error, len(c.detectors))
+
+	var wg sync.WaitGroup
+	for i, d := range c.detectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			perDetector[i], errs[i] = DetectEntitiesBatch(ctx, d, texts)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	var firstErr error
+	for _, err := range errs {
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+	case !c.partial || failed == len(c.detectors):
+		return nil, firstErr
+	default:
+		nerPartialResultsTotal.Inc()
+	}
+
+	merged := make([][]models.Entity, len(texts))
+	for i, text := range texts {
+		var candidates []models.Entity
+		for d, results := range perDetector {
+			if errs[d] != nil {
+				continue
+			}
+			for _, entity := range results[i] {
+				if !c.meetsThreshold(entity) {
+					continue
+				}
+				if start, ok := anonymizer.Locate(text, entity); ok {
+					entity.Position = start
+				}
+				candidates = append(candidates, entity)
+			}
+		}
+		merged[i] = mergeEntities(candidates)
+	}
+	return merged, nil
+}
+
+func (c *CompositeNER) meetsThreshold(entity models.Entity) bool {
+	threshold, ok := c.thresholds[entity.Type]
+	if !ok {
+		threshold = c.thresholds["*"]
+	}
+	return entity.Confidence >= threshold
+}
+
+// mergeEntities drops duplicate and overlapping spans, preferring longer and
+// then more confident entities, and returns the survivors in text order.
+func mergeEntities(entities []models.Entity) []models.Entity {
+	sort.SliceStable(entities, func(i, j int) bool {
+		if len(entities[i].Original) != len(entities[j].Original) {
+			return len(entities[i].Original) > len(entities[j].Original)
+		}
+		return entities[i].Confidence > entities[j].Confidence
+	})
+
+	var kept []models.Entity
+	for _, e := range entities {
+		start, end := e.Position, e.Position+len(e.Original)
+		overlaps := false
+		for _, k := range kept {
+			if start < k.Position+len(k.Original) && k.Position < end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, e)
+		}
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].Position < kept[j].Position
+	})
+	return kept
+}
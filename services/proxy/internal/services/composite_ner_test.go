@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+type fixedNER struct {
+	entities []models.Entity
+	err      error
+}
+
+func (f *fixedNER) DetectEntities(ctx context.Context, text string) ([]models.Entity, error) {
+	return f.entities, f.err
+}
+
+func TestCompositeNER_CombinesDetectors(t *testing.T) {
+	text := "John Smith, 4111-1111-1111-1111"
+	remote := &fixedNER{entities: []models.Entity{
+		{Original: "John Smith", Token: "[PERSON_001]", Type: "PERSON", Position: 0, Confidence: 0.9},
+		{Original: "4111-1111-1111", Token: "[PHONE_001]", Type: "PHONE", Position: 12, Confidence: 0.6},
+	}}
+
+	composite := NewCompositeNER(nil, remote, NewRegexNER())
+	entities, err := composite.DetectEntities(context.Background(), text)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(entities) != 2 {
+		t.Fatalf("Expected 2 entities, got %+v", entities)
+	}
+	if entities[0].Type != "PERSON" || entities[1].Type != "CREDIT_CARD" {
+		t.Errorf("Expected the card to replace the overlapping phone, got %+v", entities)
+	}
+}
+
+func TestCompositeNER_DedupesKeepingMostConfident(t *testing.T) {
+	text := "Grüße an José"
+	a := &fixedNER{entities: []models.Entity{
+		// Code point offset, as the Python service reports it.
+		{Original: "José", Type: "PERSON", Position: 9, Confidence: 0.7},
+	}}
+	b := &fixedNER{entities: []models.Entity{
+		{Original: "José", Type: "PERSON", Position: 11, Confidence: 0.95},
+	}}
+
+	entities, err := NewCompositeNER(nil, a, b).DetectEntities(context.Background(), text)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entities) != 1 {
+		t.Fatalf("Expected duplicates to merge, got %+v", entities)
+	}
+	if entities[0].Confidence != 0.95 || entities[0].Position != 11 {
+		t.Errorf("Expected the confident entity at byte offset 11, got %+v", entities[0])
+	}
+}
+
+func TestCompositeNER_AppliesThresholds(t *testing.T) {
+	remote := &fixedNER{entities: []models.Entity{
+		{Original: "Ann", Type: "PERSON", Position: 0, Confidence: 0.7},
+		{Original: "Paris", Type: "LOCATION", Position: 8, Confidence: 0.6},
+		{Original: "Acme", Type: "ORG", Position: 17, Confidence: 0.4},
+	}}
+
+	thresholds := map[string]float64{"PERSON": 0.8, "*": 0.5}
+	entities, err := NewCompositeNER(thresholds, remote).DetectEntities(context.Background(), "Ann in  Paris at Acme")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entities) != 1 || entities[0].Original != "Paris" {
+		t.Errorf("Expected only Paris to pass its threshold, got %+v", entities)
+	}
+}
+
+func TestCompositeNER_ReturnsDetectorError(t *testing.T) {
+	failing := &fixedNER{err: errors.New("unavailable")}
+
+	_, err := NewCompositeNER(nil, NewRegexNER(), failing).DetectEntities(context.Background(), "text")
+	if err == nil {
+		t.Error("Expected the detector error to be returned")
+	}
+}
+
+func TestCompositeNER_PartialResults(t *testing.T) {
+	failing := &fixedNER{err: errors.New("unavailable")}
+
+	composite := NewCompositeNER(nil, NewRegexNER(), failing).AllowPartialResults()
+	entities, err := composite.DetectEntities(context.Background(), "Mail jane@example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entities) != 1 || entities[0].Type != "EMAIL" {
+		t.Errorf("Expected the regex email, got %+v", entities)
+	}
+
+	_, err = NewCompositeNER(nil, failing, failing).AllowPartialResults().DetectEntities(context.Background(), "text")
+	if err == nil {
+		t.Error("Expected an error when every detector fails")
+	}
+}
+
+func TestDictionaryNER_MatchesWholeWordsIgnoringCase(t *testing.T) {
+	ner := NewDictionaryNER(map[string][]string{
+		"PROJECT": {"Falcon", "Falcon Heavy"},
+		"ORG":     {"acme"},
+	})
+
+	text := "FALCON heavy ships for Acme, not Acmeco or Falconry"
+	entities, err := ner.DetectEntities(context.Background(), text)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(entities) != 2 {
+		t.Fatalf("Expected 2 entities, got %+v", entities)
+	}
+	if entities[0].Original != "FALCON heavy" || entities[0].Token != "[PROJECT_001]" {
+		t.Errorf("Expected the longer project term, got %+v", entities[0])
+	}
+	if entities[1].Original != "Acme" || text[entities[1].Position:entities[1].Position+4] != "Acme" {
+		t.Errorf("Expected Acme at its byte offset, got %+v", entities[1])
+	}
+}
+
+func TestLoadDictionaryNER(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dictionary.json")
+	if err := os.WriteFile(path, []byte(`{"CUSTOMER": ["Globex"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ner, err := LoadDictionaryNER(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entities, _ := ner.DetectEntities(context.Background(), "ticket from Globex")
+	if len(entities) != 1 || entities[0].Type != "CUSTOMER" {
+		t.Errorf("Expected a CUSTOMER entity, got %+v", entities)
+	}
+
+	if _, err := LoadDictionaryNER(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+const dictionaryConfidence = 1.0
+
+type dictionaryTerms struct {
+	entityType string
+	pattern    *regexp.Regexp
+}
+
+// DictionaryNER detects a fixed list of terms per entity type, such as
+// customer names or internal project codenames. Matching is case-insensitive
+// and only on whole words.
+type DictionaryNER struct {
+	terms []dictionaryTerms
+}
+
+// NewDictionaryNER builds a detector from terms keyed by entity type.
+func NewDictionaryNER(terms map[string][]string) *DictionaryNER {
+	types := make([]string, 0, len(terms))
+	for entityType := range terms {
+		types = append(types, entityType)
+	}
+	sort.Strings(types)
+
+	n := &DictionaryNER{}
+	for _, entityType := range types {
+		var quoted []string
+		for _, term := range terms[entityType] {
+			if term = strings.TrimSpace(term); term != "" {
+				quoted = append(quoted, regexp.QuoteMeta(term))
+			}
+		}
+		if len(quoted) == 0 {
+			continue
+		}
+		// Longer terms first so "Acme Corp" wins over "Acme".
+		sort.SliceStable(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+		n.terms = append(n.terms, dictionaryTerms{
+			entityType: entityType,
+			pattern:    regexp.MustCompile(`(?i)` + strings.Join(quoted, "|")),
+		})
+	}
+	return n
+}
+
+// LoadDictionaryNER reads a JSON object mapping entity types to term lists.
+func LoadDictionaryNER(path string) (*DictionaryNER, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dictionary: %w", err)
+	}
+
+	var terms map[string][]string
+	if err := json.Unmarshal(data, &terms); err != nil {
+		return nil, fmt.Errorf("failed to parse dictionary: %w", err)
+	}
+	return NewDictionaryNER(terms), nil
+}
+
+func (n *DictionaryNER) DetectEntities(ctx context.Context, text string) ([]models.Entity, error) {
+	return n.detect(text, make(map[string]int)), nil
+}
+
+func (n *DictionaryNER) DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	counters := make(map[string]int)
+	results := make([][]models.Entity, len(texts))
+	for i, text := range texts {
+		results[i] = n.detect(text, counters)
+	}
+	return results, nil
+}
+
+func (n *DictionaryNER) detect(text string, counters map[string]int) []models.Entity {
+	var matches []models.Entity
+	for _, t := range n.terms {
+		for _, loc := range t.pattern.FindAllStringIndex(text, -1) {
+			if !onWordBoundary(text, loc[0], loc[1]) {
+				continue
+			}
+			matches = append(matches, models.Entity{
+				Original:   text[loc[0]:loc[1]],
+				Type:       t.entityType,
+				Position:   loc[0],
+				Confidence: dictionaryConfidence,
+			})
+		}
+	}
+
+	entities := dropOverlaps(matches)
+	for i := range entities {
+		counters[entities[i].Type]++
+		entities[i].Token = fmt.Sprintf("[%s_%03d]", entities[i].Type, counters[entities[i].Type])
+	}
+	return entities
+}
+
+func onWordBoundary(text string, start, end int) bool {
+	if prev, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWordChar(prev) {
+		return false
+	}
+	if next, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordChar(next) {
+		return false
+	}
+	return true
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// StatusError is returned when the NER service or the vault answers with a
+// non-200 status.
+type StatusError struct {
+	Service    string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s service returned status %d", e.Service, e.StatusCode)
+}
+
+// ErrorCause classifies an upstream failure into a small, fixed set of
+// values suitable for a metric label.
+func ErrorCause(err error) string {
+	var (
+		providerErr *ProviderError
+		statusErr   *StatusError
+		netErr      net.Error
+		syntaxErr   *json.SyntaxError
+		typeErr     *json.UnmarshalTypeError
+	)
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrModelNotRouted):
+		return "unrouted"
+	case errors.Is(err, ErrStreamingUnsupported):
+		return "streaming_unsupported"
+	case errors.Is(err, ErrEmbeddingsUnsupported):
+		return "embeddings_unsupported"
+	case errors.Is(err, ErrInvalidRequest):
+		return "invalid_request"
+	case errors.As(err, &providerErr):
+		return statusCause(providerErr.StatusCode)
+	case errors.As(err, &statusErr):
+		return statusCause(statusErr.StatusCode)
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "connection"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return "invalid_response"
+	default:
+		return "other"
+	}
+}
+
+func statusCause(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limited"
+	case status == statusOverloaded:
+		return "overloaded"
+	case status >= 500:
+		return "status_5xx"
+	default:
+		return "status_4xx"
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrorCause(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"canceled", fmt.Errorf("failed to send request: %w", context.Canceled), "canceled"},
+		{"deadline", fmt.Errorf("failed to send request: %w", context.DeadlineExceeded), "timeout"},
+		{"unrouted", fmt.Errorf("%w: %q", ErrModelNotRouted, "x"), "unrouted"},
+		{"streaming", ErrStreamingUnsupported, "streaming_unsupported"},
+		{"invalid request", fmt.Errorf("failed to marshal request: %w", ErrInvalidRequest), "invalid_request"},
+		{"provider 429", &ProviderError{StatusCode: http.StatusTooManyRequests}, "rate_limited"},
+		{"provider 529", &ProviderError{StatusCode: statusOverloaded}, "overloaded"},
+		{"provider 502", &ProviderError{StatusCode: http.StatusBadGateway}, "status_5xx"},
+		{"provider 401", &ProviderError{StatusCode: http.StatusUnauthorized}, "status_4xx"},
+		{"vault 500", &StatusError{Service: "vault", StatusCode: 500}, "status_5xx"},
+		{"connection", fmt.Errorf("failed to send request: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), "connection"},
+		{"decode", fmt.Errorf("failed to decode response: %w", &json.SyntaxError{}), "invalid_response"},
+		{"other", errors.New("boom"), "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorCause(tt.err); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestErrorCause_ClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := NewVaultClient(server.URL)
+	client.httpClient.Timeout = 10 * time.Millisecond
+
+	_, err := client.GetEntities(context.Background(), "req-1")
+	if got := ErrorCause(err); got != "timeout" {
+		t.Errorf("Expected timeout, got %q (%v)", got, err)
+	}
+}
+
+func TestStatusError_Message(t *testing.T) {
+	err := &StatusError{Service: "NER", StatusCode: 503}
+	if err.Error() != "NER service returned status 503" {
+		t.Errorf("Unexpected message: %s", err.Error())
+	}
+}
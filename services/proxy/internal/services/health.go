@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// llmHealthPath lists models on both Anthropic and OpenAI-compatible APIs;
+// it is free and proves that the endpoint and the API key both work.
+const llmHealthPath = "/v1/models"
+
+func (c *NERClient) Health(ctx context.Context) error {
+	return checkHealth(ctx, c.httpClient, c.baseURL+"/health", "NER", nil)
+}
+
+func (c *VaultClient) Health(ctx context.Context) error {
+	return checkHealth(ctx, c.httpClient, c.baseURL+"/health", "vault", nil)
+}
+
+func (c *LLMClient) Health(ctx context.Context) error {
+	return checkHealth(ctx, c.httpClient, c.baseURL+llmHealthPath, "LLM provider", func(h http.Header) {
+		c.adapter.setHeaders(h, c.apiKey)
+	})
+}
+
+func checkHealth(ctx context.Context, client *http.Client, url, service string, setHeaders func(http.Header)) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if setHeaders != nil {
+		setHeaders(req.Header)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Service: service, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth_Clients(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer server.Close()
+
+	if err := NewNERClient(server.URL).Health(context.Background()); err != nil {
+		t.Errorf("NER: unexpected error: %v", err)
+	}
+	if err := NewVaultClient(server.URL).Health(context.Background()); err != nil {
+		t.Errorf("Vault: unexpected error: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != "/health" || paths[1] != "/health" {
+		t.Errorf("Expected two /health calls, got %v", paths)
+	}
+}
+
+func TestHealth_LLMUsesProviderAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			t.Errorf("Expected /v1/models, got %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	client, _ := NewLLMClient(ProviderAnthropic, server.URL, "test-key")
+	if err := client.Health(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	client, _ = NewLLMClient(ProviderAnthropic, server.URL, "wrong-key")
+	err := client.Health(context.Background())
+	if ErrorCause(err) != "status_4xx" {
+		t.Errorf("Expected a 4xx status error for a bad key, got %v", err)
+	}
+}
@@ -10,6 +10,12 @@ type NERService interface {
 	DetectEntities(ctx context.Context, text string) ([]models.Entity, error)
 }
 
+// BatchNERService is implemented by NER clients that can scan several texts
+// in one call, with tokens unique across the whole batch.
+type BatchNERService interface {
+	DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error)
+}
+
 type VaultService interface {
 	StoreEntities(ctx context.Context, requestID string, entities []models.Entity) error
 	GetEntities(ctx context.Context, requestID string) ([]models.Entity, error)
@@ -18,3 +24,33 @@ type VaultService interface {
 type LLMService interface {
 	ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error)
 }
+
+// LLMStreamService is implemented by LLM clients that can relay a chat
+// completion as a sequence of server-sent chunks.
+type LLMStreamService interface {
+	ChatCompletionStream(ctx context.Context, req models.ChatCompletionRequest) (ChatCompletionStream, error)
+}
+
+// EmbeddingService is implemented by LLM clients whose provider has an
+// embeddings API.
+type EmbeddingService interface {
+	Embeddings(ctx context.Context, req models.EmbeddingRequest) (models.EmbeddingResponse, error)
+}
+
+// ModelLabeler maps a requested model to a bounded metric label, so that
+// arbitrary model names sent by clients cannot grow label cardinality.
+type ModelLabeler interface {
+	ModelLabel(model string) string
+}
+
+// ChatCompletionStream yields chunks until Recv returns io.EOF.
+type ChatCompletionStream interface {
+	Recv() (models.ChatCompletionChunk, error)
+	Close() error
+}
+
+// HealthChecker is implemented by clients that can tell whether their
+// service is reachable, for the readiness probe.
+type HealthChecker interface {
+	Health(ctx context.Context) error
+}
@@ -5,57 +5,208 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/tracing"
 )
 
 type LLMClient struct {
-	baseURL    string
-	apiKey     string
-	httpClient *http.Client
+	baseURL      string
+	apiKey       string
+	adapter      providerAdapter
+	httpClient   *http.Client
+	streamClient *http.Client
 }
 
-func NewLLMClient(baseURL, apiKey string) *LLMClient {
+func NewLLMClient(provider, baseURL, apiKey string) (*LLMClient, error) {
+	adapter, err := adapterFor(provider)
+	if err != nil {
+		return nil, err
+	}
+
 	return &LLMClient{
 		baseURL: baseURL,
 		apiKey:  apiKey,
+		adapter: adapter,
 		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
+			Timeout:   60 * time.Second,
+			Transport: tracing.NewExternalTransport(provider, nil),
 		},
-	}
+		// Streams can legitimately outlive any fixed deadline, so only the
+		// wait for response headers is bounded; the caller's context ends it.
+		streamClient: &http.Client{
+			Transport: tracing.NewExternalTransport(provider, &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: 60 * time.Second,
+			}),
+		},
+	}, nil
 }
 
 func (c *LLMClient) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	req.Stream = false
+
+	resp, err := c.send(ctx, c.httpClient, req)
+	if err != nil {
+		return models.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	llmResp, err := c.adapter.decodeResponse(resp.Body)
+	if err != nil {
+		return models.ChatCompletionResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return llmResp, nil
+}
+
+func (c *LLMClient) ChatCompletionStream(ctx context.Context, req models.ChatCompletionRequest) (ChatCompletionStream, error) {
+	req.Stream = true
+
+	resp, err := c.send(ctx, c.streamClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &llmStream{
+		body:    resp.Body,
+		events:  newSSEReader(resp.Body),
+		decoder: c.adapter.newStreamDecoder(),
+	}, nil
+}
+
+// Embeddings sends req to the provider's embeddings API. Anthropic has
+// none, so its clients return ErrEmbeddingsUnsupported.
+func (c *LLMClient) Embeddings(ctx context.Context, req models.EmbeddingRequest) (models.EmbeddingResponse, error) {
+	embedder, ok := c.adapter.(embeddingsAdapter)
+	if !ok {
+		return models.EmbeddingResponse{}, fmt.Errorf("%w: %s", ErrEmbeddingsUnsupported, req.Model)
+	}
+
 	jsonData, err := json.Marshal(req)
 	if err != nil {
-		return models.ChatCompletionResponse{}, fmt.Errorf("failed to marshal request: %w", err)
+		return models.EmbeddingResponse{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := c.post(ctx, c.httpClient, embedder.embeddingsPath(), jsonData, false)
+	if err != nil {
+		return models.EmbeddingResponse{}, err
 	}
+	defer resp.Body.Close()
+
+	var embeddings models.EmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
+		return models.EmbeddingResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return embeddings, nil
+}
+
+func (c *LLMClient) send(ctx context.Context, client *http.Client, req models.ChatCompletionRequest) (*http.Response, error) {
+	jsonData, err := c.adapter.encodeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return c.post(ctx, client, c.adapter.path(), jsonData, req.Stream)
+}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/v1/messages", bytes.NewBuffer(jsonData))
+func (c *LLMClient) post(ctx context.Context, client *http.Client, path string, body []byte, stream bool) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, bytes.NewBuffer(body))
 	if err != nil {
-		return models.ChatCompletionResponse{}, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", c.apiKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	c.adapter.setHeaders(httpReq.Header, c.apiKey)
+	if stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := client.Do(httpReq)
 	if err != nil {
-		return models.ChatCompletionResponse{}, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return models.ChatCompletionResponse{}, fmt.Errorf("LLM provider returned status %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, &ProviderError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 
-	var llmResp models.ChatCompletionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&llmResp); err != nil {
-		return models.ChatCompletionResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	return resp, nil
+}
+
+// ProviderError is returned when the upstream provider answers with a
+// non-200 status. RetryAfter is zero unless the provider sent the header.
+type ProviderError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("LLM provider returned status %d", e.StatusCode)
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
 	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
 
-	return llmResp, nil
+type llmStream struct {
+	body    io.ReadCloser
+	events  *sseReader
+	decoder streamDecoder
+	// done is set once the decoder has seen the provider's terminal event
+	// ([DONE] or message_stop).
+	done bool
+}
+
+// Recv returns io.EOF only after the provider's terminal event. A body that
+// ends before it, such as a dropped connection, yields io.ErrUnexpectedEOF
+// so that a truncated answer is not passed off as a complete one.
+func (s *llmStream) Recv() (models.ChatCompletionChunk, error) {
+	if s.done {
+		return models.ChatCompletionChunk{}, io.EOF
+	}
+	for {
+		event, err := s.events.Next()
+		if err == io.EOF {
+			return models.ChatCompletionChunk{}, fmt.Errorf("stream ended before its final event: %w", io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return models.ChatCompletionChunk{}, err
+		}
+
+		chunk, ok, err := s.decoder.decode(event)
+		if err == io.EOF {
+			s.done = true
+			return models.ChatCompletionChunk{}, io.EOF
+		}
+		if err != nil {
+			return models.ChatCompletionChunk{}, fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if ok {
+			return chunk, nil
+		}
+	}
+}
+
+func (s *llmStream) Close() error {
+	return s.body.Close()
 }
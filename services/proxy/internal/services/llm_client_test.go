@@ -0,0 +1,550 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+func testRequest() models.ChatCompletionRequest {
+	return models.ChatCompletionRequest{
+		Model: "claude-3",
+		Messages: []models.Message{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Hello"},
+			{Role: "user", Content: "Are you there?"},
+			{Role: "assistant", Content: "Yes."},
+			{Role: "user", Content: "Good."},
+		},
+	}
+}
+
+func TestLLMClient_AnthropicChatCompletion(t *testing.T) {
+	var got models.AnthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("Expected /v1/messages, got %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" {
+			t.Error("Expected x-api-key header")
+		}
+		if r.Header.Get("anthropic-version") == "" {
+			t.Error("Expected anthropic-version header")
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+
+		fmt.Fprint(w, `{
+			"id": "msg_123",
+			"type": "message",
+			"role": "assistant",
+			"model": "claude-3",
+			"content": [{"type": "text", "text": "Hi "}, {"type": "text", "text": "there"}],
+			"stop_reason": "max_tokens",
+			"usage": {"input_tokens": 12, "output_tokens": 3}
+		}`)
+	}))
+	defer server.Close()
+
+	client, err := NewLLMClient(ProviderAnthropic, server.URL, "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.ChatCompletion(context.Background(), testRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got.System.Text() != "Be brief." {
+		t.Errorf("Expected system prompt to be lifted, got %q", got.System.Text())
+	}
+	if got.MaxTokens != anthropicDefaultMaxTokens {
+		t.Errorf("Expected default max_tokens, got %d", got.MaxTokens)
+	}
+	wantRoles := []string{"user", "assistant", "user"}
+	if len(got.Messages) != len(wantRoles) {
+		t.Fatalf("Expected %d messages, got %d", len(wantRoles), len(got.Messages))
+	}
+	for i, role := range wantRoles {
+		if got.Messages[i].Role != role {
+			t.Errorf("Message %d: expected role %s, got %s", i, role, got.Messages[i].Role)
+		}
+	}
+	if got.Messages[0].Content.Text() != "Hello\n\nAre you there?" {
+		t.Errorf("Expected consecutive user turns to be merged, got %q", got.Messages[0].Content.Text())
+	}
+
+	if resp.ID != "msg_123" || resp.Object != "chat.completion" {
+		t.Errorf("Unexpected response envelope: %+v", resp)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "Hi there" {
+		t.Fatalf("Unexpected choices: %+v", resp.Choices)
+	}
+	if resp.Choices[0].FinishReason != "length" {
+		t.Errorf("Expected finish_reason length, got %s", resp.Choices[0].FinishReason)
+	}
+	if resp.Usage.PromptTokens != 12 || resp.Usage.CompletionTokens != 3 || resp.Usage.TotalTokens != 15 {
+		t.Errorf("Unexpected usage: %+v", resp.Usage)
+	}
+}
+
+func TestLLMClient_AnthropicStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, strings.Join([]string{
+			"event: message_start",
+			`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-3","usage":{"input_tokens":7}}}`,
+			"",
+			"event: ping",
+			`data: {"type":"ping"}`,
+			"",
+			"event: content_block_delta",
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+			"",
+			"event: content_block_delta",
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+			"",
+			"event: message_delta",
+			`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":2}}`,
+			"",
+			"event: message_stop",
+			`data: {"type":"message_stop"}`,
+			"",
+		}, "\n"))
+	}))
+	defer server.Close()
+
+	client, _ := NewLLMClient(ProviderAnthropic, server.URL, "test-key")
+	stream, err := client.ChatCompletionStream(context.Background(), testRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	var finish string
+	var usage *models.Usage
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if chunk.ID != "msg_1" {
+			t.Errorf("Expected chunk id msg_1, got %q", chunk.ID)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != nil {
+				finish = *choice.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+
+	if content.String() != "Hello" {
+		t.Errorf("Expected Hello, got %q", content.String())
+	}
+	if finish != "stop" {
+		t.Errorf("Expected finish_reason stop, got %q", finish)
+	}
+	if usage == nil || usage.TotalTokens != 9 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+}
+
+func TestLLMClient_OpenAIPassthrough(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Expected /v1/chat/completions, got %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Error("Expected bearer authorization")
+		}
+
+		var req models.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Messages) != 5 || req.Messages[0].Role != "system" {
+			t.Errorf("Expected messages to be forwarded unchanged, got %+v", req.Messages)
+		}
+
+		json.NewEncoder(w).Encode(models.ChatCompletionResponse{
+			ID:      "chatcmpl-1",
+			Choices: []models.Choice{{Message: models.Message{Role: "assistant", Content: "ok"}}},
+		})
+	}))
+	defer server.Close()
+
+	client, _ := NewLLMClient(ProviderOpenAI, server.URL, "test-key")
+	resp, err := client.ChatCompletion(context.Background(), testRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.ID != "chatcmpl-1" || resp.Choices[0].Message.Content != "ok" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+}
+
+func TestLLMClient_OpenAIStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"a\"}}]}\n\n")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"b\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client, _ := NewLLMClient(ProviderOpenAI, server.URL, "test-key")
+	stream, err := client.ChatCompletionStream(context.Background(), testRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if content.String() != "ab" {
+		t.Errorf("Expected ab, got %q", content.String())
+	}
+}
+
+func TestLLMClient_StreamCutShort(t *testing.T) {
+	tests := []struct {
+		provider string
+		body     string
+	}{
+		{ProviderOpenAI, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"a\"}}]}\n\n"},
+		{ProviderAnthropic, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"a\"}}\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			client, _ := NewLLMClient(tt.provider, server.URL, "test-key")
+			stream, err := client.ChatCompletionStream(context.Background(), testRequest())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer stream.Close()
+
+			if _, err := stream.Recv(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := stream.Recv(); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("Expected io.ErrUnexpectedEOF without a final event, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewLLMClient_UnknownProvider(t *testing.T) {
+	if _, err := NewLLMClient("carrier-pigeon", "http://localhost", ""); err == nil {
+		t.Error("Expected error for unknown provider")
+	}
+}
+
+func toolRequest() models.ChatCompletionRequest {
+	return models.ChatCompletionRequest{
+		Model: "claude-3",
+		Messages: []models.Message{
+			{Role: "user", Content: "Email Ann"},
+			{Role: "assistant", ToolCalls: []models.ToolCall{{ID: "call_1", Type: "function", Function: models.FunctionCall{Name: "send_email", Arguments: `{"to":"[EMAIL_001]"}`}}}},
+			{Role: "tool", ToolCallID: "call_1", Content: "sent"},
+			{Role: "user", Content: "Thanks"},
+		},
+		Tools: []models.Tool{{Type: "function", Function: models.FunctionDefinition{
+			Name:       "send_email",
+			Parameters: json.RawMessage(`{"type":"object","properties":{"to":{"type":"string"}}}`),
+		}}},
+		ToolChoice: json.RawMessage(`"required"`),
+	}
+}
+
+func TestLLMClient_AnthropicToolCalls(t *testing.T) {
+	var got models.AnthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+
+		fmt.Fprint(w, `{
+			"id": "msg_2",
+			"model": "claude-3",
+			"content": [
+				{"type": "text", "text": "Sending."},
+				{"type": "tool_use", "id": "toolu_1", "name": "send_email", "input": {"to": "[EMAIL_001]"}}
+			],
+			"stop_reason": "tool_use",
+			"usage": {"input_tokens": 30, "output_tokens": 9}
+		}`)
+	}))
+	defer server.Close()
+
+	client, _ := NewLLMClient(ProviderAnthropic, server.URL, "test-key")
+	resp, err := client.ChatCompletion(context.Background(), toolRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(got.Tools) != 1 || got.Tools[0].Name != "send_email" || !strings.Contains(string(got.Tools[0].InputSchema), `"to"`) {
+		t.Errorf("Unexpected tools: %+v", got.Tools)
+	}
+	if got.ToolChoice == nil || got.ToolChoice.Type != "any" {
+		t.Errorf("Expected tool_choice any, got %+v", got.ToolChoice)
+	}
+
+	wantRoles := []string{"user", "assistant", "user"}
+	if len(got.Messages) != len(wantRoles) {
+		t.Fatalf("Expected %d messages, got %d", len(wantRoles), len(got.Messages))
+	}
+	use := got.Messages[1].Content[0]
+	if use.Type != "tool_use" || use.ID != "call_1" || string(use.Input) != `{"to":"[EMAIL_001]"}` {
+		t.Errorf("Unexpected tool_use block: %+v", use)
+	}
+	result := got.Messages[2].Content
+	if len(result) != 2 || result[0].Type != "tool_result" || result[0].ToolUseID != "call_1" || result[0].Content.Text() != "sent" || result[1].Text != "Thanks" {
+		t.Errorf("Expected the tool result and next user turn to be merged, got %+v", result)
+	}
+
+	msg := resp.Choices[0].Message
+	if msg.Content != "Sending." || len(msg.ToolCalls) != 1 {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+	call := msg.ToolCalls[0]
+	if call.ID != "toolu_1" || call.Type != "function" || call.Function.Name != "send_email" || call.Function.Arguments != `{"to": "[EMAIL_001]"}` {
+		t.Errorf("Unexpected tool call: %+v", call)
+	}
+	if resp.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls, got %s", resp.Choices[0].FinishReason)
+	}
+}
+
+func TestLLMClient_AnthropicInvalidToolRequest(t *testing.T) {
+	client, _ := NewLLMClient(ProviderAnthropic, "http://127.0.0.1:0", "test-key")
+
+	req := toolRequest()
+	req.Messages[1].ToolCalls[0].Function.Arguments = `{"to":`
+	if _, err := client.ChatCompletion(context.Background(), req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected ErrInvalidRequest for malformed arguments, got %v", err)
+	}
+
+	req = toolRequest()
+	req.ToolChoice = json.RawMessage(`"sometimes"`)
+	if _, err := client.ChatCompletion(context.Background(), req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected ErrInvalidRequest for an unknown tool_choice, got %v", err)
+	}
+}
+
+func TestLLMClient_AnthropicStreamToolUse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, strings.Join([]string{
+			`data: {"type":"message_start","message":{"id":"msg_3","model":"claude-3","usage":{"input_tokens":7}}}`,
+			"",
+			`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			"",
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"On it."}}`,
+			"",
+			`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"send_email","input":{}}}`,
+			"",
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"to\": \"[EMA"}}`,
+			"",
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"IL_001]\"}"}}`,
+			"",
+			`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}`,
+			"",
+			`data: {"type":"message_stop"}`,
+			"",
+		}, "\n"))
+	}))
+	defer server.Close()
+
+	client, _ := NewLLMClient(ProviderAnthropic, server.URL, "test-key")
+	stream, err := client.ChatCompletionStream(context.Background(), toolRequest())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer stream.Close()
+
+	var id, name, finish string
+	var args strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, choice := range chunk.Choices {
+			for _, call := range choice.Delta.ToolCalls {
+				if call.Index == nil || *call.Index != 0 {
+					t.Errorf("Expected tool call index 0, got %v", call.Index)
+				}
+				if call.ID != "" {
+					id, name = call.ID, call.Function.Name
+				}
+				args.WriteString(call.Function.Arguments)
+			}
+			if choice.FinishReason != nil {
+				finish = *choice.FinishReason
+			}
+		}
+	}
+
+	if id != "toolu_1" || name != "send_email" {
+		t.Errorf("Expected the call's id and name in its first delta, got %q %q", id, name)
+	}
+	if args.String() != `{"to": "[EMAIL_001]"}` {
+		t.Errorf("Unexpected arguments %q", args.String())
+	}
+	if finish != "tool_calls" {
+		t.Errorf("Expected finish_reason tool_calls, got %q", finish)
+	}
+}
+
+func TestToAnthropicRequest_ImageParts(t *testing.T) {
+	req := models.ChatCompletionRequest{
+		Model: "claude-3",
+		Messages: []models.Message{{Role: "user", Parts: []models.ContentPart{
+			{Type: models.PartText, Text: "Compare"},
+			{Type: models.PartImageURL, ImageURL: &models.ImageURL{URL: "data:image/png;base64,iVBORw0KGgo="}},
+			{Type: models.PartImageURL, ImageURL: &models.ImageURL{URL: "https://example.com/b.jpg"}},
+		}}},
+	}
+
+	out, err := toAnthropicRequest(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	blocks := out.Messages[0].Content
+	if len(blocks) != 3 || blocks[0].Text != "Compare" {
+		t.Fatalf("Unexpected blocks: %+v", blocks)
+	}
+	if src := blocks[1].Source; blocks[1].Type != "image" || src.Type != "base64" || src.MediaType != "image/png" || src.Data != "iVBORw0KGgo=" {
+		t.Errorf("Unexpected base64 image block: %+v", blocks[1])
+	}
+	if src := blocks[2].Source; src.Type != "url" || src.URL != "https://example.com/b.jpg" {
+		t.Errorf("Unexpected URL image block: %+v", blocks[2])
+	}
+
+	req.Messages[0].Parts = append(req.Messages[0].Parts, models.ContentPart{Type: models.PartInputAudio})
+	if _, err := toAnthropicRequest(req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected ErrInvalidRequest for an audio part, got %v", err)
+	}
+}
+
+func TestToAnthropicRequest_DeveloperMessages(t *testing.T) {
+	req := models.ChatCompletionRequest{Model: "claude-3", Messages: []models.Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "developer", Content: "Answer in French."},
+		{Role: "user", Content: "Hello"},
+	}}
+
+	out, err := toAnthropicRequest(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(out.System) != 1 || out.System[0].Text != "Be brief.\n\nAnswer in French." {
+		t.Errorf("Expected developer text in the system prompt, got %+v", out.System)
+	}
+	if len(out.Messages) != 1 || out.Messages[0].Role != "user" || out.Messages[0].Content[0].Text != "Hello" {
+		t.Errorf("Expected only the user turn, got %+v", out.Messages)
+	}
+}
+
+func TestToAnthropicRequest_Stop(t *testing.T) {
+	topP := 0.5
+	req := testRequest()
+	req.TopP = &topP
+	req.Stop = json.RawMessage(`"END"`)
+
+	out, err := toAnthropicRequest(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(out.StopSequences) != 1 || out.StopSequences[0] != "END" || out.TopP == nil || *out.TopP != 0.5 {
+		t.Errorf("Expected stop sequence and top_p, got %v and %v", out.StopSequences, out.TopP)
+	}
+
+	req.Stop = json.RawMessage(`["\n\n", "END"]`)
+	if out, _ := toAnthropicRequest(req); len(out.StopSequences) != 2 {
+		t.Errorf("Expected two stop sequences, got %v", out.StopSequences)
+	}
+
+	req.Stop = json.RawMessage(`42`)
+	if _, err := toAnthropicRequest(req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected ErrInvalidRequest for a numeric stop, got %v", err)
+	}
+}
+
+func TestLLMClient_OpenAIEmbeddings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("Expected /v1/embeddings, got %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if want := `{"model":"text-embedding-3-small","input":["one","two"],"dimensions":2}`; string(body) != want {
+			t.Errorf("Expected %s, got %s", want, body)
+		}
+
+		fmt.Fprint(w, `{
+			"object": "list",
+			"data": [
+				{"object": "embedding", "index": 0, "embedding": [0.0023064255, -0.009327292]},
+				{"object": "embedding", "index": 1, "embedding": "AAAAAAAA8D8="}
+			],
+			"model": "text-embedding-3-small",
+			"usage": {"prompt_tokens": 2, "total_tokens": 2}
+		}`)
+	}))
+	defer server.Close()
+
+	var req models.EmbeddingRequest
+	if err := json.Unmarshal([]byte(`{"model":"text-embedding-3-small","input":["one","two"],"dimensions":2}`), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	client, _ := NewLLMClient(ProviderOpenAI, server.URL, "test-key")
+	resp, err := client.Embeddings(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(resp.Data) != 2 || string(resp.Data[0].Embedding) != "[0.0023064255, -0.009327292]" || string(resp.Data[1].Embedding) != `"AAAAAAAA8D8="` {
+		t.Errorf("Expected vectors as sent, got %+v", resp.Data)
+	}
+	if resp.Usage.TotalTokens != 2 {
+		t.Errorf("Expected usage, got %+v", resp.Usage)
+	}
+}
+
+func TestLLMClient_AnthropicEmbeddingsUnsupported(t *testing.T) {
+	client, _ := NewLLMClient(ProviderAnthropic, "http://127.0.0.1:0", "test-key")
+	_, err := client.Embeddings(context.Background(), models.EmbeddingRequest{Model: "claude-3", Input: []string{"hi"}})
+	if !errors.Is(err, ErrEmbeddingsUnsupported) {
+		t.Errorf("Expected ErrEmbeddingsUnsupported, got %v", err)
+	}
+}
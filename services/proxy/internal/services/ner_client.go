@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/tracing"
 )
 
 type NERClient struct {
@@ -20,7 +21,8 @@ func NewNERClient(baseURL string) *NERClient {
 	return &NERClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: tracing.NewTransport("ner", nil),
 		},
 	}
 }
@@ -50,7 +52,7 @@ func (c *NERClient) DetectEntities(ctx context.Context, text string) ([]models.E
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("NER service returned status %d", resp.StatusCode)
+		return nil, &StatusError{Service: "NER", StatusCode: resp.StatusCode}
 	}
 
 	var nerResp models.NERResponse
@@ -60,3 +62,68 @@ func (c *NERClient) DetectEntities(ctx context.Context, text string) ([]models.E
 
 	return nerResp.Entities, nil
 }
+
+func (c *NERClient) DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	reqBody := models.NERBatchRequest{
+		Texts:  texts,
+		Domain: "general",
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/detect/batch", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Service: "NER", StatusCode: resp.StatusCode}
+	}
+
+	var nerResp models.NERBatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&nerResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(nerResp.Results) != len(texts) {
+		return nil, fmt.Errorf("NER service returned %d results for %d texts", len(nerResp.Results), len(texts))
+	}
+
+	results := make([][]models.Entity, len(nerResp.Results))
+	for i, result := range nerResp.Results {
+		results[i] = result.Entities
+	}
+	return results, nil
+}
+
+// DetectEntitiesBatch scans each text with ner, in a single call when ner
+// supports batching. Empty texts are not sent to services that lack it.
+func DetectEntitiesBatch(ctx context.Context, ner NERService, texts []string) ([][]models.Entity, error) {
+	if batcher, ok := ner.(BatchNERService); ok {
+		return batcher.DetectEntitiesBatch(ctx, texts)
+	}
+
+	results := make([][]models.Entity, len(texts))
+	for i, text := range texts {
+		if text == "" {
+			continue
+		}
+		entities, err := ner.DetectEntities(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = entities
+	}
+	return results, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+func TestNERClient_DetectEntitiesBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/detect/batch" {
+			t.Errorf("Expected /detect/batch, got %s", r.URL.Path)
+		}
+
+		var req models.NERBatchRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Texts) != 2 {
+			t.Errorf("Expected 2 texts, got %d", len(req.Texts))
+		}
+
+		json.NewEncoder(w).Encode(models.NERBatchResponse{
+			Results: []models.NERBatchResult{
+				{Entities: []models.Entity{{Original: "a@example.com", Token: "[EMAIL_001]", Type: "EMAIL", Position: 3}}, Count: 1},
+				{Entities: []models.Entity{}, Count: 0},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := NewNERClient(server.URL)
+	results, err := client.DetectEntitiesBatch(context.Background(), []string{"hi a@example.com", "nothing here"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 2 || len(results[0]) != 1 || len(results[1]) != 0 {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+}
+
+func TestNERClient_DetectEntitiesBatchMismatchedResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.NERBatchResponse{})
+	}))
+	defer server.Close()
+
+	client := NewNERClient(server.URL)
+	if _, err := client.DetectEntitiesBatch(context.Background(), []string{"one"}); err == nil {
+		t.Error("Expected error when result count does not match")
+	}
+}
+
+type singleNER struct {
+	calls []string
+}
+
+func (m *singleNER) DetectEntities(ctx context.Context, text string) ([]models.Entity, error) {
+	m.calls = append(m.calls, text)
+	return []models.Entity{{Original: text, Token: "[X_001]"}}, nil
+}
+
+func TestDetectEntitiesBatch_FallsBackToPerTextCalls(t *testing.T) {
+	ner := &singleNER{}
+
+	results, err := DetectEntitiesBatch(context.Background(), ner, []string{"a", "", "b"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(ner.calls) != 2 {
+		t.Errorf("Expected empty texts to be skipped, got calls %v", ner.calls)
+	}
+	if len(results) != 3 || len(results[1]) != 0 || results[2][0].Original != "b" {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+}
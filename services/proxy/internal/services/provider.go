@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+const (
+	ProviderAnthropic = "anthropic"
+	ProviderOpenAI    = "openai"
+)
+
+// ErrInvalidRequest is returned when a request cannot be translated into the
+// provider's wire format. Retrying or failing over will not help.
+var ErrInvalidRequest = errors.New("request cannot be sent to LLM provider")
+
+// providerAdapter translates between SafeRoute's OpenAI-shaped models and a
+// provider's wire format.
+type providerAdapter interface {
+	path() string
+	setHeaders(header http.Header, apiKey string)
+	encodeRequest(req models.ChatCompletionRequest) ([]byte, error)
+	decodeResponse(body io.Reader) (models.ChatCompletionResponse, error)
+	newStreamDecoder() streamDecoder
+}
+
+// embeddingsAdapter is implemented by adapters for providers with an
+// OpenAI-compatible embeddings API.
+type embeddingsAdapter interface {
+	embeddingsPath() string
+}
+
+// streamDecoder turns SSE events into chunks. ok is false for events that
+// carry nothing for the client; io.EOF marks the end of the stream.
+type streamDecoder interface {
+	decode(event sseEvent) (chunk models.ChatCompletionChunk, ok bool, err error)
+}
+
+func adapterFor(provider string) (providerAdapter, error) {
+	switch provider {
+	case ProviderAnthropic:
+		return anthropicAdapter{}, nil
+	case ProviderOpenAI:
+		return openAIAdapter{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported LLM provider %q", provider)
+	}
+}
+
+type openAIAdapter struct{}
+
+func (openAIAdapter) path() string {
+	return "/v1/chat/completions"
+}
+
+func (openAIAdapter) embeddingsPath() string {
+	return "/v1/embeddings"
+}
+
+func (openAIAdapter) setHeaders(header http.Header, apiKey string) {
+	if apiKey != "" {
+		header.Set("Authorization", "Bearer "+apiKey)
+	}
+}
+
+func (openAIAdapter) encodeRequest(req models.ChatCompletionRequest) ([]byte, error) {
+	return json.Marshal(req)
+}
+
+func (openAIAdapter) decodeResponse(body io.Reader) (models.ChatCompletionResponse, error) {
+	var resp models.ChatCompletionResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return models.ChatCompletionResponse{}, err
+	}
+	return resp, nil
+}
+
+func (openAIAdapter) newStreamDecoder() streamDecoder {
+	return openAIStreamDecoder{}
+}
+
+type openAIStreamDecoder struct{}
+
+func (openAIStreamDecoder) decode(event sseEvent) (models.ChatCompletionChunk, bool, error) {
+	if event.Data == "" {
+		return models.ChatCompletionChunk{}, false, nil
+	}
+	if event.Data == "[DONE]" {
+		return models.ChatCompletionChunk{}, false, io.EOF
+	}
+
+	var chunk models.ChatCompletionChunk
+	if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+		return models.ChatCompletionChunk{}, false, err
+	}
+	return chunk, true, nil
+}
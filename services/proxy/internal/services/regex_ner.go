@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+type regexDetector struct {
+	entityType string
+	pattern    *regexp.Regexp
+	confidence float64
+	// validate reports whether a match is real; nil accepts every match.
+	// It may return a shorter prefix of the match to keep instead.
+	validate func(match string) (string, bool)
+	// standalone rejects matches that touch a letter, digit, '_' or ':',
+	// which the pattern alone cannot rule out without lookaround.
+	standalone bool
+}
+
+// RegexNER detects structured PII with regular expressions and checksums.
+// It needs no external service, so its results are deterministic and always
+// available.
+type RegexNER struct {
+	detectors []regexDetector
+}
+
+func NewRegexNER() *RegexNER {
+	return &RegexNER{
+		detectors: []regexDetector{
+			{
+				entityType: "EMAIL",
+				pattern:    regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+				confidence: 0.99,
+			},
+			{
+				entityType: "API_KEY",
+				pattern:    regexp.MustCompile(`\b(?:sk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}|AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abpr]-[A-Za-z0-9-]{10,}|AIza[0-9A-Za-z_-]{35})`),
+				confidence: 0.97,
+			},
+			{
+				entityType: "CREDIT_CARD",
+				pattern:    regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+				confidence: 0.99,
+				validate:   validCreditCard,
+			},
+			{
+				entityType: "IBAN",
+				pattern:    regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}\b`),
+				confidence: 0.99,
+				validate:   validIBAN,
+			},
+			{
+				entityType: "SSN",
+				pattern:    regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+				confidence: 0.95,
+				validate:   validSSN,
+			},
+			{
+				entityType: "PHONE",
+				pattern:    regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\)|\b\d{3})[ .-]\d{3}[ .-]\d{4}\b|\+\d{1,3}(?:[ .-]?\d{2,4}){2,5}\b`),
+				confidence: 0.9,
+				validate:   validPhone,
+			},
+			{
+				entityType: "IP_ADDRESS",
+				pattern:    regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4})*)?::(?:[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4})*)?`),
+				confidence: 0.95,
+				validate:   validIP,
+				standalone: true,
+			},
+		},
+	}
+}
+
+func (n *RegexNER) DetectEntities(ctx context.Context, text string) ([]models.Entity, error) {
+	return n.detect(text, make(map[string]int)), nil
+}
+
+func (n *RegexNER) DetectEntitiesBatch(ctx context.Context, texts []string) ([][]models.Entity, error) {
+	counters := make(map[string]int)
+	results := make([][]models.Entity, len(texts))
+	for i, text := range texts {
+		results[i] = n.detect(text, counters)
+	}
+	return results, nil
+}
+
+func (n *RegexNER) detect(text string, counters map[string]int) []models.Entity {
+	var matches []models.Entity
+	for _, d := range n.detectors {
+		for _, loc := range d.pattern.FindAllStringIndex(text, -1) {
+			match := text[loc[0]:loc[1]]
+			if d.standalone && !standsAlone(text, loc[0], loc[1]) {
+				continue
+			}
+			if d.validate != nil {
+				var ok bool
+				if match, ok = d.validate(match); !ok {
+					continue
+				}
+			}
+			matches = append(matches, models.Entity{
+				Original:   match,
+				Type:       d.entityType,
+				Position:   loc[0],
+				Confidence: d.confidence,
+			})
+		}
+	}
+
+	entities := dropOverlaps(matches)
+	for i := range entities {
+		counters[entities[i].Type]++
+		entities[i].Token = fmt.Sprintf("[%s_%03d]", entities[i].Type, counters[entities[i].Type])
+	}
+	return entities
+}
+
+// dropOverlaps keeps the longest of any overlapping matches (a card number
+// also looks like a phone number) and returns them in text order.
+func dropOverlaps(matches []models.Entity) []models.Entity {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return len(matches[i].Original) > len(matches[j].Original)
+	})
+
+	var kept []models.Entity
+	for _, m := range matches {
+		start, end := m.Position, m.Position+len(m.Original)
+		overlaps := false
+		for _, k := range kept {
+			if start < k.Position+len(k.Original) && k.Position < end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, m)
+		}
+	}
+
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].Position < kept[j].Position
+	})
+	return kept
+}
+
+// standsAlone reports whether text[start:end] is not part of a longer
+// identifier: "d::" in "std::vector" is not an address.
+func standsAlone(text string, start, end int) bool {
+	if prev, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isIdentifierRune(prev) {
+		return false
+	}
+	if next, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isIdentifierRune(next) {
+		return false
+	}
+	return true
+}
+
+func isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == ':'
+}
+
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func validCreditCard(match string) (string, bool) {
+	digits := digitsOnly(match)
+	if len(digits) < 13 || len(digits) > 19 {
+		return "", false
+	}
+	return match, luhnValid(digits)
+}
+
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validIBAN checks the ISO 13616 mod-97 checksum. The pattern may run on
+// into following uppercase words, so trailing groups are dropped until a
+// valid IBAN remains.
+func validIBAN(match string) (string, bool) {
+	for {
+		if ibanChecksumValid(strings.ReplaceAll(match, " ", "")) {
+			return match, true
+		}
+		idx := strings.LastIndex(match, " ")
+		if idx == -1 {
+			return "", false
+		}
+		match = match[:idx]
+	}
+}
+
+func ibanChecksumValid(iban string) bool {
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	rearranged := iban[4:] + iban[:4]
+	remainder := 0
+	for _, r := range rearranged {
+		var value int
+		switch {
+		case r >= '0' && r <= '9':
+			value = int(r - '0')
+			remainder = (remainder*10 + value) % 97
+		case r >= 'A' && r <= 'Z':
+			value = int(r-'A') + 10
+			remainder = (remainder*100 + value) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
+
+func validSSN(match string) (string, bool) {
+	area, group, serial := match[0:3], match[4:6], match[7:11]
+	if area == "000" || area == "666" || area[0] == '9' {
+		return "", false
+	}
+	return match, group != "00" && serial != "0000"
+}
+
+func validPhone(match string) (string, bool) {
+	n := len(digitsOnly(match))
+	return match, n >= 10 && n <= 15
+}
+
+// validIP accepts addresses that parse. A compressed IPv6 address must
+// spell out at least three groups, or two with one of four digits
+// ("fe80::1"), so that "a::b" in code is not taken for one.
+func validIP(match string) (string, bool) {
+	addr, err := netip.ParseAddr(match)
+	if err != nil {
+		return "", false
+	}
+	if addr.Is6() && strings.Contains(match, "::") {
+		groups, full := 0, false
+		for _, group := range strings.Split(match, ":") {
+			if group != "" {
+				groups++
+				full = full || len(group) == 4
+			}
+		}
+		if groups < 2 || (groups == 2 && !full) {
+			return "", false
+		}
+	}
+	return match, addr.Is4() || addr.Is6()
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saferoute/proxy/internal/models"
+)
+
+// statusOverloaded is Anthropic's "overloaded" status.
+const statusOverloaded = 529
+
+var llmAttemptsTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "llm_attempts_total",
+		Help: "Total number of LLM call attempts by target and outcome",
+	},
+	[]string{"target", "outcome"},
+)
+
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries per target before failing over.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Budget bounds the whole of a non-streaming call, every attempt and
+	// wait included, so the answer arrives before the server's write
+	// deadline. Zero means no bound.
+	Budget time.Duration
+}
+
+// LLMTarget is one place a request may be sent. An empty Model keeps the
+// model the client asked for.
+type LLMTarget struct {
+	Name    string
+	Service LLMService
+	Model   string
+}
+
+// FailoverLLM retries retryable failures against each target in turn with
+// exponential backoff, moving to the next target once one is exhausted.
+type FailoverLLM struct {
+	targets []LLMTarget
+	policy  RetryPolicy
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+func NewFailoverLLM(policy RetryPolicy, primary LLMService, fallbacks ...LLMTarget) *FailoverLLM {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	targets := append([]LLMTarget{{Name: "primary", Service: primary}}, fallbacks...)
+	return &FailoverLLM{
+		targets: targets,
+		policy:  policy,
+		sleep:   sleepContext,
+	}
+}
+
+func (f *FailoverLLM) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	ctx, cancel := f.withBudget(ctx)
+	defer cancel()
+
+	var resp models.ChatCompletionResponse
+	err := f.do(ctx, f.targets, req.Model, func(target LLMTarget, model string) error {
+		req.Model = model
+		var err error
+		resp, err = target.Service.ChatCompletion(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (f *FailoverLLM) ChatCompletionStream(ctx context.Context, req models.ChatCompletionRequest) (ChatCompletionStream, error) {
+	var stream ChatCompletionStream
+	err := f.do(ctx, f.targets, req.Model, func(target LLMTarget, model string) error {
+		streamer, ok := target.Service.(LLMStreamService)
+		if !ok {
+			return ErrStreamingUnsupported
+		}
+		req.Model = model
+		var err error
+		stream, err = streamer.ChatCompletionStream(ctx, req)
+		return err
+	})
+	return stream, err
+}
+
+// Embeddings retries the primary target only: vectors from a fallback model
+// could not be compared with those the client already has.
+func (f *FailoverLLM) Embeddings(ctx context.Context, req models.EmbeddingRequest) (models.EmbeddingResponse, error) {
+	ctx, cancel := f.withBudget(ctx)
+	defer cancel()
+
+	var resp models.EmbeddingResponse
+	err := f.do(ctx, f.targets[:1], req.Model, func(target LLMTarget, model string) error {
+		embedder, ok := target.Service.(EmbeddingService)
+		if !ok {
+			return ErrEmbeddingsUnsupported
+		}
+		req.Model = model
+		var err error
+		resp, err = embedder.Embeddings(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// ModelLabel labels model as the primary target does, or "other" when it
+// cannot.
+func (f *FailoverLLM) ModelLabel(model string) string {
+	if labeler, ok := f.targets[0].Service.(ModelLabeler); ok {
+		return labeler.ModelLabel(model)
+	}
+	return ModelLabelOther
+}
+
+// withBudget bounds ctx by the policy's Budget. Streams are not bounded:
+// they outlive any fixed deadline, and their writes extend the server's.
+func (f *FailoverLLM) withBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	if f.policy.Budget <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, f.policy.Budget)
+}
+
+// do calls each target in turn with the model to ask it for: the target's
+// own, or model when it has none.
+func (f *FailoverLLM) do(ctx context.Context, targets []LLMTarget, model string, call func(target LLMTarget, model string) error) error {
+	var lastErr error
+
+	for _, target := range targets {
+		targetModel := model
+		if target.Model != "" {
+			targetModel = target.Model
+		}
+
+		backoff := f.policy.InitialBackoff
+		for attempt := 1; attempt <= f.policy.MaxAttempts; attempt++ {
+			err := call(target, targetModel)
+			if err == nil {
+				llmAttemptsTotal.WithLabelValues(target.Name, "success").Inc()
+				return nil
+			}
+			lastErr = err
+
+			if ctx.Err() != nil {
+				llmAttemptsTotal.WithLabelValues(target.Name, interruptedOutcome(ctx)).Inc()
+				return err
+			}
+
+			if !isRetryable(err) {
+				llmAttemptsTotal.WithLabelValues(target.Name, "error").Inc()
+				if errors.Is(err, ErrStreamingUnsupported) || errors.Is(err, ErrEmbeddingsUnsupported) || errors.Is(err, ErrCircuitOpen) {
+					break
+				}
+				return err
+			}
+			llmAttemptsTotal.WithLabelValues(target.Name, "retryable_error").Inc()
+
+			if attempt == f.policy.MaxAttempts {
+				break
+			}
+
+			wait := backoff
+			var providerErr *ProviderError
+			if errors.As(err, &providerErr) && providerErr.RetryAfter > 0 {
+				// A provider asking us to wait longer than we ever would is
+				// better served by the next target.
+				if f.policy.MaxBackoff > 0 && providerErr.RetryAfter > f.policy.MaxBackoff {
+					break
+				}
+				wait = max(wait, providerErr.RetryAfter)
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+				// Waiting would spend the rest of the budget; the next
+				// target may still answer in time.
+				break
+			}
+
+			if err := f.sleep(ctx, wait); err != nil {
+				return err
+			}
+
+			backoff *= 2
+			if f.policy.MaxBackoff > 0 && backoff > f.policy.MaxBackoff {
+				backoff = f.policy.MaxBackoff
+			}
+		}
+	}
+
+	return lastErr
+}
+
+// isRetryable reports whether another attempt could succeed: transport
+// failures, rate limiting, overload and 5xx responses.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrModelNotRouted) || errors.Is(err, ErrStreamingUnsupported) || errors.Is(err, ErrEmbeddingsUnsupported) || errors.Is(err, ErrInvalidRequest) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		switch providerErr.StatusCode {
+		case http.StatusTooManyRequests, statusOverloaded:
+			return true
+		default:
+			return providerErr.StatusCode >= 500
+		}
+	}
+
+	return true
+}
+
+// interruptedOutcome labels an attempt cut short by ctx: "timeout" when the
+// budget or the caller's deadline ran out, "canceled" otherwise.
+func interruptedOutcome(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "canceled"
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
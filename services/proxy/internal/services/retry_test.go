@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/saferoute/proxy/internal/models"
+)
+
+type scriptedLLM struct {
+	errs   []error
+	calls  int
+	models []string
+}
+
+func (m *scriptedLLM) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	m.calls++
+	m.models = append(m.models, req.Model)
+	if len(m.errs) > 0 {
+		err := m.errs[0]
+		m.errs = m.errs[1:]
+		if err != nil {
+			return models.ChatCompletionResponse{}, err
+		}
+	}
+	return models.ChatCompletionResponse{ID: "ok", Model: req.Model}, nil
+}
+
+func (m *scriptedLLM) Embeddings(ctx context.Context, req models.EmbeddingRequest) (models.EmbeddingResponse, error) {
+	resp, err := m.ChatCompletion(ctx, models.ChatCompletionRequest{Model: req.Model})
+	return models.EmbeddingResponse{Model: resp.Model}, err
+}
+
+func newTestFailover(primary LLMService, fallbacks ...LLMTarget) (*FailoverLLM, *[]time.Duration) {
+	f := NewFailoverLLM(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}, primary, fallbacks...)
+
+	var sleeps []time.Duration
+	f.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	return f, &sleeps
+}
+
+func TestFailoverLLM_RetriesWithExponentialBackoff(t *testing.T) {
+	primary := &scriptedLLM{errs: []error{
+		&ProviderError{StatusCode: http.StatusBadGateway},
+		&ProviderError{StatusCode: statusOverloaded},
+	}}
+	f, sleeps := newTestFailover(primary)
+
+	before := testutil.ToFloat64(llmAttemptsTotal.WithLabelValues("primary", "retryable_error"))
+
+	resp, err := f.ChatCompletion(context.Background(), models.ChatCompletionRequest{Model: "claude-3"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.ID != "ok" || primary.calls != 3 {
+		t.Errorf("Expected success on third attempt, got %d calls", primary.calls)
+	}
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+	if len(*sleeps) != len(want) || (*sleeps)[0] != want[0] || (*sleeps)[1] != want[1] {
+		t.Errorf("Expected backoff %v, got %v", want, *sleeps)
+	}
+
+	after := testutil.ToFloat64(llmAttemptsTotal.WithLabelValues("primary", "retryable_error"))
+	if after-before != 2 {
+		t.Errorf("Expected 2 retryable attempts recorded, got %v", after-before)
+	}
+}
+
+func TestFailoverLLM_RespectsRetryAfter(t *testing.T) {
+	primary := &scriptedLLM{errs: []error{
+		&ProviderError{StatusCode: http.StatusTooManyRequests, RetryAfter: 700 * time.Millisecond},
+	}}
+	f, sleeps := newTestFailover(primary)
+
+	if _, err := f.ChatCompletion(context.Background(), models.ChatCompletionRequest{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] != 700*time.Millisecond {
+		t.Errorf("Expected to wait for Retry-After, got %v", *sleeps)
+	}
+}
+
+func TestFailoverLLM_FailsOverToFallbackModel(t *testing.T) {
+	overloaded := &ProviderError{StatusCode: statusOverloaded}
+	primary := &scriptedLLM{errs: []error{overloaded, overloaded, overloaded}}
+	fallback := &scriptedLLM{}
+	f, _ := newTestFailover(primary, LLMTarget{Name: "gpt-4o-mini", Service: fallback, Model: "gpt-4o-mini"})
+
+	resp, err := f.ChatCompletion(context.Background(), models.ChatCompletionRequest{Model: "claude-3"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if primary.calls != 3 || fallback.calls != 1 {
+		t.Errorf("Expected 3 primary and 1 fallback calls, got %d and %d", primary.calls, fallback.calls)
+	}
+	if resp.Model != "gpt-4o-mini" {
+		t.Errorf("Expected fallback model in response, got %s", resp.Model)
+	}
+}
+
+func TestFailoverLLM_EmbeddingsDoNotFailOver(t *testing.T) {
+	overloaded := &ProviderError{StatusCode: statusOverloaded}
+	primary := &scriptedLLM{errs: []error{overloaded, overloaded, overloaded}}
+	fallback := &scriptedLLM{}
+	f, _ := newTestFailover(primary, LLMTarget{Name: "other-embedding", Service: fallback, Model: "other-embedding"})
+
+	_, err := f.Embeddings(context.Background(), models.EmbeddingRequest{Model: "text-embedding-3-small"})
+	if !errors.Is(err, overloaded) {
+		t.Errorf("Expected the primary's error, got %v", err)
+	}
+	if primary.calls != 3 || fallback.calls != 0 {
+		t.Errorf("Expected 3 primary and no fallback calls, got %d and %d", primary.calls, fallback.calls)
+	}
+}
+
+func TestFailoverLLM_LongRetryAfterFailsOverImmediately(t *testing.T) {
+	primary := &scriptedLLM{errs: []error{
+		&ProviderError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute},
+	}}
+	fallback := &scriptedLLM{}
+	f, sleeps := newTestFailover(primary, LLMTarget{Name: "backup", Service: fallback})
+
+	if _, err := f.ChatCompletion(context.Background(), models.ChatCompletionRequest{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if primary.calls != 1 || fallback.calls != 1 || len(*sleeps) != 0 {
+		t.Errorf("Expected immediate failover, got %d/%d calls and sleeps %v", primary.calls, fallback.calls, *sleeps)
+	}
+}
+
+func TestFailoverLLM_DoesNotRetryClientErrors(t *testing.T) {
+	badRequest := &ProviderError{StatusCode: http.StatusBadRequest}
+	primary := &scriptedLLM{errs: []error{badRequest}}
+	fallback := &scriptedLLM{}
+	f, _ := newTestFailover(primary, LLMTarget{Name: "backup", Service: fallback})
+
+	_, err := f.ChatCompletion(context.Background(), models.ChatCompletionRequest{})
+	if !errors.Is(err, badRequest) {
+		t.Errorf("Expected the 400 to be returned, got %v", err)
+	}
+	if primary.calls != 1 || fallback.calls != 0 {
+		t.Errorf("Expected a single attempt, got %d/%d calls", primary.calls, fallback.calls)
+	}
+}
+
+func TestFailoverLLM_StopsWhenContextCancelled(t *testing.T) {
+	primary := &scriptedLLM{errs: []error{&ProviderError{StatusCode: http.StatusServiceUnavailable}}}
+	f := NewFailoverLLM(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}, primary)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := f.ChatCompletion(ctx, models.ChatCompletionRequest{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFailoverLLM_RecordsInterruptedAttempt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	primary := &contextLLM{check: func(context.Context) { cancel() }}
+	f := NewFailoverLLM(RetryPolicy{MaxAttempts: 3}, primary)
+
+	before := testutil.ToFloat64(llmAttemptsTotal.WithLabelValues("primary", "canceled"))
+
+	if _, err := f.ChatCompletion(ctx, models.ChatCompletionRequest{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	after := testutil.ToFloat64(llmAttemptsTotal.WithLabelValues("primary", "canceled"))
+	if after-before != 1 {
+		t.Errorf("Expected the interrupted attempt recorded, got %v", after-before)
+	}
+}
+
+func TestFailoverLLM_DoesNotWaitPastBudget(t *testing.T) {
+	primary := &scriptedLLM{errs: []error{&ProviderError{StatusCode: http.StatusServiceUnavailable}}}
+	fallback := &scriptedLLM{}
+	f := NewFailoverLLM(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, Budget: time.Minute}, primary,
+		LLMTarget{Name: "backup", Service: fallback})
+
+	if _, err := f.ChatCompletion(context.Background(), models.ChatCompletionRequest{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if primary.calls != 1 || fallback.calls != 1 {
+		t.Errorf("Expected immediate failover instead of a wait past the budget, got %d/%d calls", primary.calls, fallback.calls)
+	}
+}
+
+func TestFailoverLLM_BudgetBoundsCall(t *testing.T) {
+	var deadline time.Time
+	primary := &contextLLM{check: func(ctx context.Context) { deadline, _ = ctx.Deadline() }}
+	f := NewFailoverLLM(RetryPolicy{MaxAttempts: 1, Budget: time.Minute}, primary)
+
+	f.ChatCompletion(context.Background(), models.ChatCompletionRequest{})
+	if remaining := time.Until(deadline); remaining <= 0 || remaining > time.Minute {
+		t.Errorf("Expected a deadline within the budget, got %v", remaining)
+	}
+}
+
+type contextLLM struct {
+	check func(ctx context.Context)
+}
+
+func (m *contextLLM) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	m.check(ctx)
+	return models.ChatCompletionResponse{}, ctx.Err()
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{now.Add(5 * time.Second).Format(http.TimeFormat), 5 * time.Second},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+var (
+	ErrModelNotRouted        = errors.New("no LLM provider configured for model")
+	ErrStreamingUnsupported  = errors.New("LLM provider does not support streaming")
+	ErrEmbeddingsUnsupported = errors.New("LLM provider does not support embeddings")
+)
+
+type llmRoute struct {
+	pattern string
+	service LLMService
+}
+
+// LLMRouter dispatches each request to an LLMService chosen by model name.
+type LLMRouter struct {
+	routes   []llmRoute
+	fallback LLMService
+}
+
+// NewLLMRouter creates a router that sends unmatched models to fallback. A
+// nil fallback makes unmatched models fail with ErrModelNotRouted.
+func NewLLMRouter(fallback LLMService) *LLMRouter {
+	return &LLMRouter{fallback: fallback}
+}
+
+// Handle routes models matching pattern to service. Patterns are exact model
+// names or prefixes ending in "*"; the most specific pattern wins. For a
+// namespaced pattern like "local/*" the namespace is stripped upstream.
+func (r *LLMRouter) Handle(pattern string, service LLMService) {
+	r.routes = append(r.routes, llmRoute{pattern: pattern, service: service})
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].pattern) > len(r.routes[j].pattern)
+	})
+}
+
+func (r *LLMRouter) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	service, upstreamModel, err := r.resolve(req.Model)
+	if err != nil {
+		return models.ChatCompletionResponse{}, err
+	}
+
+	requested := req.Model
+	req.Model = upstreamModel
+
+	resp, err := service.ChatCompletion(ctx, req)
+	if err != nil {
+		return models.ChatCompletionResponse{}, err
+	}
+	if upstreamModel != requested {
+		resp.Model = requested
+	}
+	return resp, nil
+}
+
+func (r *LLMRouter) ChatCompletionStream(ctx context.Context, req models.ChatCompletionRequest) (ChatCompletionStream, error) {
+	service, upstreamModel, err := r.resolve(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	streamer, ok := service.(LLMStreamService)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStreamingUnsupported, req.Model)
+	}
+
+	requested := req.Model
+	req.Model = upstreamModel
+
+	stream, err := streamer.ChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if upstreamModel != requested {
+		stream = &renamedStream{ChatCompletionStream: stream, model: requested}
+	}
+	return stream, nil
+}
+
+func (r *LLMRouter) Embeddings(ctx context.Context, req models.EmbeddingRequest) (models.EmbeddingResponse, error) {
+	service, upstreamModel, err := r.resolve(req.Model)
+	if err != nil {
+		return models.EmbeddingResponse{}, err
+	}
+
+	embedder, ok := service.(EmbeddingService)
+	if !ok {
+		return models.EmbeddingResponse{}, fmt.Errorf("%w: %s", ErrEmbeddingsUnsupported, req.Model)
+	}
+
+	requested := req.Model
+	req.Model = upstreamModel
+
+	resp, err := embedder.Embeddings(ctx, req)
+	if err != nil {
+		return models.EmbeddingResponse{}, err
+	}
+	if upstreamModel != requested {
+		resp.Model = requested
+	}
+	return resp, nil
+}
+
+// Labels returned by ModelLabel for models no pattern matches.
+const (
+	ModelLabelDefault  = "default"
+	ModelLabelUnrouted = "unrouted"
+	// ModelLabelOther labels every model when the LLM service cannot tell
+	// them apart.
+	ModelLabelOther = "other"
+)
+
+// ModelLabel returns the pattern that routes model, "default" when it goes
+// to the fallback service, or "unrouted".
+func (r *LLMRouter) ModelLabel(model string) string {
+	for _, route := range r.routes {
+		if _, ok := matchModel(route.pattern, model); ok {
+			return route.pattern
+		}
+	}
+	if r.fallback != nil {
+		return ModelLabelDefault
+	}
+	return ModelLabelUnrouted
+}
+
+func (r *LLMRouter) resolve(model string) (LLMService, string, error) {
+	for _, route := range r.routes {
+		if upstreamModel, ok := matchModel(route.pattern, model); ok {
+			return route.service, upstreamModel, nil
+		}
+	}
+
+	if r.fallback != nil {
+		return r.fallback, model, nil
+	}
+	return nil, "", fmt.Errorf("%w: %q", ErrModelNotRouted, model)
+}
+
+func matchModel(pattern, model string) (string, bool) {
+	prefix, wildcard := strings.CutSuffix(pattern, "*")
+	if !wildcard {
+		return model, model == pattern
+	}
+	if !strings.HasPrefix(model, prefix) || len(model) == len(prefix) {
+		return "", false
+	}
+	if strings.HasSuffix(prefix, "/") {
+		return strings.TrimPrefix(model, prefix), true
+	}
+	return model, true
+}
+
+type renamedStream struct {
+	ChatCompletionStream
+	model string
+}
+
+func (s *renamedStream) Recv() (models.ChatCompletionChunk, error) {
+	chunk, err := s.ChatCompletionStream.Recv()
+	if err == nil {
+		chunk.Model = s.model
+	}
+	return chunk, err
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+)
+
+type recordingLLM struct {
+	name      string
+	lastModel string
+}
+
+func (m *recordingLLM) ChatCompletion(ctx context.Context, req models.ChatCompletionRequest) (models.ChatCompletionResponse, error) {
+	m.lastModel = req.Model
+	return models.ChatCompletionResponse{ID: m.name, Model: req.Model}, nil
+}
+
+type recordingStreamLLM struct {
+	recordingLLM
+}
+
+func (m *recordingStreamLLM) ChatCompletionStream(ctx context.Context, req models.ChatCompletionRequest) (ChatCompletionStream, error) {
+	m.lastModel = req.Model
+	return &sliceStream{chunks: []models.ChatCompletionChunk{{ID: m.name, Model: req.Model}}}, nil
+}
+
+type recordingEmbeddingLLM struct {
+	recordingLLM
+}
+
+func (m *recordingEmbeddingLLM) Embeddings(ctx context.Context, req models.EmbeddingRequest) (models.EmbeddingResponse, error) {
+	m.lastModel = req.Model
+	return models.EmbeddingResponse{Model: req.Model}, nil
+}
+
+type sliceStream struct {
+	chunks []models.ChatCompletionChunk
+}
+
+func (s *sliceStream) Recv() (models.ChatCompletionChunk, error) {
+	if len(s.chunks) == 0 {
+		return models.ChatCompletionChunk{}, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *sliceStream) Close() error { return nil }
+
+func TestLLMRouter_DispatchesByModel(t *testing.T) {
+	anthropic := &recordingLLM{name: "anthropic"}
+	openai := &recordingLLM{name: "openai"}
+	local := &recordingLLM{name: "local"}
+	fallback := &recordingLLM{name: "fallback"}
+
+	router := NewLLMRouter(fallback)
+	router.Handle("claude-*", anthropic)
+	router.Handle("gpt-*", openai)
+	router.Handle("gpt-4o-special", anthropic)
+	router.Handle("local/*", local)
+
+	tests := []struct {
+		model         string
+		wantProvider  string
+		wantUpstream  string
+		wantRespModel string
+	}{
+		{"claude-3-5-sonnet", "anthropic", "claude-3-5-sonnet", "claude-3-5-sonnet"},
+		{"gpt-4o", "openai", "gpt-4o", "gpt-4o"},
+		{"gpt-4o-special", "anthropic", "gpt-4o-special", "gpt-4o-special"},
+		{"local/llama3", "local", "llama3", "local/llama3"},
+		{"mistral-large", "fallback", "mistral-large", "mistral-large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			resp, err := router.ChatCompletion(context.Background(), models.ChatCompletionRequest{Model: tt.model})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp.ID != tt.wantProvider {
+				t.Errorf("Expected provider %s, got %s", tt.wantProvider, resp.ID)
+			}
+			if resp.Model != tt.wantRespModel {
+				t.Errorf("Expected response model %s, got %s", tt.wantRespModel, resp.Model)
+			}
+		})
+	}
+
+	if local.lastModel != "llama3" {
+		t.Errorf("Expected namespace to be stripped upstream, got %s", local.lastModel)
+	}
+}
+
+func TestLLMRouter_UnroutedModel(t *testing.T) {
+	router := NewLLMRouter(nil)
+	router.Handle("claude-*", &recordingLLM{})
+
+	_, err := router.ChatCompletion(context.Background(), models.ChatCompletionRequest{Model: "gpt-4o"})
+	if !errors.Is(err, ErrModelNotRouted) {
+		t.Errorf("Expected ErrModelNotRouted, got %v", err)
+	}
+
+	_, err = router.ChatCompletion(context.Background(), models.ChatCompletionRequest{Model: "claude-"})
+	if !errors.Is(err, ErrModelNotRouted) {
+		t.Errorf("Expected bare prefix not to match, got %v", err)
+	}
+}
+
+func TestLLMRouter_Stream(t *testing.T) {
+	local := &recordingStreamLLM{recordingLLM{name: "local"}}
+
+	router := NewLLMRouter(&recordingLLM{name: "fallback"})
+	router.Handle("local/*", local)
+
+	stream, err := router.ChatCompletionStream(context.Background(), models.ChatCompletionRequest{Model: "local/llama3"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	chunk, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if chunk.Model != "local/llama3" || local.lastModel != "llama3" {
+		t.Errorf("Expected upstream llama3 and client-facing local/llama3, got %s and %s", local.lastModel, chunk.Model)
+	}
+
+	_, err = router.ChatCompletionStream(context.Background(), models.ChatCompletionRequest{Model: "other"})
+	if !errors.Is(err, ErrStreamingUnsupported) {
+		t.Errorf("Expected ErrStreamingUnsupported, got %v", err)
+	}
+}
+
+func TestLLMRouter_Embeddings(t *testing.T) {
+	local := &recordingEmbeddingLLM{recordingLLM{name: "local"}}
+
+	router := NewLLMRouter(&recordingLLM{name: "fallback"})
+	router.Handle("local/*", local)
+
+	resp, err := router.Embeddings(context.Background(), models.EmbeddingRequest{Model: "local/nomic-embed-text"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Model != "local/nomic-embed-text" || local.lastModel != "nomic-embed-text" {
+		t.Errorf("Expected upstream nomic-embed-text and client-facing local/nomic-embed-text, got %s and %s", local.lastModel, resp.Model)
+	}
+
+	_, err = router.Embeddings(context.Background(), models.EmbeddingRequest{Model: "other"})
+	if !errors.Is(err, ErrEmbeddingsUnsupported) {
+		t.Errorf("Expected ErrEmbeddingsUnsupported, got %v", err)
+	}
+}
+
+func TestLLMRouter_ModelLabel(t *testing.T) {
+	router := NewLLMRouter(nil)
+	router.Handle("claude-*", &recordingLLM{name: "anthropic"})
+	router.Handle("local/*", &recordingLLM{name: "local"})
+
+	tests := map[string]string{
+		"claude-3-opus":   "claude-*",
+		"local/anything1": "local/*",
+		"gpt-4o":          ModelLabelUnrouted,
+	}
+	for model, want := range tests {
+		if got := router.ModelLabel(model); got != want {
+			t.Errorf("ModelLabel(%q): expected %q, got %q", model, want, got)
+		}
+	}
+
+	if got := NewLLMRouter(&recordingLLM{}).ModelLabel("gpt-4o"); got != ModelLabelDefault {
+		t.Errorf("Expected %q for the fallback, got %q", ModelLabelDefault, got)
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+type sseReader struct {
+	scanner *bufio.Scanner
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &sseReader{scanner: scanner}
+}
+
+// Next returns the next dispatched event, or io.EOF once the stream ends.
+func (r *sseReader) Next() (sseEvent, error) {
+	var event sseEvent
+	var data []string
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+
+		if line == "" {
+			if len(data) == 0 && event.Event == "" {
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			return event, nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return sseEvent{}, err
+	}
+
+	if len(data) > 0 {
+		event.Data = strings.Join(data, "\n")
+		return event, nil
+	}
+
+	return sseEvent{}, io.EOF
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/tracing"
+	"github.com/saferoute/proxy/internal/tracing/tracingtest"
+)
+
+// The NER service and vault join the trace; LLM providers are third parties
+// and get a client span but no trace headers.
+func TestClients_PropagateTraceContext(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	traceparents := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents[r.URL.Path] = r.Header.Get("traceparent")
+		switch r.URL.Path {
+		case "/detect":
+			json.NewEncoder(w).Encode(models.NERResponse{})
+		case "/retrieve/req-1":
+			json.NewEncoder(w).Encode(models.VaultRetrieveResponse{})
+		case "/v1/chat/completions":
+			json.NewEncoder(w).Encode(models.ChatCompletionResponse{ID: "chatcmpl-1"})
+		}
+	}))
+	defer server.Close()
+
+	ctx, parent := tracing.Start(context.Background(), "request")
+	defer parent.End()
+
+	if _, err := NewNERClient(server.URL).DetectEntities(ctx, "hello"); err != nil {
+		t.Fatalf("NER: unexpected error: %v", err)
+	}
+	vault := NewVaultClient(server.URL)
+	if err := vault.StoreEntities(ctx, "req-1", nil); err != nil {
+		t.Fatalf("Vault store: unexpected error: %v", err)
+	}
+	if _, err := vault.GetEntities(ctx, "req-1"); err != nil {
+		t.Fatalf("Vault retrieve: unexpected error: %v", err)
+	}
+	llm, _ := NewLLMClient(ProviderOpenAI, server.URL, "test-key")
+	if _, err := llm.ChatCompletion(ctx, models.ChatCompletionRequest{Model: "gpt-4o"}); err != nil {
+		t.Fatalf("LLM: unexpected error: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	for _, tc := range []struct {
+		span, path string
+		propagated bool
+	}{
+		{"ner POST", "/detect", true},
+		{"vault POST", "/store", true},
+		{"vault GET", "/retrieve/req-1", true},
+		{"openai POST", "/v1/chat/completions", false},
+	} {
+		span, ok := tracingtest.Find(spans, tc.span)
+		if !ok {
+			t.Errorf("Expected a %q span", tc.span)
+			continue
+		}
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s: expected the caller's span as parent", tc.span)
+		}
+		want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+		if !tc.propagated {
+			want = ""
+		}
+		if got := traceparents[tc.path]; got != want {
+			t.Errorf("%s: expected traceparent %q, got %q", tc.path, want, got)
+		}
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/saferoute/proxy/internal/models"
+	"github.com/saferoute/proxy/internal/tracing"
 )
 
 type VaultClient struct {
@@ -20,7 +22,8 @@ func NewVaultClient(baseURL string) *VaultClient {
 	return &VaultClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: tracing.NewTransport("vault", nil),
 		},
 	}
 }
@@ -50,14 +53,14 @@ func (c *VaultClient) StoreEntities(ctx context.Context, requestID string, entit
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("vault service returned status %d", resp.StatusCode)
+		return &StatusError{Service: "vault", StatusCode: resp.StatusCode}
 	}
 
 	return nil
 }
 
 func (c *VaultClient) GetEntities(ctx context.Context, requestID string) ([]models.Entity, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/retrieve/"+requestID, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/retrieve/"+url.PathEscape(requestID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -69,7 +72,7 @@ func (c *VaultClient) GetEntities(ctx context.Context, requestID string) ([]mode
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("vault service returned status %d", resp.StatusCode)
+		return nil, &StatusError{Service: "vault", StatusCode: resp.StatusCode}
 	}
 
 	var vaultResp models.VaultRetrieveResponse
@@ -0,0 +1,86 @@
+// Package tracing configures OpenTelemetry for the proxy and wraps the
+// pieces handlers and service clients need: stage spans, client spans for
+// outbound HTTP calls and W3C trace context propagation.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/saferoute/proxy"
+
+// Exporters accepted by Setup.
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// Setup installs the W3C trace context propagator and, unless exporter is
+// "none", a tracer provider that batches spans to an OTLP/HTTP collector.
+// The collector endpoint, headers and sampler come from the standard
+// OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER variables. The returned
+// function flushes pending spans and must be called on shutdown.
+func Setup(ctx context.Context, exporter, serviceName string) (func(context.Context) error, error) {
+	// Baggage is deliberately not propagated: clients can put anything in
+	// it, PII included, and it would be forwarded to every downstream
+	// service.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unsupported traces exporter %q", exporter)
+	}
+
+	spanExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the proxy's tracer from the global provider, so spans go
+// wherever Setup (or a test) pointed it.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start begins an internal span for one processing stage.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,143 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/saferoute/proxy/internal/tracing/tracingtest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTransport_InjectsTraceparent(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ctx, parent := Start(context.Background(), "stage")
+	client := &http.Client{Transport: NewTransport("ner", nil)}
+	req, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/detect", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	parent.End()
+
+	span, ok := tracingtest.Find(exporter.GetSpans(), "ner POST")
+	if !ok {
+		t.Fatalf("Expected a client span, got %v", exporter.GetSpans())
+	}
+	if span.SpanKind != trace.SpanKindClient {
+		t.Errorf("Expected client span kind, got %v", span.SpanKind)
+	}
+	if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected the client span to be a child of the stage span")
+	}
+
+	want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("Expected traceparent %q, got %q", want, traceparent)
+	}
+}
+
+func TestExternalTransport_InjectsNothing(t *testing.T) {
+	tracingtest.Record(t)
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+
+	ctx, parent := Start(context.Background(), "stage")
+	defer parent.End()
+	client := &http.Client{Transport: NewExternalTransport("openai", nil)}
+	req, _ := http.NewRequestWithContext(ctx, "POST", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+
+	if header.Get("traceparent") != "" || header.Get("baggage") != "" {
+		t.Errorf("Expected no trace headers, got %v", header)
+	}
+}
+
+func TestTransport_SpanLastsUntilBodyClosed(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: chunk\n\n"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewTransport("openai", nil)}
+	resp, err := client.Post(server.URL+"/v1/chat/completions", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(exporter.GetSpans()) != 0 {
+		t.Error("Expected the span to stay open while the body is unread")
+	}
+	resp.Body.Close()
+	if len(exporter.GetSpans()) != 1 {
+		t.Errorf("Expected 1 span after closing the body, got %d", len(exporter.GetSpans()))
+	}
+}
+
+func TestTransport_ErrorStatus(t *testing.T) {
+	exporter := tracingtest.Record(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewTransport("vault", nil)}
+	resp, err := client.Get(server.URL + "/retrieve/abc")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+
+	span, ok := tracingtest.Find(exporter.GetSpans(), "vault GET")
+	if !ok {
+		t.Fatal("Expected a client span")
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("Expected error status for a 502, got %v", span.Status.Code)
+	}
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), "zipkin", "saferoute-proxy"); err == nil {
+		t.Error("Expected an error for an unsupported exporter")
+	}
+}
+
+func TestSetup_None(t *testing.T) {
+	shutdown, err := Setup(context.Background(), ExporterNone, "saferoute-proxy")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error on shutdown, got %v", err)
+	}
+	if fields := otel.GetTextMapPropagator().Fields(); slices.Contains(fields, "baggage") {
+		t.Errorf("Expected baggage not to be propagated, got fields %v", fields)
+	}
+}
@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Transport starts a client span for every request sent through it and
+// injects the W3C traceparent header, so downstream services join the
+// caller's trace. The span stays open until the response body is closed,
+// which makes streamed responses count their full duration.
+type Transport struct {
+	// Peer names the downstream service on each span, e.g. "ner".
+	Peer string
+	// Base sends the request; http.DefaultTransport when nil.
+	Base http.RoundTripper
+	// External skips injecting trace headers, for third parties that have
+	// no business seeing our trace IDs.
+	External bool
+}
+
+// NewTransport wraps base with client spans named after peer.
+func NewTransport(peer string, base http.RoundTripper) *Transport {
+	return &Transport{Peer: peer, Base: base}
+}
+
+// NewExternalTransport is NewTransport for a third-party peer: the request
+// is traced here but carries no trace headers.
+func NewExternalTransport(peer string, base http.RoundTripper) *Transport {
+	return &Transport{Peer: peer, Base: base, External: true}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := Tracer().Start(req.Context(), t.Peer+" "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("peer.service", t.Peer),
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.URLPath(req.URL.Path),
+		),
+	)
+
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(ctx)
+	if !t.External {
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	if resp.Body == nil || resp.Body == http.NoBody {
+		span.End()
+		return resp, nil
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// spanBody ends its span once the body is drained, fails or is closed.
+type spanBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF):
+		b.end(nil)
+	default:
+		b.end(err)
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end(nil)
+	return err
+}
+
+func (b *spanBody) end(err error) {
+	b.once.Do(func() { End(b.span, err) })
+}